@@ -0,0 +1,72 @@
+package genailib
+
+import "fmt"
+
+// Artifact is a media file produced by a provider or a processing step. It
+// carries the content in Data, a location in URL, or both.
+type Artifact struct {
+	Data     []byte `json:"-"`
+	URL      string `json:"url,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// Seed is the seed the artifact was generated with, when known.
+	Seed *int64 `json:"seed,omitempty"`
+	// Media describes the streams of videos made by processing steps.
+	Media *MediaInfo `json:"media,omitempty"`
+}
+
+// ImageInput is an image passed to an edit. Exactly one of Data, URL or
+// Object is set; Object names a file in the configured Storage.
+type ImageInput struct {
+	Data     []byte
+	URL      string
+	Object   string
+	MIMEType string
+}
+
+// imageInputs converts the values accepted by Image.Edit into ImageInputs.
+// Strings are URLs, or data URIs, and artifacts are used by content when
+// they carry it.
+func imageInputs(v any) ([]ImageInput, error) {
+	switch in := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return []ImageInput{{Data: in}}, nil
+	case string:
+		return []ImageInput{{URL: in}}, nil
+	case ImageInput:
+		return []ImageInput{in}, nil
+	case *ImageInput:
+		return []ImageInput{*in}, nil
+	case Artifact:
+		return []ImageInput{{Data: in.Data, URL: in.URL, MIMEType: in.MIMEType}}, nil
+	case *Artifact:
+		return []ImageInput{{Data: in.Data, URL: in.URL, MIMEType: in.MIMEType}}, nil
+	case [][]byte:
+		return collectInputs(in)
+	case []string:
+		return collectInputs(in)
+	case []ImageInput:
+		return in, nil
+	case []Artifact:
+		return collectInputs(in)
+	case []any:
+		return collectInputs(in)
+	default:
+		return nil, fmt.Errorf("%w: unsupported image input %T", ErrInvalidParameters, v)
+	}
+}
+
+func collectInputs[T any](items []T) ([]ImageInput, error) {
+	var inputs []ImageInput
+	for _, item := range items {
+		in, err := imageInputs(item)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, in...)
+	}
+	return inputs, nil
+}
@@ -0,0 +1,398 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AudioRole tells MixAudio how a track takes part in ducking.
+type AudioRole string
+
+const (
+	// AudioMusic tracks, such as a music bed, are ducked under speech.
+	AudioMusic AudioRole = "music"
+	// AudioVoice tracks, such as a voice-over, trigger ducking.
+	AudioVoice AudioRole = "voice"
+)
+
+// AudioTrack is an audio file mixed into a video by MixAudio.
+type AudioTrack struct {
+	Audio []byte
+	// Role is AudioMusic by default.
+	Role AudioRole
+	// Volume scales the track. Zero means 1, the track's own level.
+	Volume float64
+	// Start delays the track relative to the start of the video.
+	Start time.Duration
+	// FadeIn and FadeOut fade the track in after Start and out before it
+	// ends.
+	FadeIn  time.Duration
+	FadeOut time.Duration
+	// Loop repeats the track until the video ends.
+	Loop bool
+}
+
+// Ducking lowers music while a voice track is speaking, using ffmpeg's
+// sidechaincompress filter. Zero fields take the defaults below.
+type Ducking struct {
+	// Threshold is the voice level, between 0 and 1, above which music is
+	// lowered. Defaults to 0.05.
+	Threshold float64
+	// Ratio is the compression ratio between 1 and 20, 8 by default.
+	Ratio float64
+	// Attack and Release are how quickly music is lowered and restored,
+	// 20ms and 400ms by default.
+	Attack  time.Duration
+	Release time.Duration
+}
+
+// MixOptions controls MixAudio.
+type MixOptions struct {
+	// KeepOriginal mixes the video's own audio, if any, with the tracks
+	// instead of replacing it.
+	KeepOriginal bool
+	// OriginalVolume scales the video's own audio. Zero means 1.
+	OriginalVolume float64
+	// Ducking, when set, lowers the music tracks and the kept original audio
+	// under the voice tracks.
+	Ducking *Ducking
+}
+
+func (d Ducking) withDefaults() (Ducking, error) {
+	if d.Threshold == 0 {
+		d.Threshold = 0.05
+	}
+	if d.Ratio == 0 {
+		d.Ratio = 8
+	}
+	if d.Attack == 0 {
+		d.Attack = 20 * time.Millisecond
+	}
+	if d.Release == 0 {
+		d.Release = 400 * time.Millisecond
+	}
+	if d.Threshold < 0.001 || d.Threshold > 1 {
+		return d, fmt.Errorf("%w: ducking threshold must be between 0.001 and 1", ErrInvalidParameters)
+	}
+	if d.Ratio < 1 || d.Ratio > 20 {
+		return d, fmt.Errorf("%w: ducking ratio must be between 1 and 20", ErrInvalidParameters)
+	}
+	if d.Attack < 0 || d.Attack > 2*time.Second || d.Release < 0 || d.Release > 9*time.Second {
+		return d, fmt.Errorf("%w: ducking attack must be at most 2s and release at most 9s", ErrInvalidParameters)
+	}
+	return d, nil
+}
+
+// mixInput is an AudioTrack with the probed length of its file.
+type mixInput struct {
+	AudioTrack
+	duration time.Duration
+}
+
+// MixAudio mixes audio tracks into a video, e.g. a music bed and a
+// voice-over, each with its own volume, start offset and fades. The video
+// stream is copied and the result keeps the video's length. ffmpeg and
+// ffprobe must be installed and accessible on the system PATH.
+func MixAudio(video []byte, tracks []AudioTrack, opts MixOptions) ([]byte, error) {
+	return defaultFFmpeg.mixAudio(context.Background(), video, tracks, opts)
+}
+
+// MixAudio is the package level MixAudio for the video read from r,
+// writing the result to w.
+func (f *FFmpeg) MixAudio(ctx context.Context, r io.Reader, w io.Writer, tracks []AudioTrack, opts MixOptions) error {
+	opts, err := checkMix(tracks, opts)
+	if err != nil {
+		return err
+	}
+	j, err := f.newJob(ctx, "mixaudio")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	in, err := j.spool("input.mp4", r)
+	if err != nil {
+		return err
+	}
+	out := j.path("output.mp4")
+	if err := j.mixAudio(in, out, tracks, opts); err != nil {
+		return err
+	}
+	return f.copyOutput(out, w)
+}
+
+// MixAudioFile is MixAudio for the video file at video, writing the result
+// to out.
+func (f *FFmpeg) MixAudioFile(ctx context.Context, video, out string, tracks []AudioTrack, opts MixOptions) error {
+	opts, err := checkMix(tracks, opts)
+	if err != nil {
+		return err
+	}
+	if err := f.checkInput(video); err != nil {
+		return err
+	}
+	j, err := f.newJob(ctx, "mixaudio")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	if err := j.mixAudio(video, out, tracks, opts); err != nil {
+		return err
+	}
+	return f.checkOutput(out)
+}
+
+// mixAudio is MixAudio for videos held in memory.
+func (f *FFmpeg) mixAudio(ctx context.Context, video []byte, tracks []AudioTrack, opts MixOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.MixAudio(ctx, bytes.NewReader(video), &buf, tracks, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkMix validates tracks and opts, filling in the ducking defaults.
+func checkMix(tracks []AudioTrack, opts MixOptions) (MixOptions, error) {
+	if len(tracks) == 0 && !opts.KeepOriginal {
+		return opts, fmt.Errorf("%w: no audio tracks to mix", ErrInvalidParameters)
+	}
+	for i, t := range tracks {
+		if err := checkAudioTrack(t); err != nil {
+			return opts, fmt.Errorf("track %d: %w", i+1, err)
+		}
+	}
+	if opts.OriginalVolume < 0 {
+		return opts, fmt.Errorf("%w: original volume must not be negative", ErrInvalidParameters)
+	}
+	if opts.Ducking != nil {
+		d, err := opts.Ducking.withDefaults()
+		if err != nil {
+			return opts, err
+		}
+		opts.Ducking = &d
+	}
+	return opts, nil
+}
+
+// mixAudio mixes tracks into the video at vidFile, writing outFile.
+func (j *job) mixAudio(vidFile, outFile string, tracks []AudioTrack, opts MixOptions) error {
+	info, err := j.probe(vidFile, "video", true, false)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-i", vidFile}
+	inputs := make([]mixInput, len(tracks))
+	for i, t := range tracks {
+		name := fmt.Sprintf("track %d", i+1)
+		path, err := j.write(fmt.Sprintf("track%d", i), t.Audio)
+		if err != nil {
+			return err
+		}
+		trackInfo, err := j.probe(path, name, false, true)
+		if err != nil {
+			return err
+		}
+		if t.Start >= info.Duration {
+			return fmt.Errorf("%w: %s starts after the video ends", ErrInvalidParameters, name)
+		}
+		if t.Loop {
+			args = append(args, "-stream_loop", "-1")
+		}
+		args = append(args, "-i", path)
+		inputs[i] = mixInput{AudioTrack: t, duration: trackInfo.Duration}
+	}
+
+	var original string
+	if opts.KeepOriginal && info.Audio != nil {
+		original = "[0:a]"
+	}
+	if len(inputs) == 0 && original == "" {
+		return fmt.Errorf("%w: video has no audio to keep", ErrInvalidParameters)
+	}
+	filter := mixFilter(inputs, 1, original, opts, info.Duration)
+	args = append(args,
+		"-filter_complex", filter,
+		"-map", "0:v:0",
+		"-map", "[aout]",
+		"-c:v", "copy",
+		"-c:a", "aac",
+		"-t", seconds(info.Duration),
+		"-y", outFile,
+	)
+	return j.run(args...)
+}
+
+func checkAudioTrack(t AudioTrack) error {
+	if len(t.Audio) == 0 {
+		return fmt.Errorf("%w: empty audio", ErrInvalidParameters)
+	}
+	if t.Role != "" && t.Role != AudioMusic && t.Role != AudioVoice {
+		return fmt.Errorf("%w: unknown audio role %q", ErrInvalidParameters, t.Role)
+	}
+	if t.Volume < 0 {
+		return fmt.Errorf("%w: volume must not be negative", ErrInvalidParameters)
+	}
+	if t.Start < 0 || t.FadeIn < 0 || t.FadeOut < 0 {
+		return fmt.Errorf("%w: start and fades must not be negative", ErrInvalidParameters)
+	}
+	return nil
+}
+
+// mixFilter builds the filter graph mixing the original audio read from the
+// pad original, if not empty, and inputs (ffmpeg inputs first..first+n-1)
+// into the [aout] label, padded with silence to the video's duration.
+func mixFilter(inputs []mixInput, first int, original string, opts MixOptions, duration time.Duration) string {
+	const format = "aformat=sample_rates=48000:channel_layouts=stereo"
+	var (
+		filters       []string
+		ducked, voice []string
+	)
+	if original != "" {
+		filters = append(filters, fmt.Sprintf("%s%s,volume=%s[orig]", original, format, volume(opts.OriginalVolume)))
+		ducked = append(ducked, "[orig]")
+	}
+	for i, in := range inputs {
+		// Each track is cut where it would run past the end of the video,
+		// so that fade-outs land on the audible end.
+		length := duration - in.Start
+		if !in.Loop && in.duration > 0 && in.duration < length {
+			length = in.duration
+		}
+		chain := []string{format, "atrim=0:" + seconds(length), "volume=" + volume(in.Volume)}
+		if in.FadeIn > 0 {
+			chain = append(chain, "afade=t=in:st=0:d="+seconds(in.FadeIn))
+		}
+		if in.FadeOut > 0 {
+			fade := min(in.FadeOut, length)
+			chain = append(chain, fmt.Sprintf("afade=t=out:st=%s:d=%s", seconds(length-fade), seconds(fade)))
+		}
+		if in.Start > 0 {
+			chain = append(chain, fmt.Sprintf("adelay=%d:all=1", in.Start.Milliseconds()))
+		}
+		label := fmt.Sprintf("[t%d]", i+1)
+		filters = append(filters, fmt.Sprintf("[%d:a]%s%s", first+i, strings.Join(chain, ","), label))
+		if in.Role == AudioVoice {
+			voice = append(voice, label)
+		} else {
+			ducked = append(ducked, label)
+		}
+	}
+
+	var outputs []string
+	if opts.Ducking != nil && len(voice) > 0 && len(ducked) > 0 {
+		d := opts.Ducking
+		filters = append(filters,
+			mixLabels(ducked, "[bed]"),
+			mixLabels(voice, "[voice]"),
+			"[voice]asplit=2[speech][sc]",
+			// sidechaincompress stops at the end of either input, so the
+			// sidechain is padded to let the bed play on after the voice.
+			"[sc]apad[scp]",
+			fmt.Sprintf("[bed][scp]sidechaincompress=threshold=%s:ratio=%s:attack=%s:release=%s[duck]",
+				strconv.FormatFloat(d.Threshold, 'f', -1, 64), strconv.FormatFloat(d.Ratio, 'f', -1, 64),
+				strconv.FormatInt(d.Attack.Milliseconds(), 10), strconv.FormatInt(d.Release.Milliseconds(), 10)),
+		)
+		outputs = []string{"[duck]", "[speech]"}
+	} else {
+		outputs = append(ducked, voice...)
+	}
+	filters = append(filters, mixLabels(outputs, "[mix]"), "[mix]apad[aout]")
+	return strings.Join(filters, ";")
+}
+
+// mixLabels sums the labelled streams without amix's default scaling by
+// the number of inputs, so each track keeps the volume it was given.
+func mixLabels(labels []string, out string) string {
+	if len(labels) == 1 {
+		return labels[0] + "anull" + out
+	}
+	return fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0%s", strings.Join(labels, ""), len(labels), out)
+}
+
+func volume(v float64) string {
+	if v == 0 {
+		v = 1
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// mixOptionsFromOptions reads the workflow step options shared by the
+// mix_audio and video_and_audio_to_video steps.
+func mixOptionsFromOptions(opts *MixOptions, k string, v any) (bool, error) {
+	var err error
+	switch k {
+	case "keep_original":
+		opts.KeepOriginal, err = optionBool(k, v)
+	case "original_volume":
+		opts.OriginalVolume, err = optionFloat(k, v)
+	case "ducking":
+		opts.Ducking, err = duckingFromOption(k, v)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// duckingFromOption accepts true for the default ducking or a map with
+// threshold, ratio, attack and release (in seconds).
+func duckingFromOption(key string, v any) (*Ducking, error) {
+	if m, ok := v.(map[string]any); ok {
+		var (
+			d   Ducking
+			err error
+		)
+		for k, val := range m {
+			switch k {
+			case "threshold":
+				d.Threshold, err = optionFloat(key+"."+k, val)
+			case "ratio":
+				d.Ratio, err = optionFloat(key+"."+k, val)
+			case "attack":
+				d.Attack, err = optionSeconds(key+"."+k, val)
+			case "release":
+				d.Release, err = optionSeconds(key+"."+k, val)
+			default:
+				err = fmt.Errorf("%w: unknown ducking option %s", ErrInvalidParameters, k)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &d, nil
+	}
+	on, err := optionBool(key, v)
+	if err != nil || !on {
+		return nil, err
+	}
+	return &Ducking{}, nil
+}
+
+// audioTrackFromOption reads the track settings shared by the entries of the
+// mix_audio "tracks" option and the video_and_audio_to_video step.
+func audioTrackFromOption(t *AudioTrack, k string, v any) (bool, error) {
+	var err error
+	switch k {
+	case "role":
+		var r string
+		r, err = optionString(k, v)
+		t.Role = AudioRole(r)
+	case "volume":
+		t.Volume, err = optionFloat(k, v)
+	case "start":
+		t.Start, err = optionSeconds(k, v)
+	case "fade_in":
+		t.FadeIn, err = optionSeconds(k, v)
+	case "fade_out":
+		t.FadeOut, err = optionSeconds(k, v)
+	case "loop":
+		t.Loop, err = optionBool(k, v)
+	default:
+		return false, nil
+	}
+	return true, err
+}
@@ -0,0 +1,172 @@
+package genailib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMixFilter(t *testing.T) {
+	inputs := []mixInput{
+		{AudioTrack: AudioTrack{Volume: 0.3, FadeOut: 2 * time.Second, Loop: true}, duration: 3 * time.Second},
+		{AudioTrack: AudioTrack{Role: AudioVoice, Start: 1500 * time.Millisecond}, duration: 2 * time.Second},
+	}
+	got := mixFilter(inputs, 1, "", MixOptions{}, 8*time.Second)
+	want := strings.Join([]string{
+		"[1:a]aformat=sample_rates=48000:channel_layouts=stereo,atrim=0:8.000,volume=0.3,afade=t=out:st=6.000:d=2.000[t1]",
+		"[2:a]aformat=sample_rates=48000:channel_layouts=stereo,atrim=0:2.000,volume=1,adelay=1500:all=1[t2]",
+		"[t1][t2]amix=inputs=2:duration=longest:normalize=0[mix]",
+		"[mix]apad[aout]",
+	}, ";")
+	if got != want {
+		t.Fatalf("unexpected filter\n got %s\nwant %s", got, want)
+	}
+
+	d, _ := Ducking{}.withDefaults()
+	got = mixFilter(inputs, 1, "[0:a]", MixOptions{OriginalVolume: 0.5, Ducking: &d}, 8*time.Second)
+	for _, part := range []string{
+		"[0:a]aformat=sample_rates=48000:channel_layouts=stereo,volume=0.5[orig]",
+		"[orig][t1]amix=inputs=2:duration=longest:normalize=0[bed]",
+		"[t2]anull[voice]",
+		"[voice]asplit=2[speech][sc]",
+		"[sc]apad[scp]",
+		"[bed][scp]sidechaincompress=threshold=0.05:ratio=8:attack=20:release=400[duck]",
+		"[duck][speech]amix=inputs=2:duration=longest:normalize=0[mix]",
+	} {
+		if !strings.Contains(got, part) {
+			t.Errorf("filter %s\nmissing %s", got, part)
+		}
+	}
+}
+
+func TestMixFilterShortVoice(t *testing.T) {
+	// A 2s voice-over ducking a 10s music bed under an 8s video: the bed
+	// must keep playing once the voice has ended.
+	inputs := []mixInput{
+		{AudioTrack: AudioTrack{}, duration: 10 * time.Second},
+		{AudioTrack: AudioTrack{Role: AudioVoice, Start: time.Second}, duration: 2 * time.Second},
+	}
+	d, _ := Ducking{}.withDefaults()
+	got := mixFilter(inputs, 1, "", MixOptions{Ducking: &d}, 8*time.Second)
+	want := strings.Join([]string{
+		"[1:a]aformat=sample_rates=48000:channel_layouts=stereo,atrim=0:8.000,volume=1[t1]",
+		"[2:a]aformat=sample_rates=48000:channel_layouts=stereo,atrim=0:2.000,volume=1,adelay=1000:all=1[t2]",
+		"[t1]anull[bed]",
+		"[t2]anull[voice]",
+		"[voice]asplit=2[speech][sc]",
+		"[sc]apad[scp]",
+		"[bed][scp]sidechaincompress=threshold=0.05:ratio=8:attack=20:release=400[duck]",
+		"[duck][speech]amix=inputs=2:duration=longest:normalize=0[mix]",
+		"[mix]apad[aout]",
+	}, ";")
+	if got != want {
+		t.Fatalf("unexpected filter\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestMixAudioInvalid(t *testing.T) {
+	audio := []byte("audio")
+	cases := []struct {
+		tracks []AudioTrack
+		opts   MixOptions
+	}{
+		{nil, MixOptions{}},
+		{[]AudioTrack{{}}, MixOptions{}},
+		{[]AudioTrack{{Audio: audio, Role: "sfx"}}, MixOptions{}},
+		{[]AudioTrack{{Audio: audio, Volume: -1}}, MixOptions{}},
+		{[]AudioTrack{{Audio: audio, FadeIn: -time.Second}}, MixOptions{}},
+		{[]AudioTrack{{Audio: audio}}, MixOptions{Ducking: &Ducking{Ratio: 30}}},
+		{nil, MixOptions{KeepOriginal: true, OriginalVolume: -1}},
+	}
+	for _, c := range cases {
+		if _, err := MixAudio(nil, c.tracks, c.opts); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %+v %+v, got %v", c.tracks, c.opts, err)
+		}
+	}
+}
+
+func TestMixAudio(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("green")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	aud, err := createToneAudio()
+	if err != nil {
+		t.Fatalf("failed to create audio: %v", err)
+	}
+	withAudio, err := AddAudioToVideo(vid, aud)
+	if err != nil {
+		t.Fatalf("AddAudioToVideo returned error: %v", err)
+	}
+	out, err := MixAudio(withAudio, []AudioTrack{
+		{Audio: aud, Volume: 0.5, FadeIn: 200 * time.Millisecond, Loop: true},
+		{Audio: aud, Role: AudioVoice, Start: 500 * time.Millisecond},
+	}, MixOptions{KeepOriginal: true, Ducking: &Ducking{}})
+	if err != nil {
+		t.Fatalf("MixAudio returned error: %v", err)
+	}
+	info, err := ProbeMedia(out)
+	if err != nil {
+		t.Fatalf("ProbeMedia returned error: %v", err)
+	}
+	if info.Audio == nil || info.Audio.Channels != 2 || info.Duration > 1100*time.Millisecond {
+		t.Fatalf("unexpected output %+v", info)
+	}
+
+	in := filepath.Join(t.TempDir(), "in.mp4")
+	if err := os.WriteFile(in, vid, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := defaultFFmpeg.MixAudioFile(context.Background(), in, dst, []AudioTrack{{Audio: aud}}, MixOptions{}); err != nil {
+		t.Fatalf("MixAudioFile returned error: %v", err)
+	}
+	if info, err := ProbeMediaFile(dst); err != nil || info.Audio == nil {
+		t.Fatalf("unexpected output %+v: %v", info, err)
+	}
+}
+
+func TestWorkflowMixAudioOptions(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "mix",
+		FunctionType: FunctionTypeMixAudio,
+		Video:        "clip",
+		Options: map[string]any{
+			"tracks":  []any{map[string]any{"audio": "music", "volume": 0.2, "loop": true}},
+			"ducking": map[string]any{"threshold": 2},
+		},
+	}}}
+	inputs := map[string]any{"clip": []byte("video"), "music": []byte("audio")}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for ducking threshold, got %v", err)
+	}
+
+	wf.Steps[0].Options = map[string]any{"tracks": []any{map[string]any{"volume": 0.2}}}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for a track without audio, got %v", err)
+	}
+	wf.Steps[0].Options = map[string]any{"tracks": []any{map[string]any{"audio": "music", "pan": -1}}}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for unknown track option, got %v", err)
+	}
+
+	wf.Steps[0] = WorkflowStep{
+		ID:           "mix",
+		FunctionType: FunctionTypeVideoAndAudioToVideo,
+		Video:        "clip",
+		Audio:        "music",
+		Options:      map[string]any{"keep_original": true, "volume": -0.5},
+	}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for volume, got %v", err)
+	}
+}
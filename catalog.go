@@ -0,0 +1,273 @@
+package genailib
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageRequirements describes the input images a model accepts.
+type ImageRequirements struct {
+	MIMETypes []string `json:"mime_types"`
+	// MaxBytes is the largest accepted file size. Zero means unknown.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// Pricing holds approximate list prices in USD.
+type Pricing struct {
+	PerImage  float64 `json:"per_image,omitempty"`
+	PerSecond float64 `json:"per_second,omitempty"`
+}
+
+// ModelInfo describes what a provider model can do. Capabilities use the
+// workflow function type names, e.g. FunctionTypeTextToImage.
+type ModelInfo struct {
+	Provider     string   `json:"provider"`
+	Model        string   `json:"model"`
+	Capabilities []string `json:"capabilities"`
+	// Sizes lists accepted WIDTHxHEIGHT sizes for models that take exact sizes.
+	Sizes        []string `json:"sizes,omitempty"`
+	AspectRatios []string `json:"aspect_ratios,omitempty"`
+	// Durations lists accepted clip lengths in seconds; the first is the default.
+	Durations       []int              `json:"durations,omitempty"`
+	MaxPromptLength int                `json:"max_prompt_length,omitempty"`
+	InputImage      *ImageRequirements `json:"input_image,omitempty"`
+	Pricing         Pricing            `json:"pricing"`
+	// Implemented reports whether the library can call the model.
+	Implemented bool `json:"implemented"`
+}
+
+// Supports reports whether the model offers capability.
+func (m ModelInfo) Supports(capability string) bool {
+	return slices.Contains(m.Capabilities, capability)
+}
+
+// EstimatedPrice returns the approximate price of a single request using the
+// default duration for video models.
+func (m ModelInfo) EstimatedPrice() float64 {
+	if m.Pricing.PerSecond > 0 && len(m.Durations) > 0 {
+		return m.Pricing.PerSecond * float64(m.Durations[0])
+	}
+	return m.Pricing.PerImage
+}
+
+var commonImageTypes = []string{"image/png", "image/jpeg", "image/webp"}
+
+var catalog = []ModelInfo{
+	{
+		Provider:        OpenAIProvider,
+		Model:           ProviderGPTImage1,
+		Capabilities:    []string{FunctionTypeTextToImage, FunctionTypeTextAndImageToImage},
+		Sizes:           []string{"1024x1024", "1536x1024", "1024x1536"},
+		MaxPromptLength: 32000,
+		InputImage:      &ImageRequirements{MIMETypes: commonImageTypes, MaxBytes: 50 << 20},
+		Pricing:         Pricing{PerImage: 0.17},
+		Implemented:     true,
+	},
+	{
+		Provider:        OpenAIProvider,
+		Model:           ProviderDallE3,
+		Capabilities:    []string{FunctionTypeTextToImage},
+		Sizes:           []string{"1024x1024", "1792x1024", "1024x1792"},
+		MaxPromptLength: 4000,
+		Pricing:         Pricing{PerImage: 0.08},
+		Implemented:     true,
+	},
+	{
+		Provider:        GeminiProvider,
+		Model:           ProviderImagen3Generate002,
+		Capabilities:    []string{FunctionTypeTextToImage},
+		AspectRatios:    []string{"1:1", "3:4", "4:3", "9:16", "16:9"},
+		MaxPromptLength: 1920,
+		Pricing:         Pricing{PerImage: 0.04},
+		Implemented:     true,
+	},
+	{
+		Provider:     GeminiProvider,
+		Model:        ProviderGemini20FlashExpImageGeneration,
+		Capabilities: []string{FunctionTypeTextToImage, FunctionTypeTextAndImageToImage},
+		InputImage:   &ImageRequirements{MIMETypes: commonImageTypes, MaxBytes: 20 << 20},
+		Pricing:      Pricing{PerImage: 0.04},
+		Implemented:  true,
+	},
+	{Provider: "leonardo", Model: ProviderLeonardoKinoXL, Capabilities: []string{FunctionTypeTextToImage}},
+	{Provider: "leonardo", Model: ProviderLeonardoDiffusionXL, Capabilities: []string{FunctionTypeTextToImage}},
+	{Provider: "leonardo", Model: ProviderLeonardoAnimeXL, Capabilities: []string{FunctionTypeTextToImage}},
+	{Provider: "leonardo", Model: ProviderLeonardoLightning, Capabilities: []string{FunctionTypeTextToImage}},
+	// Luma's models run on Replicate, where the gateway sends every
+	// owner/name model.
+	{Provider: ReplicateProvider, Model: ProviderLumaPhoton, Capabilities: []string{FunctionTypeTextToImage}, Pricing: Pricing{PerImage: 0.03}, Implemented: true},
+	{Provider: ReplicateProvider, Model: ProviderLumaPhotonFlash, Capabilities: []string{FunctionTypeTextToImage}, Pricing: Pricing{PerImage: 0.01}, Implemented: true},
+	{Provider: "stability", Model: ProviderStabilitySD3, Capabilities: []string{FunctionTypeTextToImage}, Pricing: Pricing{PerImage: 0.035}},
+	{Provider: ReplicateProvider, Model: ProviderFluxSchnell, Capabilities: []string{FunctionTypeTextToImage}, Pricing: Pricing{PerImage: 0.003}},
+	{Provider: ReplicateProvider, Model: ProviderSana, Capabilities: []string{FunctionTypeTextToImage}},
+	{
+		Provider:     ReplicateProvider,
+		Model:        ProviderRealESRGAN,
+		Capabilities: []string{FunctionTypeUpscaleImage},
+		InputImage:   &ImageRequirements{MIMETypes: commonImageTypes},
+		Implemented:  true,
+	},
+	{
+		Provider:     ReplicateProvider,
+		Model:        ProviderRembg,
+		Capabilities: []string{FunctionTypeRemoveBackground},
+		InputImage:   &ImageRequirements{MIMETypes: commonImageTypes},
+		Implemented:  true,
+	},
+	{
+		Provider:     ReplicateProvider,
+		Model:        ProviderSeedance1,
+		Capabilities: []string{FunctionTypeTextAndImageToVideo, FunctionTypeTextAndImagesToVideo},
+		AspectRatios: []string{"16:9", "4:3", "1:1", "3:4", "9:16", "21:9", "9:21"},
+		Durations:    []int{5, 10},
+		InputImage:   &ImageRequirements{MIMETypes: commonImageTypes},
+		Pricing:      Pricing{PerSecond: 0.15},
+		Implemented:  true,
+	},
+	{
+		Provider:     ReplicateProvider,
+		Model:        ProviderSeedance1Lite,
+		Capabilities: []string{FunctionTypeTextAndImageToVideo, FunctionTypeTextAndImagesToVideo},
+		AspectRatios: []string{"16:9", "4:3", "1:1", "3:4", "9:16", "21:9", "9:21"},
+		Durations:    []int{5, 10},
+		InputImage:   &ImageRequirements{MIMETypes: commonImageTypes},
+		Pricing:      Pricing{PerSecond: 0.036},
+		Implemented:  true,
+	},
+	{
+		Provider:        GeminiProvider,
+		Model:           ProviderVeo3Preview,
+		Capabilities:    []string{FunctionTypeTextAndImageToVideo, FunctionTypeTextAndImagesToVideo},
+		AspectRatios:    []string{"16:9"},
+		Durations:       []int{8},
+		MaxPromptLength: 4000,
+		InputImage:      &ImageRequirements{MIMETypes: []string{"image/png", "image/jpeg"}, MaxBytes: 20 << 20},
+		Pricing:         Pricing{PerSecond: 0.75},
+		Implemented:     true,
+	},
+}
+
+// Models returns the catalog of known provider models.
+func Models() []ModelInfo {
+	return slices.Clone(catalog)
+}
+
+// LookupModel returns the catalog entry for model.
+func LookupModel(model string) (ModelInfo, bool) {
+	for _, m := range catalog {
+		if m.Model == model {
+			return m, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// ModelsFor returns the implemented models offering capability.
+func ModelsFor(capability string) []ModelInfo {
+	var out []ModelInfo
+	for _, m := range catalog {
+		if m.Implemented && m.Supports(capability) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// estimatedPrice returns the catalog price of one request to model.
+func estimatedPrice(model string) float64 {
+	m, ok := LookupModel(model)
+	if !ok {
+		return 0
+	}
+	return m.EstimatedPrice()
+}
+
+// providerFunctionTypes lists the function types whose Provider field
+// selects a catalog model.
+var providerFunctionTypes = []string{
+	FunctionTypeTextToImage,
+	FunctionTypeTextAndImageToImage,
+	FunctionTypeTextAndImagesToVideo,
+	FunctionTypeTextAndImageToVideo,
+	FunctionTypeUpscaleImage,
+	FunctionTypeRemoveBackground,
+}
+
+// knownFunctionTypes lists every function type the workflow engine runs.
+var knownFunctionTypes = []string{
+	FunctionTypeTextsToText,
+	FunctionTypeTextToImage,
+	FunctionTypeTextAndImageToImage,
+	FunctionTypeTextAndImagesToVideo,
+	FunctionTypeTextAndImageToVideo,
+	FunctionTypeVideosToVideo,
+	FunctionTypeVideoAndAudioToVideo,
+	FunctionTypeProcessImage,
+	FunctionTypeWatermark,
+	FunctionTypeUpscaleImage,
+	FunctionTypeRemoveBackground,
+	FunctionTypeVideoToImage,
+	FunctionTypeTrimVideo,
+	FunctionTypeCutVideo,
+	FunctionTypeChangeVideoSpeed,
+	FunctionTypeReverseVideo,
+	FunctionTypeLoopVideo,
+	FunctionTypeCaptionVideo,
+	FunctionTypeMixAudio,
+	FunctionTypeExportVideo,
+	FunctionTypeRenderTimeline,
+}
+
+// ValidateWorkflow checks a workflow against the catalog before it runs,
+// rejecting unknown function types, duplicate step IDs and providers that are
+// unknown, not implemented or lack the step's capability.
+func ValidateWorkflow(wf *Workflow) error {
+	if wf == nil {
+		return errors.New("nil workflow")
+	}
+	seen := make(map[string]bool)
+	for _, step := range wf.Steps {
+		if step.ID == "" {
+			return errors.New("workflow step without id")
+		}
+		if seen[step.ID] {
+			return errors.Errorf("duplicate workflow step id %s", step.ID)
+		}
+		seen[step.ID] = true
+
+		if !slices.Contains(knownFunctionTypes, step.FunctionType) {
+			return errors.Errorf("step %s: unsupported function type: %s", step.ID, step.FunctionType)
+		}
+		if step.Provider == "" || !slices.Contains(providerFunctionTypes, step.FunctionType) {
+			continue
+		}
+		if err := validateStepProvider(step); err != nil {
+			return errors.Wrapf(err, "step %s", step.ID)
+		}
+	}
+	return nil
+}
+
+func validateStepProvider(step WorkflowStep) error {
+	m, ok := LookupModel(step.Provider)
+	if !ok {
+		// Any "owner/model" name may be run on Replicate.
+		if strings.Contains(step.Provider, "/") {
+			return nil
+		}
+		return fmt.Errorf("unknown provider %s", step.Provider)
+	}
+	if !m.Implemented {
+		return fmt.Errorf("provider %s is not implemented", step.Provider)
+	}
+	if !m.Supports(step.FunctionType) {
+		return fmt.Errorf("provider %s does not support %s", step.Provider, step.FunctionType)
+	}
+	if m.MaxPromptLength > 0 && len(step.Prompt) > m.MaxPromptLength {
+		return fmt.Errorf("prompt exceeds %d characters allowed by %s", m.MaxPromptLength, step.Provider)
+	}
+	return nil
+}
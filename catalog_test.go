@@ -0,0 +1,65 @@
+package genailib
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+	m, ok := LookupModel(ProviderVeo3Preview)
+	if !ok {
+		t.Fatalf("veo model not in catalog")
+	}
+	if !m.Implemented || !m.Supports(FunctionTypeTextAndImagesToVideo) || m.Supports(FunctionTypeTextToImage) {
+		t.Fatalf("unexpected veo entry: %+v", m)
+	}
+	if got := m.EstimatedPrice(); got != 6 {
+		t.Fatalf("unexpected veo price %v", got)
+	}
+	if _, ok := LookupModel("no-such-model"); ok {
+		t.Fatal("unexpected entry for unknown model")
+	}
+}
+
+func TestModelsForOnlyReturnsImplemented(t *testing.T) {
+	models := ModelsFor(FunctionTypeTextToImage)
+	if len(models) == 0 {
+		t.Fatal("no text to image models")
+	}
+	for _, m := range models {
+		if !m.Implemented {
+			t.Fatalf("unimplemented model %s returned", m.Model)
+		}
+	}
+}
+
+func TestValidateWorkflow(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []WorkflowStep
+		ok    bool
+	}{
+		{"valid", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextAndImageToVideo, Provider: ProviderSeedance1Lite}}, true},
+		{"replicate model", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextToImage, Provider: "owner/model"}}, true},
+		{"luma on replicate", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextToImage, Provider: ProviderLumaPhoton}}, true},
+		{"unknown provider", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextToImage, Provider: "nope"}}, false},
+		{"not implemented", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextToImage, Provider: ProviderLeonardoKinoXL}}, false},
+		{"wrong capability", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextToImage, Provider: ProviderVeo3Preview}}, false},
+		{"duplicate id", []WorkflowStep{{ID: "a", FunctionType: FunctionTypeTextsToText}, {ID: "a", FunctionType: FunctionTypeTextsToText}}, false},
+		{"unknown type", []WorkflowStep{{ID: "a", FunctionType: "unknown"}}, false},
+	}
+	for _, c := range cases {
+		err := ValidateWorkflow(&Workflow{Steps: c.steps})
+		if (err == nil) != c.ok {
+			t.Errorf("%s: ValidateWorkflow error = %v", c.name, err)
+		}
+	}
+}
+
+func TestWorkflowGenerateRejectsImpossibleProvider(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{ID: "img", FunctionType: FunctionTypeTextToImage, Provider: ProviderVeo3Preview, Prompt: "x"}}}
+	if _, _, err := svc.Generate(context.Background(), wf, nil); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
@@ -0,0 +1,152 @@
+package genailib
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/iomodo/gen-ai-lib/external/gemini"
+	"github.com/iomodo/gen-ai-lib/external/openai"
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+	"github.com/iomodo/gen-ai-lib/external/storage"
+)
+
+// Config holds the provider credentials, endpoints and transport settings
+// shared by the services of this library. Nothing is read from the
+// environment unless ConfigFromEnv or WithEnv is used.
+type Config struct {
+	OpenAI    openai.Config
+	Gemini    gemini.Config
+	Replicate replicate.Config
+
+	// HTTPClient is used by every provider that has no client of its own
+	// and for downloading inputs referenced by URL. Vertex AI without an API
+	// key does not use it, since it authenticates with Google default
+	// credentials through a client of its own.
+	HTTPClient *http.Client
+	// Transport and Timeout build the shared client when HTTPClient is nil.
+	Transport http.RoundTripper
+	Timeout   time.Duration
+
+	// Storage is the backend used to persist generated artifacts.
+	Storage storage.Storage
+
+	// FFmpeg runs the video steps of workflows. Nil runs the ffmpeg and
+	// ffprobe found on the system PATH.
+	FFmpeg *FFmpeg
+}
+
+// Option configures a Config.
+type Option func(*Config)
+
+// NewConfig returns a Config with opts applied in order.
+func NewConfig(opts ...Option) Config {
+	var cfg Config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// ConfigFromEnv returns a Config populated from the environment variables
+// read by each provider package.
+func ConfigFromEnv() Config {
+	return Config{
+		OpenAI:    openai.ConfigFromEnv(),
+		Gemini:    gemini.ConfigFromEnv(),
+		Replicate: replicate.ConfigFromEnv(),
+	}
+}
+
+// WithConfig replaces the whole configuration with cfg.
+func WithConfig(cfg Config) Option {
+	return func(c *Config) { *c = cfg }
+}
+
+// WithEnv loads provider settings from the environment, keeping any
+// transport and storage settings already applied.
+func WithEnv() Option {
+	return func(c *Config) {
+		env := ConfigFromEnv()
+		c.OpenAI = env.OpenAI
+		c.Gemini = env.Gemini
+		c.Replicate = env.Replicate
+	}
+}
+
+// WithOpenAIKey sets the OpenAI API key.
+func WithOpenAIKey(key string) Option {
+	return func(c *Config) { c.OpenAI.APIKey = key }
+}
+
+// WithOpenAIBaseURL sets the OpenAI API endpoint.
+func WithOpenAIBaseURL(url string) Option {
+	return func(c *Config) { c.OpenAI.BaseURL = url }
+}
+
+// WithGeminiKey sets the Gemini API key.
+func WithGeminiKey(key string) Option {
+	return func(c *Config) { c.Gemini.APIKey = key }
+}
+
+// WithGeminiBaseURL sets the Gemini API endpoint.
+func WithGeminiBaseURL(url string) Option {
+	return func(c *Config) { c.Gemini.BaseURL = url }
+}
+
+// WithVertexAI routes Gemini requests to Vertex AI in the given project and location.
+func WithVertexAI(project, location string) Option {
+	return func(c *Config) {
+		c.Gemini.UseVertexAI = true
+		c.Gemini.Project = project
+		c.Gemini.Location = location
+	}
+}
+
+// WithReplicateToken sets the Replicate API token.
+func WithReplicateToken(token string) Option {
+	return func(c *Config) { c.Replicate.APIToken = token }
+}
+
+// WithReplicateBaseURL sets the Replicate API endpoint.
+func WithReplicateBaseURL(url string) Option {
+	return func(c *Config) { c.Replicate.BaseURL = url }
+}
+
+// WithHTTPClient sets the HTTP client shared by all providers.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) { c.HTTPClient = client }
+}
+
+// WithTransport sets the round tripper used when no HTTP client is given,
+// for example to route requests through a proxy or a recording transport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Config) { c.Transport = rt }
+}
+
+// WithTimeout sets the per-request timeout used when no HTTP client is given.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Config) { c.Timeout = d }
+}
+
+// WithStorage sets the storage backend.
+func WithStorage(s storage.Storage) Option {
+	return func(c *Config) { c.Storage = s }
+}
+
+// WithFFmpeg sets the ffmpeg runner used by workflow video steps, e.g. to
+// choose the binaries, the temp directory or size limits.
+func WithFFmpeg(f *FFmpeg) Option {
+	return func(c *Config) { c.FFmpeg = f }
+}
+
+// httpClient returns the shared HTTP client, or nil to let each provider
+// use its own default.
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	if c.Transport != nil || c.Timeout > 0 {
+		return &http.Client{Transport: c.Transport, Timeout: c.Timeout}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package genailib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewConfigOptions(t *testing.T) {
+	cfg := NewConfig(
+		WithOpenAIKey("oai"),
+		WithGeminiKey("gem"),
+		WithVertexAI("proj", "us-central1"),
+		WithReplicateToken("rep"),
+		WithReplicateBaseURL("http://localhost:8080/v1"),
+		WithTimeout(5*time.Second),
+	)
+	if cfg.OpenAI.APIKey != "oai" || cfg.Gemini.APIKey != "gem" || cfg.Replicate.APIToken != "rep" {
+		t.Fatalf("unexpected keys: %+v", cfg)
+	}
+	if !cfg.Gemini.UseVertexAI || cfg.Gemini.Project != "proj" || cfg.Gemini.Location != "us-central1" {
+		t.Fatalf("unexpected vertex settings: %+v", cfg.Gemini)
+	}
+	if cfg.Replicate.BaseURL != "http://localhost:8080/v1" {
+		t.Fatalf("unexpected replicate base url: %s", cfg.Replicate.BaseURL)
+	}
+	if c := cfg.httpClient(); c == nil || c.Timeout != 5*time.Second {
+		t.Fatalf("expected client with timeout, got %+v", c)
+	}
+}
+
+func TestNewConfigDoesNotReadEnv(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "from-env")
+	if cfg := NewConfig(); cfg.OpenAI.APIKey != "" {
+		t.Fatalf("NewConfig read the environment: %q", cfg.OpenAI.APIKey)
+	}
+	if cfg := NewConfig(WithEnv()); cfg.OpenAI.APIKey != "from-env" {
+		t.Fatalf("WithEnv did not load the environment: %q", cfg.OpenAI.APIKey)
+	}
+}
+
+func TestNewProvidersSharesHTTPClient(t *testing.T) {
+	client := &http.Client{}
+	p := newProviders(NewConfig(WithHTTPClient(client)))
+	if p.cfg.OpenAI.HTTPClient != client || p.cfg.Gemini.HTTPClient != client || p.cfg.Replicate.HTTPClient != client {
+		t.Fatal("shared HTTP client was not applied to every provider")
+	}
+}
+
+func TestNewProvidersKeepsVertexCredentials(t *testing.T) {
+	p := newProviders(NewConfig(WithVertexAI("proj", "us-central1"), WithTimeout(time.Minute)))
+	if p.cfg.Gemini.HTTPClient != nil {
+		t.Fatal("shared HTTP client replaced Vertex AI default credentials")
+	}
+	if p.cfg.OpenAI.HTTPClient == nil {
+		t.Fatal("shared HTTP client was not applied to OpenAI")
+	}
+
+	p = newProviders(NewConfig(WithVertexAI("proj", "us-central1"), WithGeminiKey("key"), WithTimeout(time.Minute)))
+	if p.cfg.Gemini.HTTPClient == nil {
+		t.Fatal("shared HTTP client was not applied to Vertex AI with an API key")
+	}
+}
+
+type countingTransport struct {
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.calls++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestProvidersDownloadUsesTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	rt := &countingTransport{}
+	p := newProviders(NewConfig(WithTransport(rt)))
+	data, err := p.download(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("download returned error: %v", err)
+	}
+	if string(data) != "payload" || rt.calls != 1 {
+		t.Fatalf("unexpected download %q with %d transport calls", data, rt.calls)
+	}
+}
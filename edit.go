@@ -0,0 +1,220 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+
+	"github.com/iomodo/gen-ai-lib/external/gemini"
+	"github.com/iomodo/gen-ai-lib/external/openai"
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+)
+
+// EditRequest describes an instruction based image edit. The first image is
+// the one being edited; any further images are references, for example to
+// keep a character or style consistent. Transparent pixels of Mask mark the
+// area of the first image to repaint, which also covers outpainting when the
+// image has been padded with transparency.
+type EditRequest struct {
+	Prompt string
+	Images []ImageInput
+	Mask   *ImageInput
+	// Size is a WIDTHxHEIGHT string such as "1024x1024".
+	Size string
+	// N is the number of images to generate. Zero means one.
+	N int
+	// Extra holds provider specific inputs that are passed through unchanged.
+	Extra map[string]any
+}
+
+// fields returns the optional fields set on r.
+func (r EditRequest) fields() ImageField {
+	var f ImageField
+	if r.Mask != nil {
+		f |= ImageFieldMask
+	}
+	if len(r.Images) > 1 {
+		f |= ImageFieldReferenceImages
+	}
+	if r.Size != "" {
+		f |= ImageFieldSize
+	}
+	if r.N > 1 {
+		f |= ImageFieldN
+	}
+	if len(r.Extra) > 0 {
+		f |= ImageFieldExtra
+	}
+	return f
+}
+
+// EditServiceFunc edits images for a validated request.
+type EditServiceFunc func(ctx context.Context, req EditRequest) (*ImageResponse, error)
+
+// editService represents a single image-to-image endpoint.
+type editService struct {
+	name     string
+	supports ImageField
+	fn       EditServiceFunc
+}
+
+// validate rejects requests without an image or that set fields the service
+// does not support.
+func (s *editService) validate(req EditRequest) error {
+	if len(req.Images) == 0 {
+		return fmt.Errorf("%w: at least one image is required", ErrInvalidParameters)
+	}
+	return validateRequest(s.name, req.Prompt, req.N, req.fields(), s.supports)
+}
+
+// getEditService is getService for image-to-image services.
+func (g *APIGateway) getEditService(preferredService string) *editService {
+	if preferredService == "" {
+		return g.imageToImageServices[0]
+	}
+	for _, api := range g.imageToImageServices {
+		if api.name == preferredService {
+			return api
+		}
+	}
+	if strings.Contains(preferredService, "/") {
+		return g.replicateEditService(preferredService)
+	}
+	return nil
+}
+
+// ImageToImage executes an image edit.
+func (g *APIGateway) ImageToImage(ctx context.Context, req EditRequest, preferredService string) (*ImageResponse, error) {
+	api := g.getEditService(preferredService)
+	if api == nil {
+		log.Printf("ImageToImage error: %v", ErrNoAPIAvailable)
+		return nil, ErrNoAPIAvailable
+	}
+	if err := api.validate(req); err != nil {
+		return nil, err
+	}
+	return g.call(ctx, "ImageToImage", api.name, req.N, func(ctx context.Context) (*ImageResponse, error) {
+		return api.fn(ctx, req)
+	})
+}
+
+func (g *APIGateway) editGPTImage1(ctx context.Context, req EditRequest) (*ImageResponse, error) {
+	editReq := openai.EditImageRequest{
+		Model:        ProviderGPTImage1,
+		Prompt:       req.Prompt,
+		ImageOptions: openai.ImageOptions{Size: req.Size, N: req.N},
+	}
+	for _, in := range req.Images {
+		file, err := g.imageFile(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		editReq.Images = append(editReq.Images, file)
+	}
+	if req.Mask != nil {
+		mask, err := g.imageFile(ctx, *req.Mask)
+		if err != nil {
+			return nil, err
+		}
+		editReq.Mask = &mask
+	}
+	res, err := g.providers.withOpenAI(ctx, ProviderGPTImage1, func(svc openai.OpenAIService) (any, error) {
+		if ed, ok := svc.(openai.ImageEditor); ok {
+			return ed.EditImage(ctx, editReq)
+		}
+		url, ok := singleImageURL(req)
+		if !ok || req.N > 1 {
+			return nil, fmt.Errorf("%w: the OpenAI service only edits a single image given by URL", ErrInvalidParameters)
+		}
+		img, err := svc.GenerateGPTImage1WithImage(ctx, req.Prompt, url)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{img}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytesResponse(OpenAIProvider, ProviderGPTImage1, res.([][]byte)...)
+}
+
+func (g *APIGateway) imageFile(ctx context.Context, in ImageInput) (openai.ImageFile, error) {
+	data, err := g.providers.loadImage(ctx, in)
+	if err != nil {
+		return openai.ImageFile{}, err
+	}
+	return openai.ImageFile{Data: data}, nil
+}
+
+func (g *APIGateway) editGeminiFlash(ctx context.Context, req EditRequest) (*ImageResponse, error) {
+	var images [][]byte
+	for _, in := range req.Images {
+		data, err := g.providers.loadImage(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, data)
+	}
+	return g.geminiImage(ctx, ProviderGemini20FlashExpImageGeneration, func(svc gemini.GeminiService) ([]byte, error) {
+		if ed, ok := svc.(gemini.FlashImageEditor); ok {
+			return ed.GenerateFlashWithImages(ctx, req.Prompt, images)
+		}
+		url, ok := singleImageURL(req)
+		if !ok {
+			return nil, fmt.Errorf("%w: the Gemini service only edits a single image given by URL", ErrInvalidParameters)
+		}
+		return svc.GenerateFlashWithImage(ctx, req.Prompt, url)
+	})
+}
+
+// singleImageURL returns the URL of the only image of an unmasked edit, the
+// one request services without multi-image editing can still serve.
+func singleImageURL(req EditRequest) (string, bool) {
+	if len(req.Images) != 1 || req.Mask != nil || req.Images[0].URL == "" || len(req.Images[0].Data) > 0 {
+		return "", false
+	}
+	return req.Images[0].URL, true
+}
+
+// replicateEditService adapts a Replicate edit model. The image is sent as
+// "image", the mask as "mask" and further images as "reference_images";
+// models using other input names can be driven through Extra, which takes
+// precedence.
+func (g *APIGateway) replicateEditService(model string) *editService {
+	return &editService{
+		name:     model,
+		supports: ImageFieldMask | ImageFieldReferenceImages | ImageFieldExtra,
+		fn: func(ctx context.Context, req EditRequest) (*ImageResponse, error) {
+			res, err := g.providers.withReplicate(ctx, model, func(svc replicate.ReplicateService) (any, error) {
+				input := map[string]any{}
+				uris := make([]string, len(req.Images))
+				for i, in := range req.Images {
+					uri, err := g.providers.replicateInput(ctx, svc, in)
+					if err != nil {
+						return nil, err
+					}
+					uris[i] = uri
+				}
+				input["image"] = uris[0]
+				if len(uris) > 1 {
+					input["reference_images"] = uris[1:]
+				}
+				if req.Mask != nil {
+					uri, err := g.providers.replicateInput(ctx, svc, *req.Mask)
+					if err != nil {
+						return nil, err
+					}
+					input["mask"] = uri
+				}
+				maps.Copy(input, req.Extra)
+				return runAll(ctx, svc, model, req.Prompt, input)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return urlResponse(ReplicateProvider, model, res.([]string)...)
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package genailib
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGatewayImageToImageOpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/edits" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got := len(r.MultipartForm.File["image[]"]); got != 2 {
+			t.Errorf("expected 2 images, got %d", got)
+		}
+		if len(r.MultipartForm.File["mask"]) != 1 || r.FormValue("model") != ProviderGPTImage1 {
+			t.Errorf("unexpected form: %v", r.MultipartForm.Value)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": []map[string]any{{"b64_json": base64.StdEncoding.EncodeToString(pngMagic)}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	g := NewAPIGateway(WithOpenAIKey("test"), WithOpenAIBaseURL(srv.URL))
+
+	req := EditRequest{
+		Prompt: "put the hat on the cat",
+		Images: []ImageInput{{Data: pngMagic}, {URL: "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngMagic)}},
+		Mask:   &ImageInput{Data: pngMagic},
+	}
+	res, err := g.ImageToImage(context.Background(), req, ProviderGPTImage1)
+	if err != nil {
+		t.Fatalf("ImageToImage returned error: %v", err)
+	}
+	if len(res.Images) != 1 || res.Images[0].MIMEType != "image/png" || res.Usage.Requests != 1 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+}
+
+func TestImageEditReplicate(t *testing.T) {
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngMagic)
+	}))
+	t.Cleanup(src.Close)
+	rep := fakeReplicateServer(t, "https://example.com/out.png", func(input map[string]any) {
+		if input["image"] != src.URL+"/cat.png" {
+			t.Errorf("unexpected image input %v", input["image"])
+		}
+		if refs, ok := input["reference_images"].([]any); !ok || len(refs) != 1 {
+			t.Errorf("unexpected reference images %v", input["reference_images"])
+		}
+		if input["mask"] == nil || input["strength"] != 0.5 {
+			t.Errorf("unexpected input %v", input)
+		}
+	})
+	svc := NewImageService(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+
+	res, err := svc.Edit(context.Background(), ReplicateProvider, "owner/inpaint",
+		[]any{src.URL + "/cat.png", pngMagic}, "a red hat",
+		map[string]any{"mask": pngMagic, "strength": 0.5})
+	if err != nil {
+		t.Fatalf("Edit returned error: %v", err)
+	}
+	if res.Images[0].URL != "https://example.com/out.png" {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+}
+
+func TestImageEditErrors(t *testing.T) {
+	svc := NewImageService()
+	ctx := context.Background()
+
+	if _, err := svc.Edit(ctx, OpenAIProvider, "", nil, "a hat", nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters without an image, got %v", err)
+	}
+	if _, err := svc.Edit(ctx, GeminiProvider, "", pngMagic, "a hat", map[string]any{"mask": pngMagic}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for unsupported mask, got %v", err)
+	}
+	if _, err := svc.Edit(ctx, OpenAIProvider, "", 42, "a hat", nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for bad input type, got %v", err)
+	}
+	if _, err := svc.Edit(ctx, OpenAIProvider, "", ImageInput{Object: "cat.png"}, "a hat", nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters without storage, got %v", err)
+	}
+	svc = NewImageService(WithStorage(uploadOnlyStorage{}))
+	if _, err := svc.Edit(ctx, OpenAIProvider, "", ImageInput{Object: "cat.png"}, "a hat", nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters from a storage that cannot download, got %v", err)
+	}
+}
+
+// uploadOnlyStorage implements storage.Storage without storage.Downloader.
+type uploadOnlyStorage struct{}
+
+func (uploadOnlyStorage) Upload(ctx context.Context, data []byte, objectName string) (string, error) {
+	return "https://example.com/" + objectName, nil
+}
@@ -0,0 +1,84 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+)
+
+// UpscaleRequest describes an image upscale.
+type UpscaleRequest struct {
+	Image ImageInput
+	// Scale is the upscaling factor, 4 by default.
+	Scale int
+	// FaceEnhance runs face restoration on models that support it.
+	FaceEnhance bool
+	// Extra holds model specific inputs that are passed through unchanged.
+	Extra map[string]any
+}
+
+// BackgroundRemovalRequest describes a background removal. The result is a
+// PNG with a transparent background.
+type BackgroundRemovalRequest struct {
+	Image ImageInput
+	// Extra holds model specific inputs that are passed through unchanged.
+	Extra map[string]any
+}
+
+// Upscale enlarges an image with a Replicate upscaling model, by default
+// ProviderRealESRGAN.
+func (g *APIGateway) Upscale(ctx context.Context, req UpscaleRequest, model string) (*ImageResponse, error) {
+	if model == "" {
+		model = ProviderRealESRGAN
+	}
+	scale := req.Scale
+	if scale == 0 {
+		scale = 4
+	}
+	if scale < 1 || scale > 10 {
+		return nil, fmt.Errorf("%w: scale must be between 1 and 10", ErrInvalidParameters)
+	}
+	input := map[string]any{"scale": scale}
+	if req.FaceEnhance {
+		input["face_enhance"] = true
+	}
+	maps.Copy(input, req.Extra)
+	return g.runImageModel(ctx, "Upscale", model, req.Image, input)
+}
+
+// RemoveBackground cuts the subject out of an image with a Replicate matting
+// model, by default ProviderRembg.
+func (g *APIGateway) RemoveBackground(ctx context.Context, req BackgroundRemovalRequest, model string) (*ImageResponse, error) {
+	if model == "" {
+		model = ProviderRembg
+	}
+	return g.runImageModel(ctx, "RemoveBackground", model, req.Image, maps.Clone(req.Extra))
+}
+
+// runImageModel runs a Replicate model taking a single "image" input and no
+// prompt.
+func (g *APIGateway) runImageModel(ctx context.Context, op, model string, image ImageInput, input map[string]any) (*ImageResponse, error) {
+	if !strings.Contains(model, "/") {
+		return nil, fmt.Errorf("%w: replicate model must be of the form owner/name, got %q", ErrInvalidParameters, model)
+	}
+	if input == nil {
+		input = map[string]any{}
+	}
+	return g.call(ctx, op, model, 1, func(ctx context.Context) (*ImageResponse, error) {
+		res, err := g.providers.withReplicate(ctx, model, func(svc replicate.ReplicateService) (any, error) {
+			uri, err := g.providers.replicateInput(ctx, svc, image)
+			if err != nil {
+				return nil, err
+			}
+			input["image"] = uri
+			return runAll(ctx, svc, model, "", input)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return urlResponse(ReplicateProvider, model, res.([]string)...)
+	})
+}
@@ -0,0 +1,75 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImageUpscale(t *testing.T) {
+	var got map[string]any
+	rep := fakeReplicateServer(t, "https://example.com/big.png", func(input map[string]any) { got = input })
+	svc := NewImageService(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+	ctx := context.Background()
+
+	res, err := svc.Upscale(ctx, "", pngMagic, map[string]any{"scale": 2, "face_enhance": true})
+	if err != nil {
+		t.Fatalf("Upscale returned error: %v", err)
+	}
+	if res.Images[0].URL != "https://example.com/big.png" || res.Images[0].Model != ProviderRealESRGAN {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	if got["scale"] != float64(2) || got["face_enhance"] != true || !strings.HasPrefix(got["image"].(string), "data:image/png;base64,") {
+		t.Fatalf("unexpected input %v", got)
+	}
+
+	// Large images are uploaded instead of inlined.
+	big := append(bytes.Clone(pngMagic), make([]byte, maxDataURIBytes)...)
+	if _, err := svc.Upscale(ctx, "", big, nil); err != nil {
+		t.Fatalf("Upscale returned error: %v", err)
+	}
+	if got["image"] != rep.URL+"/files/upload" || got["scale"] != float64(4) {
+		t.Fatalf("unexpected input %v", got)
+	}
+
+	if _, err := svc.Upscale(ctx, "", pngMagic, map[string]any{"scale": 20}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for scale, got %v", err)
+	}
+	if _, err := svc.Upscale(ctx, "", []any{pngMagic, pngMagic}, nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for two images, got %v", err)
+	}
+	if _, err := svc.Upscale(ctx, "esrgan", pngMagic, nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for model name, got %v", err)
+	}
+}
+
+func TestWorkflowRemoveBackground(t *testing.T) {
+	rep := fakeReplicateServer(t, "https://example.com/cutout.png", func(input map[string]any) {
+		if input["image"] != "https://example.com/photo.png" {
+			t.Errorf("unexpected input %v", input)
+		}
+	})
+	svc := NewWorkflowService(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+	wf := &Workflow{Steps: []WorkflowStep{
+		{ID: "cutout", FunctionType: FunctionTypeRemoveBackground, Image: "photo"},
+		{ID: "square", FunctionType: FunctionTypeProcessImage, Image: "cutout", Options: map[string]any{"aspect_ratio": "1:1"}},
+	}}
+	if err := ValidateWorkflow(wf); err != nil {
+		t.Fatalf("ValidateWorkflow returned error: %v", err)
+	}
+
+	result, _, err := svc.Generate(context.Background(), &Workflow{Steps: wf.Steps[:1]}, map[string]any{"photo": "https://example.com/photo.png"})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if art, ok := result.(*Artifact); !ok || art.URL != "https://example.com/cutout.png" || art.Model != ProviderRembg {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	wf.Steps[0].Provider = ProviderFluxSchnell
+	if err := ValidateWorkflow(wf); err == nil {
+		t.Fatal("expected error for a model without background removal")
+	}
+}
@@ -0,0 +1,383 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GIFOptions controls ExportGIF. Zero fields take the defaults below.
+type GIFOptions struct {
+	// Width of the GIF, 480 by default. The height keeps the aspect ratio.
+	Width int
+	// FPS is the frame rate, 12 by default.
+	FPS float64
+	// Start and Duration select the part of the video to convert; a zero
+	// Duration runs to the end.
+	Start    time.Duration
+	Duration time.Duration
+	// Colors is the palette size between 2 and 256, 256 by default.
+	Colors int
+	// PlayOnce stops the animation after one play instead of looping.
+	PlayOnce bool
+}
+
+// ExportGIF converts video to an animated GIF. A palette is generated from
+// the clip itself, which keeps gradients far cleaner than ffmpeg's default
+// palette. ffmpeg and ffprobe must be installed and accessible on the
+// system PATH.
+func ExportGIF(video []byte, opts GIFOptions) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, GIFOp(opts))
+}
+
+// GIFOp is the operation run by ExportGIF.
+func GIFOp(opts GIFOptions) VideoOp {
+	if opts.Width == 0 {
+		opts.Width = 480
+	}
+	if opts.FPS == 0 {
+		opts.FPS = 12
+	}
+	if opts.Colors == 0 {
+		opts.Colors = 256
+	}
+	if opts.Width < 2 || opts.FPS < 0 || opts.FPS > 50 {
+		return invalidOp(fmt.Errorf("%w: invalid GIF width %d or frame rate %g", ErrInvalidParameters, opts.Width, opts.FPS))
+	}
+	if opts.Colors < 2 || opts.Colors > 256 {
+		return invalidOp(fmt.Errorf("%w: GIF colors must be between 2 and 256", ErrInvalidParameters))
+	}
+	if opts.Start < 0 || opts.Duration < 0 {
+		return invalidOp(fmt.Errorf("%w: start and duration must not be negative", ErrInvalidParameters))
+	}
+	return VideoOp{format: "gif", args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		if opts.Start >= info.Duration {
+			return nil, fmt.Errorf("%w: start %v is past the end of the video", ErrInvalidParameters, opts.Start)
+		}
+		args := []string{"-ss", seconds(opts.Start), "-i", in}
+		if opts.Duration > 0 {
+			args = append(args, "-t", seconds(opts.Duration))
+		}
+		loop := "0"
+		if opts.PlayOnce {
+			loop = "-1"
+		}
+		filter := fmt.Sprintf(
+			"fps=%s,scale=%d:-1:flags=lanczos,split[a][b];[a]palettegen=max_colors=%d:stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+			strconv.FormatFloat(opts.FPS, 'f', -1, 64), opts.Width, opts.Colors,
+		)
+		return append(args, "-filter_complex", filter, "-loop", loop, "-f", "gif", "-y", out), nil
+	}}
+}
+
+// WebMOptions controls ExportWebM.
+type WebMOptions struct {
+	// CRF is the VP9 quality between 0 (best) and 63, 31 by default.
+	CRF int
+	// Width scales the video, keeping its aspect ratio. Zero keeps the size.
+	Width int
+}
+
+// ExportWebM converts video to WebM with VP9 video and Opus audio. ffmpeg
+// and ffprobe must be installed and accessible on the system PATH.
+func ExportWebM(video []byte, opts WebMOptions) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, WebMOp(opts))
+}
+
+// WebMOp is the operation run by ExportWebM.
+func WebMOp(opts WebMOptions) VideoOp {
+	if opts.CRF == 0 {
+		opts.CRF = 31
+	}
+	if opts.CRF < 0 || opts.CRF > 63 {
+		return invalidOp(fmt.Errorf("%w: WebM CRF must be between 0 and 63", ErrInvalidParameters))
+	}
+	if opts.Width < 0 {
+		return invalidOp(fmt.Errorf("%w: negative width", ErrInvalidParameters))
+	}
+	return VideoOp{format: "webm", args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		args := []string{"-i", in}
+		if opts.Width > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", opts.Width))
+		}
+		return append(args,
+			"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", strconv.Itoa(opts.CRF), "-row-mt", "1",
+			"-pix_fmt", "yuv420p", "-c:a", "libopus",
+			"-f", "webm", "-y", out,
+		), nil
+	}}
+}
+
+// HLSOptions controls ExportHLS.
+type HLSOptions struct {
+	// SegmentDuration is the target segment length, 6 seconds by default.
+	SegmentDuration time.Duration
+}
+
+// HLSPackage is a video packaged for HTTP Live Streaming. Files holds the
+// playlist and its MPEG-TS segments by file name; serve them from one
+// directory and point players at Playlist.
+type HLSPackage struct {
+	Playlist string
+	Files    map[string][]byte
+}
+
+// ExportHLS packages video as a single rendition video-on-demand HLS
+// stream. Keyframes are forced at segment boundaries so that every segment
+// has the target length. ffmpeg and ffprobe must be installed and
+// accessible on the system PATH.
+func ExportHLS(video []byte, opts HLSOptions) (*HLSPackage, error) {
+	j, err := defaultFFmpeg.newJob(context.Background(), "hls")
+	if err != nil {
+		return nil, err
+	}
+	defer j.close()
+	in, err := j.write("input.mp4", video)
+	if err != nil {
+		return nil, err
+	}
+	outDir := j.path("hls")
+	if err := os.Mkdir(outDir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed to create output dir")
+	}
+	playlist, err := j.exportHLS(in, outDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list HLS files")
+	}
+	pkg := &HLSPackage{Playlist: playlist, Files: make(map[string][]byte, len(entries))}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(outDir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", e.Name())
+		}
+		pkg.Files[e.Name()] = data
+	}
+	return pkg, nil
+}
+
+// ExportHLSFile is ExportHLS for the video file at in, writing the playlist
+// and segments to the existing directory dir. It returns the path of the
+// playlist.
+func (f *FFmpeg) ExportHLSFile(ctx context.Context, in, dir string, opts HLSOptions) (string, error) {
+	if err := f.checkInput(in); err != nil {
+		return "", err
+	}
+	j, err := f.newJob(ctx, "hls")
+	if err != nil {
+		return "", err
+	}
+	defer j.close()
+	playlist, err := j.exportHLS(in, dir, opts)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, playlist), nil
+}
+
+// exportHLS writes the HLS package of the video at in to dir, returning the
+// playlist name. MaxOutputSize limits the package as a whole.
+func (j *job) exportHLS(in, dir string, opts HLSOptions) (string, error) {
+	if opts.SegmentDuration == 0 {
+		opts.SegmentDuration = 6 * time.Second
+	}
+	if opts.SegmentDuration < time.Second {
+		return "", fmt.Errorf("%w: HLS segments must be at least a second long", ErrInvalidParameters)
+	}
+	if _, err := j.probe(in, "video", true, false); err != nil {
+		return "", err
+	}
+
+	const playlist = "index.m3u8"
+	segment := seconds(opts.SegmentDuration)
+	args := append([]string{"-i", in}, encodeArgs...)
+	args = append(args,
+		"-force_key_frames", "expr:gte(t,n_forced*"+segment+")",
+		"-f", "hls",
+		"-hls_time", segment,
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "segment%03d.ts"),
+		"-y", filepath.Join(dir, playlist),
+	)
+	if err := j.f.run(j.ctx, nil, args...); err != nil {
+		return "", err
+	}
+
+	// Count only the playlist and the segments it lists, dir may hold
+	// other files.
+	data, err := os.ReadFile(filepath.Join(dir, playlist))
+	if err != nil {
+		return "", errors.Wrap(err, "ffmpeg wrote no HLS playlist")
+	}
+	size := int64(len(data))
+	for _, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		st, err := os.Stat(name)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read HLS segment")
+		}
+		size += st.Size()
+	}
+	if err := checkSize("HLS package", size, j.f.MaxOutputSize); err != nil {
+		return "", err
+	}
+	return playlist, nil
+}
+
+// VideoPreset is an output frame size for a publishing platform.
+type VideoPreset struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Platform presets. PresetVertical suits Reels, Shorts and TikTok,
+// PresetSquare feed posts and PresetLandscape YouTube and the web.
+var (
+	PresetVertical  = VideoPreset{Name: "vertical", Width: 1080, Height: 1920}
+	PresetSquare    = VideoPreset{Name: "square", Width: 1080, Height: 1080}
+	PresetLandscape = VideoPreset{Name: "landscape", Width: 1920, Height: 1080}
+)
+
+// VideoPresets lists the platform presets.
+func VideoPresets() []VideoPreset {
+	return []VideoPreset{PresetVertical, PresetSquare, PresetLandscape}
+}
+
+// LookupVideoPreset returns the platform preset called name.
+func LookupVideoPreset(name string) (VideoPreset, bool) {
+	i := slices.IndexFunc(VideoPresets(), func(p VideoPreset) bool { return p.Name == name })
+	if i < 0 {
+		return VideoPreset{}, false
+	}
+	return VideoPresets()[i], true
+}
+
+// PresetOptions controls how ExportPreset fits a video whose aspect ratio
+// differs from the preset's, with the same meaning as in ImageOps.
+type PresetOptions struct {
+	// Fit defaults to FitCover, which crops the video to the preset's
+	// aspect ratio. FitContain pads it instead and FitFill stretches it.
+	Fit ImageFit
+	// Anchor selects the cropped window. AnchorSmart follows the part of
+	// the frame with the most detail across a few sampled frames.
+	Anchor CropAnchor
+	// Background fills the padding of FitContain. Nil pads with a blurred,
+	// enlarged copy of the video.
+	Background color.Color
+}
+
+// ExportPreset reframes video to the size of preset. ffmpeg and ffprobe
+// must be installed and accessible on the system PATH. Run PresetOp with
+// FFmpeg.Transform or FFmpeg.TransformFile to reframe streams and files.
+func ExportPreset(video []byte, preset VideoPreset, opts PresetOptions) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, PresetOp(preset, opts))
+}
+
+// PresetOp is the operation run by ExportPreset.
+func PresetOp(preset VideoPreset, opts PresetOptions) VideoOp {
+	if preset.Width < 2 || preset.Height < 2 || preset.Width%2 != 0 || preset.Height%2 != 0 {
+		return invalidOp(fmt.Errorf("%w: preset size %dx%d must be even and positive", ErrInvalidParameters, preset.Width, preset.Height))
+	}
+	switch opts.Fit {
+	case "", FitCover, FitContain, FitFill:
+	default:
+		return invalidOp(fmt.Errorf("%w: unknown fit %q", ErrInvalidParameters, opts.Fit))
+	}
+	switch opts.Anchor {
+	case "", AnchorCenter, AnchorSmart:
+	default:
+		return invalidOp(fmt.Errorf("%w: unknown crop anchor %q", ErrInvalidParameters, opts.Anchor))
+	}
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		filter, err := presetFilter(&frameSource{job: j, path: in, info: info}, preset, opts)
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"-i", in, "-filter_complex", filter, "-map", "[v]", "-map", "0:a?"}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// presetFilter returns the filter graph bringing the first video stream of
+// src to the preset size as [v].
+func presetFilter(src *frameSource, preset VideoPreset, opts PresetOptions) (string, error) {
+	w, h := preset.Width, preset.Height
+	switch opts.Fit {
+	case FitFill:
+		return fmt.Sprintf("[0:v]scale=%d:%d,setsar=1[v]", w, h), nil
+	case FitContain:
+		fit := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", w, h)
+		if opts.Background != nil {
+			n := color.NRGBAModel.Convert(opts.Background).(color.NRGBA)
+			return fmt.Sprintf("[0:v]%s,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=0x%02X%02X%02X,setsar=1[v]", fit, w, h, n.R, n.G, n.B), nil
+		}
+		return fmt.Sprintf(
+			"[0:v]split[bg][fg];[bg]scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,boxblur=20:2[blur];[fg]%s[fit];[blur][fit]overlay=(W-w)/2:(H-h)/2,setsar=1[v]",
+			w, h, w, h, fit,
+		), nil
+	}
+
+	vw, vh := src.info.Video.Width, src.info.Video.Height
+	cw, ch := vw, vw*h/w
+	if ch > vh {
+		cw, ch = vh*w/h, vh
+	}
+	cw, ch = max(cw&^1, 2), max(ch&^1, 2)
+	offset := (vw - cw) / 2
+	if cw == vw {
+		offset = (vh - ch) / 2
+	}
+	if opts.Anchor == AnchorSmart && (cw < vw || ch < vh) {
+		var err error
+		if offset, err = smartVideoCropOffset(src, cw, ch); err != nil {
+			return "", err
+		}
+	}
+	x, y := offset, 0
+	if cw == vw {
+		x, y = 0, offset
+	}
+	return fmt.Sprintf("[0:v]crop=%d:%d:%d:%d,scale=%d:%d,setsar=1[v]", cw, ch, x, y, w, h), nil
+}
+
+// smartVideoCropOffset runs the image smart crop on a few frames sampled
+// across the video and returns the median offset, so the crop follows the
+// subject without jumping between frames.
+func smartVideoCropOffset(src *frameSource, cw, ch int) (int, error) {
+	const samples = 5
+	offsets := make([]int, 0, samples)
+	for i := range samples {
+		t := src.info.Duration * time.Duration(2*i+1) / (2 * samples)
+		data, err := src.at(fmt.Sprintf("crop%d", i), t)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to sample frame %d", i)
+		}
+		img, _, err := DecodeImage(data)
+		if err != nil {
+			return 0, err
+		}
+		offsets = append(offsets, smartCropOffset(img, cw, ch))
+	}
+	slices.Sort(offsets)
+	return offsets[len(offsets)/2], nil
+}
@@ -0,0 +1,122 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/color"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresetFilter(t *testing.T) {
+	src := &frameSource{info: &MediaInfo{Duration: time.Second, Video: &VideoStream{Width: 1920, Height: 1080}}}
+	cases := []struct {
+		preset VideoPreset
+		opts   PresetOptions
+		want   string
+	}{
+		{PresetVertical, PresetOptions{}, "[0:v]crop=606:1080:657:0,scale=1080:1920,setsar=1[v]"},
+		{PresetSquare, PresetOptions{Anchor: AnchorCenter}, "[0:v]crop=1080:1080:420:0,scale=1080:1080,setsar=1[v]"},
+		{PresetLandscape, PresetOptions{Anchor: AnchorSmart}, "[0:v]crop=1920:1080:0:0,scale=1920:1080,setsar=1[v]"},
+		{PresetSquare, PresetOptions{Fit: FitFill}, "[0:v]scale=1080:1080,setsar=1[v]"},
+		{
+			PresetVertical, PresetOptions{Fit: FitContain, Background: color.White},
+			"[0:v]scale=1080:1920:force_original_aspect_ratio=decrease,pad=1080:1920:(ow-iw)/2:(oh-ih)/2:color=0xFFFFFF,setsar=1[v]",
+		},
+	}
+	for _, c := range cases {
+		got, err := presetFilter(src, c.preset, c.opts)
+		if err != nil {
+			t.Fatalf("presetFilter returned error: %v", err)
+		}
+		if got != c.want {
+			t.Errorf("presetFilter(%s, %+v)\n got %s\nwant %s", c.preset.Name, c.opts, got, c.want)
+		}
+	}
+	blur, _ := presetFilter(src, PresetVertical, PresetOptions{Fit: FitContain})
+	if !strings.Contains(blur, "boxblur") || !strings.HasSuffix(blur, "[v]") {
+		t.Fatalf("unexpected blurred padding filter %s", blur)
+	}
+
+	if p, ok := LookupVideoPreset("square"); !ok || p != PresetSquare {
+		t.Fatalf("unexpected preset %+v", p)
+	}
+	if _, ok := LookupVideoPreset("portrait"); ok {
+		t.Fatal("expected no preset called portrait")
+	}
+}
+
+func TestExportInvalid(t *testing.T) {
+	checks := map[string]error{}
+	_, checks["gif colors"] = ExportGIF(nil, GIFOptions{Colors: 300})
+	_, checks["gif start"] = ExportGIF(nil, GIFOptions{Start: -time.Second})
+	_, checks["webm crf"] = ExportWebM(nil, WebMOptions{CRF: 70})
+	_, checks["hls segment"] = ExportHLS(nil, HLSOptions{SegmentDuration: time.Millisecond})
+	_, checks["odd preset"] = ExportPreset(nil, VideoPreset{Width: 1081, Height: 1920}, PresetOptions{})
+	_, checks["fit"] = ExportPreset(nil, PresetSquare, PresetOptions{Fit: "stretch"})
+	_, checks["anchor"] = ExportPreset(nil, PresetSquare, PresetOptions{Anchor: "left"})
+	for name, err := range checks {
+		if !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("%s: expected ErrInvalidParameters, got %v", name, err)
+		}
+	}
+}
+
+func TestExportVideo(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+
+	gif, err := ExportGIF(vid, GIFOptions{Width: 160, FPS: 5})
+	if err != nil {
+		t.Fatalf("ExportGIF returned error: %v", err)
+	}
+	if !bytes.HasPrefix(gif, []byte("GIF8")) {
+		t.Fatalf("output is not a GIF: %q", gif[:8])
+	}
+
+	for _, opts := range []PresetOptions{{Anchor: AnchorSmart}, {Fit: FitContain}} {
+		out, err := ExportPreset(vid, PresetVertical, opts)
+		if err != nil {
+			t.Fatalf("ExportPreset(%+v) returned error: %v", opts, err)
+		}
+		info, err := ProbeMedia(out)
+		if err != nil {
+			t.Fatalf("ProbeMedia returned error: %v", err)
+		}
+		if info.Video.Width != 1080 || info.Video.Height != 1920 {
+			t.Fatalf("unexpected size %dx%d", info.Video.Width, info.Video.Height)
+		}
+	}
+
+	pkg, err := ExportHLS(vid, HLSOptions{SegmentDuration: time.Second})
+	if err != nil {
+		t.Fatalf("ExportHLS returned error: %v", err)
+	}
+	if !strings.Contains(string(pkg.Files[pkg.Playlist]), "#EXT-X-ENDLIST") || len(pkg.Files) < 2 {
+		t.Fatalf("unexpected HLS package with %d files", len(pkg.Files))
+	}
+}
+
+func TestWorkflowExportVideoOptions(t *testing.T) {
+	svc := NewWorkflowService()
+	inputs := map[string]any{"clip": []byte("video")}
+	for _, opts := range []map[string]any{
+		{"format": "avi"},
+		{"preset": "portrait"},
+		{"format": "webm", "fps": 10},
+		{"preset": "square", "crf": 30},
+	} {
+		wf := &Workflow{Steps: []WorkflowStep{{ID: "export", FunctionType: FunctionTypeExportVideo, Video: "clip", Options: opts}}}
+		if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %v, got %v", opts, err)
+		}
+	}
+}
@@ -2,14 +2,15 @@ package gemini
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	"google.golang.org/genai"
 )
 
@@ -20,6 +21,12 @@ const (
 	VEO_3_PREVIEW_MODEL = "veo-3.0-generate-preview"
 )
 
+// Errors returned when Imagen or the Flash model does not produce an image.
+var (
+	ErrSafetyBlocked = errors.New("blocked by safety filters")
+	ErrNoImage       = errors.New("no image in response")
+)
+
 type GeminiService interface {
 	GenerateImagen3Image(ctx context.Context, prompt string) ([]byte, error)
 	GenerateFlash2Image(ctx context.Context, prompt string) ([]byte, error)
@@ -31,8 +38,38 @@ type GeminiService interface {
 	GenerateVeo3PreviewVideoWithStartFrameURL(ctx context.Context, prompt, firstFrameURL string) ([]byte, error)
 }
 
+// ImagenGenerator is implemented by services that can pass options to
+// Imagen and return several images, as the one returned by
+// NewGeminiService does. It is kept out of GeminiService so that existing
+// implementations of that interface stay valid.
+type ImagenGenerator interface {
+	// GenerateImagen3Images returns every image Imagen generates for prompt.
+	GenerateImagen3Images(ctx context.Context, prompt string, opts ImagenOptions) ([][]byte, error)
+}
+
+// FlashImageEditor is implemented by services that can edit several images
+// with the Flash model, as the one returned by NewGeminiService does. It is
+// kept out of GeminiService so that existing implementations of that
+// interface stay valid.
+type FlashImageEditor interface {
+	// GenerateFlashWithImages edits or combines images according to prompt.
+	GenerateFlashWithImages(ctx context.Context, prompt string, images [][]byte) ([]byte, error)
+}
+
 type geminiService struct {
-	client *genai.Client
+	client     *genai.Client
+	httpClient *http.Client
+	vertex     bool
+}
+
+// ImagenOptions configures Imagen generation. Zero values use the model
+// defaults.
+type ImagenOptions struct {
+	// N is the number of images to generate, at most 4.
+	N int
+	// Seed makes generation reproducible. It is only available on Vertex
+	// AI.
+	Seed *int32
 }
 
 func waitAndDownloadVideo(ctx context.Context, client *genai.Client, op *genai.GenerateVideosOperation) ([]byte, error) {
@@ -50,63 +87,193 @@ func waitAndDownloadVideo(ctx context.Context, client *genai.Client, op *genai.G
 	return nil, fmt.Errorf("video generation did not return a result")
 }
 
-func NewGeminiService() GeminiService {
-	apiKey := os.Getenv("GEMINI_API_KEY")
+// Config holds the settings used to build a GeminiService.
+type Config struct {
+	APIKey string
+	// UseVertexAI selects the Vertex AI backend instead of the Gemini API.
+	UseVertexAI bool
+	Project     string
+	Location    string
+	// BaseURL overrides the default endpoint of the selected backend.
+	BaseURL string
+	// HTTPClient is used for all API requests. A default client is used when
+	// nil. Leave it unset to authenticate to Vertex AI with default credentials.
+	HTTPClient *http.Client
+}
 
-	useVertex := false
+// ConfigFromEnv returns a Config populated from the GEMINI_API_KEY,
+// GOOGLE_GENAI_USE_VERTEXAI, GOOGLE_CLOUD_PROJECT and GOOGLE_CLOUD_LOCATION
+// (or GOOGLE_CLOUD_REGION) environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		APIKey:  os.Getenv("GEMINI_API_KEY"),
+		Project: os.Getenv("GOOGLE_CLOUD_PROJECT"),
+	}
 	if v := strings.ToLower(os.Getenv("GOOGLE_GENAI_USE_VERTEXAI")); v == "1" || v == "true" {
-		useVertex = true
+		cfg.UseVertexAI = true
 	}
+	if loc := os.Getenv("GOOGLE_CLOUD_LOCATION"); loc != "" {
+		cfg.Location = loc
+	} else if loc := os.Getenv("GOOGLE_CLOUD_REGION"); loc != "" {
+		cfg.Location = loc
+	}
+	return cfg
+}
 
-	cfg := &genai.ClientConfig{APIKey: apiKey}
-	if useVertex {
-		cfg.Backend = genai.BackendVertexAI
-		if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
-			cfg.Project = project
-		}
-		if loc := os.Getenv("GOOGLE_CLOUD_LOCATION"); loc != "" {
-			cfg.Location = loc
-		} else if loc := os.Getenv("GOOGLE_CLOUD_REGION"); loc != "" {
-			cfg.Location = loc
+// NewGeminiService returns a GeminiService configured by cfg.
+func NewGeminiService(ctx context.Context, cfg Config) (GeminiService, error) {
+	clientCfg := &genai.ClientConfig{
+		APIKey:      cfg.APIKey,
+		Backend:     genai.BackendGeminiAPI,
+		HTTPClient:  cfg.HTTPClient,
+		HTTPOptions: genai.HTTPOptions{BaseURL: cfg.BaseURL},
+	}
+	if cfg.UseVertexAI {
+		clientCfg.Backend = genai.BackendVertexAI
+		// An API key selects Vertex AI express mode, which genai does not
+		// allow to be combined with a project or location.
+		if cfg.APIKey == "" {
+			clientCfg.Project = cfg.Project
+			clientCfg.Location = cfg.Location
 		}
 	}
 
-	client, err := genai.NewClient(context.Background(), cfg)
+	client, err := genai.NewClient(ctx, clientCfg)
 	if err != nil {
-		log.Fatalf("failed to create genai client: %v", err)
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
 	}
-	return &geminiService{
-		client: client,
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
 	}
+	return &geminiService{
+		client:     client,
+		httpClient: httpClient,
+		vertex:     cfg.UseVertexAI,
+	}, nil
 }
 
 func (s *geminiService) GenerateImagen3Image(ctx context.Context, prompt string) ([]byte, error) {
-	resp, err := s.client.Models.GenerateImages(ctx, IMAGEN_3_MODEL, prompt, nil)
+	images, err := s.GenerateImagen3Images(ctx, prompt, ImagenOptions{})
 	if err != nil {
 		return nil, err
 	}
-	if len(resp.GeneratedImages) > 0 && resp.GeneratedImages[0].Image != nil {
-		return resp.GeneratedImages[0].Image.ImageBytes, nil
-	}
-	return nil, nil
+	return images[0], nil
 }
 
-func (s *geminiService) GenerateFlash2Image(ctx context.Context, prompt string) ([]byte, error) {
-	resp, err := s.client.Models.GenerateImages(ctx, FLASH_2_MODEL, prompt, nil)
+// GenerateImagen3Images returns every image Imagen generates for prompt.
+// When every image is filtered out it fails with ErrSafetyBlocked, or with
+// ErrNoImage when Imagen gives no reason.
+func (s *geminiService) GenerateImagen3Images(ctx context.Context, prompt string, opts ImagenOptions) ([][]byte, error) {
+	if opts.Seed != nil && !s.vertex {
+		return nil, fmt.Errorf("seed is only supported on Vertex AI")
+	}
+	var cfg *genai.GenerateImagesConfig
+	if opts.N > 0 || opts.Seed != nil {
+		cfg = &genai.GenerateImagesConfig{NumberOfImages: int32(opts.N), Seed: opts.Seed}
+	}
+	resp, err := s.client.Models.GenerateImages(ctx, IMAGEN_3_MODEL, prompt, cfg)
 	if err != nil {
 		return nil, err
 	}
-	if len(resp.GeneratedImages) > 0 && resp.GeneratedImages[0].Image != nil {
-		return resp.GeneratedImages[0].Image.ImageBytes, nil
+	var images [][]byte
+	var reasons []string
+	for _, img := range resp.GeneratedImages {
+		if img.Image != nil && len(img.Image.ImageBytes) > 0 {
+			images = append(images, img.Image.ImageBytes)
+		} else if img.RAIFilteredReason != "" {
+			reasons = append(reasons, img.RAIFilteredReason)
+		}
+	}
+	switch {
+	case len(images) > 0:
+		return images, nil
+	case len(reasons) > 0:
+		return nil, fmt.Errorf("%w: %s", ErrSafetyBlocked, strings.Join(reasons, "; "))
 	}
-	return nil, nil
+	return nil, ErrNoImage
+}
+
+// GenerateFlash2Image generates an image from the prompt with the Gemini
+// Flash image generation model. A reply without an image is reported as
+// ErrNoImage.
+func (s *geminiService) GenerateFlash2Image(ctx context.Context, prompt string) ([]byte, error) {
+	return s.generateFlashContent(ctx, prompt)
 }
 
+// GenerateFlashWithImage downloads the image at imageURL and edits it
+// according to the prompt using the Gemini Flash image generation model.
 func (s *geminiService) GenerateFlashWithImage(ctx context.Context, prompt, imageURL string) ([]byte, error) {
-	// Download image and create a genai.Blob
-	// ... (implement image download and blob creation)
-	// Then call model.GenerateContent with both prompt and image blob
-	return nil, nil // Implement as needed
+	data, err := s.download(ctx, imageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	part, err := flashImagePart(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", imageURL, err)
+	}
+	return s.generateFlashContent(ctx, prompt, part)
+}
+
+// GenerateFlashWithImages edits or combines the given images according to
+// the prompt using the Gemini Flash image generation model.
+func (s *geminiService) GenerateFlashWithImages(ctx context.Context, prompt string, images [][]byte) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("at least one image is required")
+	}
+	var parts []*genai.Part
+	for _, img := range images {
+		part, err := flashImagePart(img)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return s.generateFlashContent(ctx, prompt, parts...)
+}
+
+// generateFlashContent sends the prompt and image parts to the Flash model,
+// asking for an image back, and returns the first inline image.
+func (s *geminiService) generateFlashContent(ctx context.Context, prompt string, images ...*genai.Part) ([]byte, error) {
+	parts := append([]*genai.Part{genai.NewPartFromText(prompt)}, images...)
+	cfg := &genai.GenerateContentConfig{ResponseModalities: []string{"TEXT", "IMAGE"}}
+	resp, err := s.client.Models.GenerateContent(ctx, FLASH_2_MODEL, []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return imageFromResponse(resp)
+}
+
+// imageFromResponse extracts the first inline image of resp. Blocked prompts
+// and candidates are reported as ErrSafetyBlocked, and a response with only
+// text as ErrNoImage carrying the model's text.
+func imageFromResponse(resp *genai.GenerateContentResponse) ([]byte, error) {
+	if fb := resp.PromptFeedback; fb != nil && fb.BlockReason != "" {
+		return nil, fmt.Errorf("%w: prompt blocked: %s %s", ErrSafetyBlocked, fb.BlockReason, fb.BlockReasonMessage)
+	}
+	var text []string
+	for _, c := range resp.Candidates {
+		switch c.FinishReason {
+		case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist,
+			genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+			return nil, fmt.Errorf("%w: response blocked: %s", ErrSafetyBlocked, c.FinishReason)
+		}
+		if c.Content == nil {
+			continue
+		}
+		for _, part := range c.Content.Parts {
+			if part.InlineData != nil && len(part.InlineData.Data) > 0 {
+				return part.InlineData.Data, nil
+			}
+			if part.Text != "" {
+				text = append(text, part.Text)
+			}
+		}
+	}
+	if len(text) > 0 {
+		return nil, fmt.Errorf("%w: model replied: %s", ErrNoImage, strings.Join(text, " "))
+	}
+	return nil, ErrNoImage
 }
 
 func (s *geminiService) GenerateVeo3Video(ctx context.Context, prompt string) ([]byte, error) {
@@ -120,10 +287,15 @@ func (s *geminiService) GenerateVeo3Video(ctx context.Context, prompt string) ([
 // GenerateVeo3PreviewVideo creates a video using the veo-3.0-generate-preview model
 // by providing the first and last frames along with the prompt.
 func (s *geminiService) GenerateVeo3PreviewVideo(ctx context.Context, prompt string, firstFrame, lastFrame []byte) ([]byte, error) {
-	start := &genai.Image{ImageBytes: firstFrame, MIMEType: "image/png"}
-	cfg := &genai.GenerateVideosConfig{
-		LastFrame: &genai.Image{ImageBytes: lastFrame, MIMEType: "image/png"},
+	start, err := veoFrame(firstFrame)
+	if err != nil {
+		return nil, fmt.Errorf("first frame: %w", err)
 	}
+	last, err := veoFrame(lastFrame)
+	if err != nil {
+		return nil, fmt.Errorf("last frame: %w", err)
+	}
+	cfg := &genai.GenerateVideosConfig{LastFrame: last}
 	op, err := s.client.Models.GenerateVideos(ctx, VEO_3_PREVIEW_MODEL, prompt, start, cfg)
 	if err != nil {
 		return nil, err
@@ -134,25 +306,14 @@ func (s *geminiService) GenerateVeo3PreviewVideo(ctx context.Context, prompt str
 // GenerateVeo3PreviewVideoFromURLs downloads the first and last frame images
 // from the provided URLs and invokes GenerateVeo3PreviewVideo.
 func (s *geminiService) GenerateVeo3PreviewVideoFromURLs(ctx context.Context, prompt, firstFrameURL, lastFrameURL string) ([]byte, error) {
-	firstResp, err := http.Get(firstFrameURL)
+	firstData, err := s.download(ctx, firstFrameURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download first frame: %w", err)
 	}
-	defer firstResp.Body.Close()
-	firstData, err := io.ReadAll(firstResp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read first frame: %w", err)
-	}
-
-	lastResp, err := http.Get(lastFrameURL)
+	lastData, err := s.download(ctx, lastFrameURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download last frame: %w", err)
 	}
-	defer lastResp.Body.Close()
-	lastData, err := io.ReadAll(lastResp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read last frame: %w", err)
-	}
 
 	return s.GenerateVeo3PreviewVideo(ctx, prompt, firstData, lastData)
 }
@@ -161,7 +322,10 @@ func (s *geminiService) GenerateVeo3PreviewVideoFromURLs(ctx context.Context, pr
 // veo-3.0-generate-preview model by providing only the first frame and the
 // prompt. The model will infer the rest of the clip.
 func (s *geminiService) GenerateVeo3PreviewVideoWithStartFrame(ctx context.Context, prompt string, firstFrame []byte) ([]byte, error) {
-	start := &genai.Image{ImageBytes: firstFrame, MIMEType: "image/png"}
+	start, err := veoFrame(firstFrame)
+	if err != nil {
+		return nil, fmt.Errorf("first frame: %w", err)
+	}
 	op, err := s.client.Models.GenerateVideos(ctx, VEO_3_PREVIEW_MODEL, prompt, start, nil)
 	if err != nil {
 		return nil, err
@@ -172,14 +336,46 @@ func (s *geminiService) GenerateVeo3PreviewVideoWithStartFrame(ctx context.Conte
 // GenerateVeo3PreviewVideoWithStartFrameURL downloads the first frame image from
 // the provided URL and invokes GenerateVeo3PreviewVideoWithStartFrame.
 func (s *geminiService) GenerateVeo3PreviewVideoWithStartFrameURL(ctx context.Context, prompt, firstFrameURL string) ([]byte, error) {
-	resp, err := http.Get(firstFrameURL)
+	data, err := s.download(ctx, firstFrameURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download first frame: %w", err)
 	}
-	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
+	return s.GenerateVeo3PreviewVideoWithStartFrame(ctx, prompt, data)
+}
+
+// flashImagePart returns data as a request part for the Flash image model,
+// converted to PNG when it is not PNG, JPEG or WEBP.
+func flashImagePart(data []byte) (*genai.Part, error) {
+	data, mimeType, err := mediatype.Convert(data, mediatype.PNG, mediatype.JPEG, mediatype.WEBP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read first frame: %w", err)
+		return nil, err
 	}
-	return s.GenerateVeo3PreviewVideoWithStartFrame(ctx, prompt, data)
+	return genai.NewPartFromBytes(data, mimeType), nil
+}
+
+// veoFrame returns data as a Veo start or end frame. Veo only takes PNG and
+// JPEG frames, so other images are converted to PNG.
+func veoFrame(data []byte) (*genai.Image, error) {
+	data, mimeType, err := mediatype.Convert(data, mediatype.PNG, mediatype.JPEG)
+	if err != nil {
+		return nil, err
+	}
+	return &genai.Image{ImageBytes: data, MIMEType: mimeType}, nil
+}
+
+// download fetches url with the service's HTTP client.
+func (s *geminiService) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
 }
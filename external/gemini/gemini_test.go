@@ -1,8 +1,19 @@
 package gemini
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -11,7 +22,10 @@ func TestGenerateImagen3Image(t *testing.T) {
 		t.Skip("GEMINI_API_KEY not set")
 	}
 
-	svc := NewGeminiService()
+	svc, err := NewGeminiService(context.Background(), ConfigFromEnv())
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
 	img, err := svc.GenerateImagen3Image(context.Background(), "A red apple on a table")
 	if err != nil {
 		t.Fatalf("GenerateImagen3Image returned error: %v", err)
@@ -26,7 +40,10 @@ func TestGenerateFlash2Image(t *testing.T) {
 		t.Skip("GEMINI_API_KEY not set")
 	}
 
-	svc := NewGeminiService()
+	svc, err := NewGeminiService(context.Background(), ConfigFromEnv())
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
 	img, err := svc.GenerateFlash2Image(context.Background(), "A blue cube")
 	if err != nil {
 		t.Fatalf("GenerateFlash2Image returned error: %v", err)
@@ -35,3 +52,193 @@ func TestGenerateFlash2Image(t *testing.T) {
 		t.Fatalf("GenerateFlash2Image returned empty image")
 	}
 }
+
+func TestGenerateImagen3ImageAgainstFakeServer(t *testing.T) {
+	want := []byte("fake image")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/"+IMAGEN_3_MODEL+":predict") {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"predictions": []map[string]any{{
+				"bytesBase64Encoded": base64.StdEncoding.EncodeToString(want),
+				"mimeType":           "image/png",
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	svc, err := NewGeminiService(context.Background(), Config{APIKey: "test", BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
+	got, err := svc.GenerateImagen3Image(context.Background(), "A red apple on a table")
+	if err != nil {
+		t.Fatalf("GenerateImagen3Image returned error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("unexpected image %q", got)
+	}
+}
+
+func TestGenerateImagen3ImageFiltered(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Instances []struct {
+				Prompt string `json:"prompt"`
+			} `json:"instances"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Instances) == 0 {
+			t.Errorf("decode request: %v", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		predictions := []map[string]any{}
+		if body.Instances[0].Prompt == "unsafe" {
+			predictions = append(predictions, map[string]any{"raiFilteredReason": "filtered for safety"})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"predictions": predictions})
+	}))
+	defer srv.Close()
+
+	svc, err := NewGeminiService(context.Background(), Config{APIKey: "test", BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
+	for prompt, want := range map[string]error{"unsafe": ErrSafetyBlocked, "empty": ErrNoImage} {
+		if _, err := svc.GenerateImagen3Image(context.Background(), prompt); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", prompt, want, err)
+		}
+	}
+}
+
+func TestGenerateFlash2ImageAgainstFakeServer(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nrest")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/"+FLASH_2_MODEL+":generateContent") {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Contents []struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+			GenerationConfig struct {
+				ResponseModalities []string `json:"responseModalities"`
+			} `json:"generationConfig"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(body.GenerationConfig.ResponseModalities, "IMAGE") {
+			t.Errorf("image output was not requested: %v", body.GenerationConfig.ResponseModalities)
+		}
+		parts := []map[string]any{{"text": "I can't draw that"}}
+		if body.Contents[0].Parts[0].Text == "A blue cube" {
+			parts = []map[string]any{{"inlineData": map[string]any{"mimeType": "image/png", "data": base64.StdEncoding.EncodeToString(png)}}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"candidates": []map[string]any{{"content": map[string]any{"parts": parts}}}})
+	}))
+	defer srv.Close()
+
+	svc, err := NewGeminiService(context.Background(), Config{APIKey: "test", BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
+	got, err := svc.GenerateFlash2Image(context.Background(), "A blue cube")
+	if err != nil {
+		t.Fatalf("GenerateFlash2Image returned error: %v", err)
+	}
+	if !bytes.Equal(got, png) {
+		t.Fatalf("unexpected image %q", got)
+	}
+	if _, err := svc.GenerateFlash2Image(context.Background(), "Something else"); !errors.Is(err, ErrNoImage) {
+		t.Fatalf("expected ErrNoImage, got %v", err)
+	}
+}
+
+func TestGenerateFlashWithImageAgainstFakeServer(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nrest")
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(png)
+	}))
+	defer images.Close()
+
+	responses := map[string]any{
+		"edit": map[string]any{"candidates": []map[string]any{{"content": map[string]any{"parts": []map[string]any{
+			{"text": "Here you go"},
+			{"inlineData": map[string]any{"mimeType": "image/png", "data": base64.StdEncoding.EncodeToString(png)}},
+		}}}}},
+		"blocked": map[string]any{"promptFeedback": map[string]any{"blockReason": "SAFETY"}},
+		"unsafe":  map[string]any{"candidates": []map[string]any{{"finishReason": "IMAGE_SAFETY"}}},
+		"chat":    map[string]any{"candidates": []map[string]any{{"content": map[string]any{"parts": []map[string]any{{"text": "I can't draw that"}}}}}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/"+FLASH_2_MODEL+":generateContent") {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Contents []struct {
+				Parts []struct {
+					Text       string `json:"text"`
+					InlineData *struct {
+						MIMEType string `json:"mimeType"`
+					} `json:"inlineData"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := body.Contents[0].Parts
+		if len(parts) != 2 || parts[1].InlineData == nil || parts[1].InlineData.MIMEType != "image/png" {
+			t.Errorf("unexpected parts %+v", parts)
+		}
+		json.NewEncoder(w).Encode(responses[parts[0].Text])
+	}))
+	defer srv.Close()
+
+	svc, err := NewGeminiService(context.Background(), Config{APIKey: "test", BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewGeminiService returned error: %v", err)
+	}
+	ctx := context.Background()
+	got, err := svc.GenerateFlashWithImage(ctx, "edit", images.URL)
+	if err != nil {
+		t.Fatalf("GenerateFlashWithImage returned error: %v", err)
+	}
+	if !bytes.Equal(got, png) {
+		t.Fatalf("unexpected image %q", got)
+	}
+	for prompt, want := range map[string]error{"blocked": ErrSafetyBlocked, "unsafe": ErrSafetyBlocked, "chat": ErrNoImage} {
+		if _, err := svc.GenerateFlashWithImage(ctx, prompt, images.URL); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", prompt, want, err)
+		}
+	}
+}
+
+func TestVeoFrame(t *testing.T) {
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0}
+	frame, err := veoFrame(jpeg)
+	if err != nil || frame.MIMEType != "image/jpeg" || !bytes.Equal(frame.ImageBytes, jpeg) {
+		t.Fatalf("expected the JPEG unchanged, got %+v: %v", frame, err)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	frame, err = veoFrame(buf.Bytes())
+	if err != nil || frame.MIMEType != "image/png" || !bytes.HasPrefix(frame.ImageBytes, []byte("\x89PNG")) {
+		t.Fatalf("expected the GIF converted to PNG, got %+v: %v", frame, err)
+	}
+
+	if _, err := veoFrame([]byte("not an image")); err == nil {
+		t.Fatal("expected error for text")
+	}
+}
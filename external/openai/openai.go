@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	goopenai "github.com/sashabaranov/go-openai"
 )
 
@@ -20,6 +27,25 @@ const (
 	GPT41Mini = goopenai.GPT4Dot1Mini
 )
 
+// ImageGenerator is implemented by services that can generate images with
+// any options, as the one returned by NewOpenAIService does. It is kept out
+// of OpenAIService so that existing implementations of that interface stay
+// valid.
+type ImageGenerator interface {
+	// GenerateImage generates images with model, checking opts against
+	// what the model accepts.
+	GenerateImage(ctx context.Context, model, prompt string, opts ImageOptions) ([][]byte, error)
+}
+
+// ImageEditor is implemented by services that can edit several images with a
+// mask, as the one returned by NewOpenAIService does. It is kept out of
+// OpenAIService so that existing implementations of that interface stay
+// valid.
+type ImageEditor interface {
+	// EditImage calls the image edit endpoint.
+	EditImage(ctx context.Context, req EditImageRequest) ([][]byte, error)
+}
+
 // OpenAIService provides helpers around the go-openai client.
 type OpenAIService interface {
 	GenerateGPTImage1(ctx context.Context, prompt string) ([]byte, error)
@@ -31,110 +57,277 @@ type OpenAIService interface {
 }
 
 type service struct {
-	client *goopenai.Client
+	client     *goopenai.Client
+	httpClient *http.Client
+	cfg        Config
 }
 
-// NewService returns an OpenAIService using the OPENAI_API_KEY environment variable.
-func NewService() OpenAIService {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	cli := goopenai.NewClient(apiKey)
-	return &service{client: cli}
+// ImageFile is an image uploaded to the edit endpoint. Its type is detected
+// from Data, and formats the model does not accept are converted to PNG.
+type ImageFile struct {
+	Data []byte
+	// Deprecated: MIMEType is ignored, the type is detected from Data.
+	MIMEType string
 }
 
-func (s *service) GenerateGPTImage1(ctx context.Context, prompt string) ([]byte, error) {
-	req := goopenai.ImageRequest{
-		Model:          GPTImage1,
-		Prompt:         prompt,
-		Moderation:     goopenai.CreateImageModerationLow,
-		OutputFormat:   goopenai.CreateImageOutputFormatWEBP,
-		Quality:        goopenai.CreateImageQualityHigh,
-		Size:           goopenai.CreateImageSize1024x1024,
-		ResponseFormat: goopenai.CreateImageResponseFormatB64JSON,
-		N:              1,
-	}
-	resp, err := s.client.CreateImage(ctx, req)
-	if err != nil {
+// EditImageRequest describes an image edit. gpt-image-1 accepts up to 16
+// input images that are combined according to the prompt; the optional
+// mask applies to the first image, whose transparent pixels mark the area to
+// repaint.
+type EditImageRequest struct {
+	Model  string
+	Prompt string
+	Images []ImageFile
+	Mask   *ImageFile
+	ImageOptions
+}
+
+// Config holds the settings used to build an OpenAIService.
+type Config struct {
+	APIKey string
+	// BaseURL overrides the default https://api.openai.com/v1 endpoint. It
+	// can point at any OpenAI-compatible server, in which case APIKey may be
+	// left empty.
+	BaseURL string
+	// OrgID is sent as the OpenAI-Organization header when set.
+	OrgID string
+	// HTTPClient is used for all API requests and image downloads.
+	// http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// ConfigFromEnv returns a Config populated from the OPENAI_API_KEY,
+// OPENAI_BASE_URL and OPENAI_ORG_ID environment variables.
+func ConfigFromEnv() Config {
+	return Config{
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}
+}
+
+// NewService returns an OpenAIService configured by cfg.
+func NewService(cfg Config) (OpenAIService, error) {
+	if cfg.APIKey == "" && cfg.BaseURL == "" {
+		return nil, errors.New("openai api key is required")
+	}
+	clientCfg := goopenai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientCfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	clientCfg.OrgID = cfg.OrgID
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	clientCfg.HTTPClient = httpClient
+	cfg.BaseURL = clientCfg.BaseURL
+	return &service{client: goopenai.NewClientWithConfig(clientCfg), httpClient: httpClient, cfg: cfg}, nil
+}
+
+// GenerateImage creates images with model after validating opts against
+// what the model allows. All generated images are returned.
+func (s *service) GenerateImage(ctx context.Context, model, prompt string, opts ImageOptions) ([][]byte, error) {
+	if err := opts.Validate(model); err != nil {
 		return nil, err
 	}
-	if len(resp.Data) == 0 {
-		return nil, fmt.Errorf("empty response")
+	resp, err := s.client.CreateImage(ctx, opts.imageRequest(model, prompt))
+	if err != nil {
+		return nil, err
 	}
-	buf, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	return decodeImages(resp)
+}
+
+// GenerateGPTImage1 creates a high quality 1024x1024 WEBP image with low
+// moderation. Use GenerateImage for other settings.
+func (s *service) GenerateGPTImage1(ctx context.Context, prompt string) ([]byte, error) {
+	images, err := s.GenerateImage(ctx, GPTImage1, prompt, ImageOptions{
+		Size:         goopenai.CreateImageSize1024x1024,
+		Quality:      goopenai.CreateImageQualityHigh,
+		OutputFormat: goopenai.CreateImageOutputFormatWEBP,
+		Moderation:   goopenai.CreateImageModerationLow,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	return images[0], nil
 }
 
 func (s *service) GenerateGPTImage1WithImage(ctx context.Context, prompt, imageURL string) ([]byte, error) {
-	resp, err := http.Get(imageURL)
+	dlReq, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(dlReq)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("download image: %s", resp.Status)
+	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" || contentType == "application/octet-stream" {
-		switch {
-		case hasSuffix(imageURL, ".jpeg"), hasSuffix(imageURL, ".jpg"):
-			contentType = "image/jpeg"
-		case hasSuffix(imageURL, ".png"):
-			contentType = "image/png"
-		case hasSuffix(imageURL, ".webp"):
-			contentType = "image/webp"
-		default:
-			contentType = "image/png"
-		}
+	images, err := s.EditImage(ctx, EditImageRequest{
+		Model:  GPTImage1,
+		Prompt: prompt,
+		Images: []ImageFile{{Data: data}},
+		ImageOptions: ImageOptions{
+			Quality: goopenai.CreateImageQualityHigh,
+			Size:    goopenai.CreateImageSize1024x1024,
+			N:       1,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+// EditImage calls the image edit endpoint. The request is built by hand
+// because the go-openai client neither sends the model nor supports several
+// input images.
+func (s *service) EditImage(ctx context.Context, req EditImageRequest) ([][]byte, error) {
+	if len(req.Images) == 0 {
+		return nil, errors.New("at least one image is required")
+	}
+	model := req.Model
+	if model == "" {
+		model = GPTImage1
+	}
+	if err := req.ImageOptions.Validate(model); err != nil {
+		return nil, err
 	}
 
-	reader := goopenai.WrapReader(bytes.NewReader(data), "image"+extFromContentType(contentType), contentType)
-	req := goopenai.ImageEditRequest{
-		Model:          GPTImage1,
-		Prompt:         prompt,
-		Image:          reader,
-		Quality:        goopenai.CreateImageQualityHigh,
-		Size:           goopenai.CreateImageSize1024x1024,
-		ResponseFormat: goopenai.CreateImageResponseFormatB64JSON,
-		N:              1,
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	field := "image"
+	if len(req.Images) > 1 {
+		field = "image[]"
 	}
-	editResp, err := s.client.CreateEditImage(ctx, req)
+	accepted := []string{mediatype.PNG}
+	if model == GPTImage1 {
+		accepted = append(accepted, mediatype.JPEG, mediatype.WEBP)
+	}
+	for i, img := range req.Images {
+		if err := writeFormFile(form, field, fmt.Sprintf("image%d", i), img, accepted); err != nil {
+			return nil, err
+		}
+	}
+	if req.Mask != nil {
+		// The mask needs an alpha channel, so it is always sent as PNG.
+		if err := writeFormFile(form, "mask", "mask", *req.Mask, []string{mediatype.PNG}); err != nil {
+			return nil, err
+		}
+	}
+	fields := map[string]string{
+		"model":         model,
+		"prompt":        req.Prompt,
+		"size":          req.Size,
+		"quality":       req.Quality,
+		"background":    req.Background,
+		"output_format": req.OutputFormat,
+	}
+	if req.N > 0 {
+		fields["n"] = strconv.Itoa(req.N)
+	}
+	if req.OutputCompression != nil {
+		fields["output_compression"] = strconv.Itoa(*req.OutputCompression)
+	}
+	if model != GPTImage1 {
+		fields["response_format"] = goopenai.CreateImageResponseFormatB64JSON
+	}
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		if err := form.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.BaseURL+"/images/edits", body)
 	if err != nil {
 		return nil, err
 	}
-	if len(editResp.Data) == 0 {
-		return nil, fmt.Errorf("empty response")
+	httpReq.Header.Set("Content-Type", form.FormDataContentType())
+	if s.cfg.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+	}
+	if s.cfg.OrgID != "" {
+		httpReq.Header.Set("OpenAI-Organization", s.cfg.OrgID)
 	}
-	buf, err := base64.StdEncoding.DecodeString(editResp.Data[0].B64JSON)
+	resp, err := s.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errRes goopenai.ErrorResponse
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, &errRes) == nil && errRes.Error != nil {
+			errRes.Error.HTTPStatus = resp.Status
+			errRes.Error.HTTPStatusCode = resp.StatusCode
+			return nil, errRes.Error
+		}
+		return nil, &goopenai.RequestError{HTTPStatus: resp.Status, HTTPStatusCode: resp.StatusCode, Body: b}
+	}
+
+	var editResp goopenai.ImageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&editResp); err != nil {
+		return nil, err
+	}
+	return decodeImages(editResp)
 }
 
-func (s *service) GenerateDallEImage(ctx context.Context, prompt, size string) ([]byte, error) {
-	req := goopenai.ImageRequest{
-		Model:          DallE3,
-		Prompt:         prompt,
-		Quality:        goopenai.CreateImageQualityHD,
-		Size:           size,
-		ResponseFormat: goopenai.CreateImageResponseFormatB64JSON,
-		N:              1,
-	}
-	resp, err := s.client.CreateImage(ctx, req)
+// writeFormFile adds img to form, converting it to the first accepted type
+// when the endpoint does not take its format.
+func writeFormFile(form *multipart.Writer, field, name string, img ImageFile, accepted []string) error {
+	data, contentType, err := mediatype.Convert(img.Data, accepted...)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s%s"`, field, name, mediatype.Extension(contentType)))
+	h.Set("Content-Type", contentType)
+	w, err := form.CreatePart(h)
+	if err != nil {
+		return err
 	}
+	_, err = w.Write(data)
+	return err
+}
+
+// decodeImages returns every base64 image of resp.
+func decodeImages(resp goopenai.ImageResponse) ([][]byte, error) {
 	if len(resp.Data) == 0 {
 		return nil, fmt.Errorf("empty response")
 	}
-	buf, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	images := make([][]byte, 0, len(resp.Data))
+	for _, d := range resp.Data {
+		buf, err := base64.StdEncoding.DecodeString(d.B64JSON)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, buf)
+	}
+	return images, nil
+}
+
+// GenerateDallEImage creates an HD DALL·E 3 image of the given size. Use
+// GenerateImage for other settings.
+func (s *service) GenerateDallEImage(ctx context.Context, prompt, size string) ([]byte, error) {
+	images, err := s.GenerateImage(ctx, DallE3, prompt, ImageOptions{Size: size, Quality: goopenai.CreateImageQualityHD})
 	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	return images[0], nil
 }
 
 func (s *service) GenerateResponseFromContent(ctx context.Context, content string) (string, error) {
@@ -193,19 +386,3 @@ func (s *service) Moderation(ctx context.Context, text string) (bool, error) {
 	}
 	return resp.Results[0].Flagged, nil
 }
-
-func hasSuffix(s, suffix string) bool {
-	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
-}
-
-func extFromContentType(ct string) string {
-	switch ct {
-	case "image/jpeg":
-		return ".jpg"
-	case "image/png":
-		return ".png"
-	case "image/webp":
-		return ".webp"
-	}
-	return ""
-}
@@ -1,38 +1,177 @@
 package openai
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
 
-func TestHasSuffix(t *testing.T) {
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestGenerateGPTImage1AgainstCompatibleServer(t *testing.T) {
+	want := []byte("fake image")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/images/generations" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var req goopenai.ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if req.Model != GPTImage1 || req.Prompt != "a cat" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		json.NewEncoder(w).Encode(goopenai.ImageResponse{
+			Data: []goopenai.ImageResponseDataInner{{B64JSON: base64.StdEncoding.EncodeToString(want)}},
+		})
+	}))
+	defer srv.Close()
+
+	svc, err := NewService(Config{BaseURL: srv.URL + "/v1", HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewService returned error: %v", err)
+	}
+	got, err := svc.GenerateGPTImage1(context.Background(), "a cat")
+	if err != nil {
+		t.Fatalf("GenerateGPTImage1 returned error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("unexpected image %q", got)
+	}
+}
+
+func TestNewServiceRequiresKeyOrBaseURL(t *testing.T) {
+	if _, err := NewService(Config{}); err == nil {
+		t.Fatal("expected error without api key or base url")
+	}
+}
+
+func TestImageOptionsValidate(t *testing.T) {
+	compression := 80
 	cases := []struct {
-		s      string
-		suffix string
-		want   bool
+		model string
+		opts  ImageOptions
+		ok    bool
 	}{
-		{"hello.jpg", ".jpg", true},
-		{"hello.jpeg", ".jpg", false},
-		{"image.png", ".png", true},
-		{"image.png?query=1", ".png", false},
+		{GPTImage1, ImageOptions{}, true},
+		{GPTImage1, ImageOptions{Size: "1536x1024", Quality: "medium", Background: "transparent", OutputFormat: "png", N: 4}, true},
+		{GPTImage1, ImageOptions{OutputFormat: "webp", OutputCompression: &compression}, true},
+		{GPTImage1, ImageOptions{OutputCompression: &compression}, false},
+		{GPTImage1, ImageOptions{Background: "transparent", OutputFormat: "jpeg"}, false},
+		{GPTImage1, ImageOptions{Quality: "hd"}, false},
+		{GPTImage1, ImageOptions{Style: "vivid"}, false},
+		{GPTImage1, ImageOptions{N: 11}, false},
+		{DallE3, ImageOptions{Size: "1792x1024", Quality: "hd", Style: "natural"}, true},
+		{DallE3, ImageOptions{Size: "1536x1024"}, false},
+		{DallE3, ImageOptions{Background: "transparent"}, false},
+		{DallE3, ImageOptions{N: 2}, false},
+		{"dall-e-1", ImageOptions{}, false},
 	}
 	for _, c := range cases {
-		if got := hasSuffix(c.s, c.suffix); got != c.want {
-			t.Errorf("hasSuffix(%q, %q)=%v want %v", c.s, c.suffix, got, c.want)
+		err := c.opts.Validate(c.model)
+		if (err == nil) != c.ok {
+			t.Errorf("Validate(%s, %+v) = %v, want ok=%v", c.model, c.opts, err, c.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidImageOptions) {
+			t.Errorf("Validate returned %v, want ErrInvalidImageOptions", err)
 		}
 	}
 }
 
-func TestExtFromContentType(t *testing.T) {
-	cases := []struct {
-		ct   string
-		want string
-	}{
-		{"image/jpeg", ".jpg"},
-		{"image/png", ".png"},
-		{"image/webp", ".webp"},
-		{"application/json", ""},
+func TestGenerateImageOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req goopenai.ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if req.N != 2 || req.Background != "transparent" || req.Quality != "low" || req.ResponseFormat != "" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		json.NewEncoder(w).Encode(goopenai.ImageResponse{
+			Data: []goopenai.ImageResponseDataInner{
+				{B64JSON: base64.StdEncoding.EncodeToString([]byte("one"))},
+				{B64JSON: base64.StdEncoding.EncodeToString([]byte("two"))},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	svc, err := NewService(Config{BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewService returned error: %v", err)
 	}
-	for _, c := range cases {
-		if got := extFromContentType(c.ct); got != c.want {
-			t.Errorf("extFromContentType(%q)=%q want %q", c.ct, got, c.want)
+	ig, ok := svc.(ImageGenerator)
+	if !ok {
+		t.Fatal("service does not implement ImageGenerator")
+	}
+	images, err := ig.GenerateImage(context.Background(), GPTImage1, "a logo", ImageOptions{N: 2, Background: "transparent", Quality: "low"})
+	if err != nil {
+		t.Fatalf("GenerateImage returned error: %v", err)
+	}
+	if len(images) != 2 || string(images[1]) != "two" {
+		t.Fatalf("unexpected images %q", images)
+	}
+}
+
+func TestEditImageConvertsFormats(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	webp := []byte("RIFF\x10\x00\x00\x00WEBPVP8L")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files := r.MultipartForm.File["image[]"]
+		if len(files) != 2 {
+			t.Errorf("expected two images, got %d", len(files))
+			http.Error(w, "wrong image count", http.StatusBadRequest)
+			return
+		}
+		if ct := files[0].Header.Get("Content-Type"); ct != "image/png" || files[0].Filename != "image0.png" {
+			t.Errorf("expected the GIF converted to PNG, got %s %s", ct, files[0].Filename)
+		}
+		if ct := files[1].Header.Get("Content-Type"); ct != "image/webp" || files[1].Filename != "image1.webp" {
+			t.Errorf("expected the WEBP sent unchanged, got %s %s", ct, files[1].Filename)
 		}
+		json.NewEncoder(w).Encode(goopenai.ImageResponse{
+			Data: []goopenai.ImageResponseDataInner{{B64JSON: base64.StdEncoding.EncodeToString([]byte("out"))}},
+		})
+	}))
+	defer srv.Close()
+
+	svc, err := NewService(Config{BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewService returned error: %v", err)
+	}
+	ed, ok := svc.(ImageEditor)
+	if !ok {
+		t.Fatal("service does not implement ImageEditor")
+	}
+	_, err = ed.EditImage(context.Background(), EditImageRequest{
+		Prompt: "a hat",
+		Images: []ImageFile{{Data: gifData.Bytes()}, {Data: webp}},
+	})
+	if err != nil {
+		t.Fatalf("EditImage returned error: %v", err)
+	}
+	_, err = ed.EditImage(context.Background(), EditImageRequest{
+		Prompt: "a hat",
+		Images: []ImageFile{{Data: []byte("\x00\x00\x00\x20ftypisom")}},
+	})
+	if err == nil {
+		t.Fatal("expected error for a video input")
 	}
 }
@@ -0,0 +1,126 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// ErrInvalidImageOptions is returned when ImageOptions are not allowed for
+// the requested model.
+var ErrInvalidImageOptions = errors.New("invalid image options")
+
+// ImageOptions configures image generation and edits. Zero values leave the
+// choice to the API.
+type ImageOptions struct {
+	// Size is a WIDTHxHEIGHT string such as "1024x1024", or "auto".
+	Size    string
+	Quality string
+	// OutputFormat is "png", "jpeg" or "webp".
+	OutputFormat string
+	// OutputCompression is the 0-100 compression level for jpeg and webp.
+	OutputCompression *int
+	// Background is "transparent", "opaque" or "auto".
+	Background string
+	// Moderation is "low" or "auto".
+	Moderation string
+	// Style is "vivid" or "natural".
+	Style string
+	// N is the number of images to generate. Zero means one.
+	N int
+}
+
+// imageModelLimits lists the option values each model accepts. A nil list
+// means the option is not supported.
+type imageModelLimits struct {
+	sizes, qualities, formats, backgrounds, moderations, styles []string
+	compression                                                 bool
+	maxN                                                        int
+}
+
+var imageLimits = map[string]imageModelLimits{
+	GPTImage1: {
+		sizes:       []string{goopenai.CreateImageSize1024x1024, goopenai.CreateImageSize1536x1024, goopenai.CreateImageSize1024x1536, "auto"},
+		qualities:   []string{goopenai.CreateImageQualityLow, goopenai.CreateImageQualityMedium, goopenai.CreateImageQualityHigh, "auto"},
+		formats:     []string{goopenai.CreateImageOutputFormatPNG, goopenai.CreateImageOutputFormatJPEG, goopenai.CreateImageOutputFormatWEBP},
+		backgrounds: []string{goopenai.CreateImageBackgroundTransparent, goopenai.CreateImageBackgroundOpaque, "auto"},
+		moderations: []string{goopenai.CreateImageModerationLow, "auto"},
+		compression: true,
+		maxN:        10,
+	},
+	DallE3: {
+		sizes:     []string{goopenai.CreateImageSize1024x1024, goopenai.CreateImageSize1792x1024, goopenai.CreateImageSize1024x1792},
+		qualities: []string{goopenai.CreateImageQualityStandard, goopenai.CreateImageQualityHD},
+		styles:    []string{goopenai.CreateImageStyleVivid, goopenai.CreateImageStyleNatural},
+		maxN:      1,
+	},
+}
+
+// Validate reports whether o is allowed for model.
+func (o ImageOptions) Validate(model string) error {
+	limits, ok := imageLimits[model]
+	if !ok {
+		return fmt.Errorf("%w: unknown image model %s", ErrInvalidImageOptions, model)
+	}
+	for _, opt := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"size", o.Size, limits.sizes},
+		{"quality", o.Quality, limits.qualities},
+		{"output format", o.OutputFormat, limits.formats},
+		{"background", o.Background, limits.backgrounds},
+		{"moderation", o.Moderation, limits.moderations},
+		{"style", o.Style, limits.styles},
+	} {
+		if opt.value == "" || slices.Contains(opt.allowed, opt.value) {
+			continue
+		}
+		if opt.allowed == nil {
+			return fmt.Errorf("%w: %s does not support %s", ErrInvalidImageOptions, model, opt.name)
+		}
+		return fmt.Errorf("%w: %s %q is not one of %v for %s", ErrInvalidImageOptions, opt.name, opt.value, opt.allowed, model)
+	}
+	if c := o.OutputCompression; c != nil {
+		switch {
+		case !limits.compression:
+			return fmt.Errorf("%w: %s does not support output compression", ErrInvalidImageOptions, model)
+		case *c < 0 || *c > 100:
+			return fmt.Errorf("%w: output compression must be between 0 and 100", ErrInvalidImageOptions)
+		case o.OutputFormat == "" || o.OutputFormat == goopenai.CreateImageOutputFormatPNG:
+			return fmt.Errorf("%w: output compression requires jpeg or webp output", ErrInvalidImageOptions)
+		}
+	}
+	if o.Background == goopenai.CreateImageBackgroundTransparent && o.OutputFormat == goopenai.CreateImageOutputFormatJPEG {
+		return fmt.Errorf("%w: transparent backgrounds require png or webp output", ErrInvalidImageOptions)
+	}
+	if o.N < 0 || o.N > limits.maxN {
+		return fmt.Errorf("%w: n must be between 1 and %d for %s", ErrInvalidImageOptions, limits.maxN, model)
+	}
+	return nil
+}
+
+// imageRequest builds the generation request for model.
+func (o ImageOptions) imageRequest(model, prompt string) goopenai.ImageRequest {
+	req := goopenai.ImageRequest{
+		Model:        model,
+		Prompt:       prompt,
+		Size:         o.Size,
+		Quality:      o.Quality,
+		Style:        o.Style,
+		Background:   o.Background,
+		Moderation:   o.Moderation,
+		OutputFormat: o.OutputFormat,
+		N:            max(o.N, 1),
+	}
+	if o.OutputCompression != nil {
+		req.OutputCompression = *o.OutputCompression
+	}
+	// gpt-image-1 always returns base64 and rejects response_format.
+	if model != GPTImage1 {
+		req.ResponseFormat = goopenai.CreateImageResponseFormatB64JSON
+	}
+	return req
+}
@@ -7,10 +7,15 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	"github.com/pkg/errors"
 )
 
@@ -21,6 +26,9 @@ const Seedance1Model = "bytedance/seedance-1-pro"
 // Seedance1LiteModel identifies the bytedance/seedance-1-lite model on Replicate.
 const Seedance1LiteModel = "bytedance/seedance-1-lite"
 
+// ErrRateLimited is returned when Replicate rejects a request with HTTP 429.
+var ErrRateLimited = errors.New("replicate rate limit exceeded")
+
 // ReplicateServiceAPI defines the interface for Replicate service operations.
 
 type ReplicateService interface {
@@ -50,35 +58,130 @@ type ReplicateService interface {
 	IsInitialized() bool
 }
 
+// MultiRunner is implemented by services that can return every output of a
+// model, as the one returned by NewReplicateService does. It is kept out of
+// ReplicateService so that existing implementations of that interface stay
+// valid.
+type MultiRunner interface {
+	// RunAll is Run for models producing several files, such as image
+	// models asked for more than one output. It returns every output URL.
+	RunAll(ctx context.Context, model string, prompt string, options map[string]any) ([]string, error)
+}
+
+// FileUploader is implemented by services that can store files with
+// Replicate's files API, as the one returned by NewReplicateService does.
+// It is kept out of ReplicateService so that existing implementations of
+// that interface stay valid.
+type FileUploader interface {
+	// UploadFile stores data and returns a URL that can be passed as a
+	// model input.
+	UploadFile(ctx context.Context, data []byte, contentType string) (string, error)
+}
+
 // ReplicateService provides methods to interact with the Replicate API.
 type replicateService struct {
 	token    string
 	client   *http.Client
 	baseURL  string
+	mu       sync.Mutex
 	versions map[string]string
 }
 
-// NewReplicateService returns a ReplicateServiceAPI interface using the HTTP API.
-func NewReplicateService(token string) (ReplicateService, error) {
-	if token == "" {
+// DefaultBaseURL is the Replicate HTTP API endpoint.
+const DefaultBaseURL = "https://api.replicate.com/v1"
+
+// Config holds the settings used to build a ReplicateService.
+type Config struct {
+	APIToken string
+	// BaseURL overrides DefaultBaseURL.
+	BaseURL string
+	// HTTPClient is used for all API requests. A client with a 60 second
+	// timeout is used when nil.
+	HTTPClient *http.Client
+}
+
+// ConfigFromEnv returns a Config populated from the REPLICATE_API_TOKEN and
+// REPLICATE_BASE_URL environment variables.
+func ConfigFromEnv() Config {
+	return Config{
+		APIToken: os.Getenv("REPLICATE_API_TOKEN"),
+		BaseURL:  os.Getenv("REPLICATE_BASE_URL"),
+	}
+}
+
+// NewReplicateService returns a ReplicateService interface using the HTTP API.
+func NewReplicateService(cfg Config) (ReplicateService, error) {
+	if cfg.APIToken == "" {
 		return nil, errors.New("replicate token is required")
 	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
 	return &replicateService{
-		token:    token,
-		client:   &http.Client{Timeout: 60 * time.Second},
-		baseURL:  "https://api.replicate.com/v1",
+		token:    cfg.APIToken,
+		client:   client,
+		baseURL:  baseURL,
 		versions: map[string]string{},
 	}, nil
 }
 
 // Run executes a model prediction using Replicate's HTTP API and waits for completion.
+// When the model returns a list of URLs only the first one is returned.
 func (r *replicateService) Run(ctx context.Context, model string, prompt string, options map[string]any) (any, error) {
+	output, err := r.predict(ctx, model, prompt, options)
+	if err != nil {
+		return nil, err
+	}
+	return extractOutputURL(output), nil
+}
+
+// RunAll is Run for models producing several files, such as image models
+// asked for more than one output. It returns every output URL.
+func (r *replicateService) RunAll(ctx context.Context, model string, prompt string, options map[string]any) ([]string, error) {
+	output, err := r.predict(ctx, model, prompt, options)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	switch v := output.(type) {
+	case string:
+		urls = append(urls, v)
+	case []any:
+		for _, item := range v {
+			if url, ok := item.(string); ok {
+				urls = append(urls, url)
+			}
+		}
+	case map[string]any:
+		if url, ok := v["url"].(string); ok {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("unexpected %s output %T", model, output)
+	}
+	return urls, nil
+}
+
+// predict creates a prediction and polls it until it finishes, returning
+// the raw output.
+func (r *replicateService) predict(ctx context.Context, model string, prompt string, options map[string]any) (any, error) {
 	version, err := r.getLatestVersion(ctx, model)
 	if err != nil {
 		return nil, err
 	}
 
-	input := map[string]any{"prompt": prompt}
+	// Models without a prompt input, such as upscalers, are run with an
+	// empty prompt.
+	input := map[string]any{}
+	if prompt != "" {
+		input["prompt"] = prompt
+	}
 	maps.Copy(input, options)
 
 	body, err := json.Marshal(map[string]any{
@@ -101,6 +204,10 @@ func (r *replicateService) Run(ctx context.Context, model string, prompt string,
 		return nil, errors.Wrap(err, "failed to create prediction")
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, errors.Wrap(ErrRateLimited, string(b))
+	}
 	if resp.StatusCode >= http.StatusBadRequest {
 		b, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("prediction create failed: %s", string(b))
@@ -114,7 +221,7 @@ func (r *replicateService) Run(ctx context.Context, model string, prompt string,
 	// Poll until prediction finished
 	for {
 		if pred.Status == "succeeded" {
-			return extractOutputURL(pred.Output), nil
+			return pred.Output, nil
 		}
 		if pred.Status == "failed" || pred.Status == "canceled" {
 			return nil, fmt.Errorf("prediction %s", pred.Status)
@@ -144,6 +251,63 @@ func (r *replicateService) RunSeedance1Lite(ctx context.Context, prompt string,
 	return r.Run(ctx, Seedance1LiteModel, prompt, options)
 }
 
+// UploadFile uploads data to the files API. Files are private to the account
+// and can be used as inputs of its predictions. An empty contentType is
+// detected from data.
+func (r *replicateService) UploadFile(ctx context.Context, data []byte, contentType string) (string, error) {
+	if contentType == "" {
+		contentType = mediatype.Detect(data)
+	}
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="content"; filename="input%s"`, mediatype.Extension(contentType)))
+	h.Set("Content-Type", contentType)
+	part, err := form.CreatePart(h)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/files", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Token "+r.token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to upload file")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		b, _ := io.ReadAll(resp.Body)
+		return "", errors.Wrap(ErrRateLimited, string(b))
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("file upload failed: %s", string(b))
+	}
+
+	var file struct {
+		URLs struct {
+			Get string `json:"get"`
+		} `json:"urls"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return "", err
+	}
+	if file.URLs.Get == "" {
+		return "", errors.New("file upload returned no url")
+	}
+	return file.URLs.Get, nil
+}
+
 func (r *replicateService) IsInitialized() bool {
 	return r.client != nil
 }
@@ -173,7 +337,10 @@ func (r *replicateService) getPrediction(ctx context.Context, id string, pred *p
 }
 
 func (r *replicateService) getLatestVersion(ctx context.Context, model string) (string, error) {
-	if v, ok := r.versions[model]; ok {
+	r.mu.Lock()
+	v, ok := r.versions[model]
+	r.mu.Unlock()
+	if ok {
 		return v, nil
 	}
 
@@ -187,6 +354,10 @@ func (r *replicateService) getLatestVersion(ctx context.Context, model string) (
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		b, _ := io.ReadAll(resp.Body)
+		return "", errors.Wrap(ErrRateLimited, string(b))
+	}
 	if resp.StatusCode >= http.StatusBadRequest {
 		b, _ := io.ReadAll(resp.Body)
 		return "", fmt.Errorf("fetch model failed: %s", string(b))
@@ -210,7 +381,9 @@ func (r *replicateService) getLatestVersion(ctx context.Context, model string) (
 	if version == "" {
 		return "", errors.New("model version not found")
 	}
+	r.mu.Lock()
 	r.versions[model] = version
+	r.mu.Unlock()
 	return version, nil
 }
 
@@ -0,0 +1,111 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunAgainstFakeServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/models/owner/model":
+			json.NewEncoder(w).Encode(map[string]any{"default_version": map[string]any{"id": "v1"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/predictions":
+			var body struct {
+				Version string         `json:"version"`
+				Input   map[string]any `json:"input"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+			if body.Version != "v1" || body.Input["prompt"] != "a cat" || body.Input["seed"] != float64(7) {
+				t.Errorf("unexpected request body: %+v", body)
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"id":     "p1",
+				"status": "succeeded",
+				"output": []string{"https://example.com/out.png"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	svc, err := NewReplicateService(Config{APIToken: "test-token", BaseURL: srv.URL + "/v1/", HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewReplicateService returned error: %v", err)
+	}
+	out, err := svc.Run(context.Background(), "owner/model", "a cat", map[string]any{"seed": 7})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if out != "https://example.com/out.png" {
+		t.Fatalf("unexpected output: %v", out)
+	}
+}
+
+func TestRunRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	svc, err := NewReplicateService(Config{APIToken: "test-token", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewReplicateService returned error: %v", err)
+	}
+	if _, err := svc.Run(context.Background(), "owner/model", "a cat", nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestNewReplicateServiceRequiresToken(t *testing.T) {
+	if _, err := NewReplicateService(Config{}); err == nil {
+		t.Fatal("expected error for missing token")
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/files" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		file, header, err := r.FormFile("content")
+		if err != nil {
+			t.Errorf("read form file: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Header.Get("Content-Type") != "image/png" || header.Filename != "input.png" {
+			t.Errorf("unexpected file %s %s", header.Filename, header.Header.Get("Content-Type"))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"urls": map[string]any{"get": "https://api.replicate.com/v1/files/f1"}})
+	}))
+	defer srv.Close()
+
+	svc, err := NewReplicateService(Config{APIToken: "test-token", BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewReplicateService returned error: %v", err)
+	}
+	up, ok := svc.(FileUploader)
+	if !ok {
+		t.Fatal("service does not implement FileUploader")
+	}
+	url, err := up.UploadFile(context.Background(), []byte("\x89PNG\r\n\x1a\n"), "")
+	if err != nil {
+		t.Fatalf("UploadFile returned error: %v", err)
+	}
+	if url != "https://api.replicate.com/v1/files/f1" {
+		t.Fatalf("unexpected url %s", url)
+	}
+}
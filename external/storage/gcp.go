@@ -5,24 +5,61 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 
 	"cloud.google.com/go/storage"
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	"github.com/pkg/errors"
+	"google.golang.org/api/option"
 )
 
+// DefaultGCPPublicURL is the base of public Cloud Storage object URLs.
+const DefaultGCPPublicURL = "https://storage.googleapis.com"
+
 type gcpService struct {
 	client     *storage.Client
 	bucketName string
+	publicURL  string
+}
+
+// GCPConfig holds the settings used to build a Cloud Storage backend.
+type GCPConfig struct {
+	Bucket string
+	// Endpoint overrides the Cloud Storage JSON API endpoint, for example to
+	// target a local emulator. Requests to a custom endpoint are not
+	// authenticated.
+	Endpoint string
+	// PublicURL overrides DefaultGCPPublicURL in returned object URLs.
+	PublicURL  string
+	HTTPClient *http.Client
 }
 
 func NewGCPService(bucketName string) (Storage, error) {
-	client, err := storage.NewClient(context.Background())
+	return NewGCPServiceWithConfig(context.Background(), GCPConfig{Bucket: bucketName})
+}
+
+// NewGCPServiceWithConfig returns a Cloud Storage backend configured by cfg.
+func NewGCPServiceWithConfig(ctx context.Context, cfg GCPConfig) (Storage, error) {
+	var opts []option.ClientOption
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint), option.WithoutAuthentication())
+	}
+	if cfg.HTTPClient != nil {
+		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	}
+	client, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create storage client")
 	}
+	publicURL := strings.TrimSuffix(cfg.PublicURL, "/")
+	if publicURL == "" {
+		publicURL = DefaultGCPPublicURL
+	}
 	return &gcpService{
 		client:     client,
-		bucketName: bucketName,
+		bucketName: cfg.Bucket,
+		publicURL:  publicURL,
 	}, nil
 }
 
@@ -32,6 +69,7 @@ func (g *gcpService) Upload(ctx context.Context, data []byte, objectName string)
 	bucket := g.client.Bucket(g.bucketName)
 	obj := bucket.Object(objName)
 	w := obj.NewWriter(ctx)
+	w.ContentType = mediatype.Detect(data)
 	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
 		w.Close()
 		return "", errors.Wrap(err, "failed to write data")
@@ -42,6 +80,19 @@ func (g *gcpService) Upload(ctx context.Context, data []byte, objectName string)
 	if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
 		return "", errors.Wrap(err, "failed to set object ACL")
 	}
-	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", g.bucketName, objName)
+	url := fmt.Sprintf("%s/%s/%s", g.publicURL, g.bucketName, objName)
 	return url, nil
 }
+
+func (g *gcpService) Download(ctx context.Context, objectName string) ([]byte, error) {
+	r, err := g.client.Bucket(g.bucketName).Object(objectName).NewReader(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open object")
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read object")
+	}
+	return data, nil
+}
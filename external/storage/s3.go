@@ -4,27 +4,73 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	"github.com/pkg/errors"
 )
 
 type s3Service struct {
 	client     *s3.Client
 	bucketName string
+	publicURL  string
+}
+
+// S3Config holds the settings used to build an S3 backend.
+type S3Config struct {
+	Bucket string
+	Region string
+	// Endpoint overrides the S3 endpoint, for example to target MinIO or
+	// another S3-compatible server. Path-style addressing is used with it.
+	Endpoint string
+	// PublicURL overrides the https://<bucket>.s3.amazonaws.com base of
+	// returned object URLs.
+	PublicURL  string
+	HTTPClient *http.Client
 }
 
 func NewS3Service(bucketName string) (Storage, error) {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	return NewS3ServiceWithConfig(context.Background(), S3Config{Bucket: bucketName})
+}
+
+// NewS3ServiceWithConfig returns an S3 backend configured by cfg.
+func NewS3ServiceWithConfig(ctx context.Context, cfg S3Config) (Storage, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(cfg.Region))
+	}
+	if cfg.HTTPClient != nil {
+		loadOpts = append(loadOpts, config.WithHTTPClient(cfg.HTTPClient))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load AWS config")
 	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	publicURL := strings.TrimSuffix(cfg.PublicURL, "/")
+	if publicURL == "" {
+		if cfg.Endpoint != "" {
+			publicURL = fmt.Sprintf("%s/%s", strings.TrimSuffix(cfg.Endpoint, "/"), cfg.Bucket)
+		} else {
+			publicURL = fmt.Sprintf("https://%s.s3.amazonaws.com", cfg.Bucket)
+		}
+	}
 	return &s3Service{
-		client:     s3.NewFromConfig(cfg),
-		bucketName: bucketName,
+		client:     client,
+		bucketName: cfg.Bucket,
+		publicURL:  publicURL,
 	}, nil
 }
 
@@ -32,15 +78,32 @@ func (s *s3Service) Upload(ctx context.Context, data []byte, objectName string)
 	objName := generateObjectName(objectName)
 
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(objName),
-		Body:   bytes.NewReader(data),
-		ACL:    types.ObjectCannedACLPublicRead,
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(objName),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(mediatype.Detect(data)),
+		ACL:         types.ObjectCannedACLPublicRead,
 	})
 	if err != nil {
 		return "", errors.Wrap(err, "failed to put object")
 	}
 
-	url := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.bucketName, objName)
+	url := fmt.Sprintf("%s/%s", s.publicURL, objName)
 	return url, nil
 }
+
+func (s *s3Service) Download(ctx context.Context, objectName string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get object")
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read object")
+	}
+	return data, nil
+}
@@ -11,6 +11,13 @@ type Storage interface {
 	Upload(ctx context.Context, data []byte, objectName string) (string, error)
 }
 
+// Downloader is implemented by storages that can read objects back, as the
+// GCP and S3 backends do. It is kept out of Storage so that existing
+// implementations of that interface stay valid.
+type Downloader interface {
+	Download(ctx context.Context, objectName string) ([]byte, error)
+}
+
 // generateObjectName returns objectName if provided, otherwise a random UUID-based name.
 func generateObjectName(objectName string) string {
 	if objectName != "" {
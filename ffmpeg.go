@@ -0,0 +1,367 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMediaTooLarge is returned when an input or output exceeds the size
+// limits of an FFmpeg.
+var ErrMediaTooLarge = errors.New("media exceeds the size limit")
+
+// FFmpeg runs the ffmpeg and ffprobe binaries behind the video helpers. The
+// zero value runs the binaries found on the system PATH, keeps working files
+// in the system temp directory and sets no size limits; the package level
+// helpers such as MergeVideos use it. The methods of an FFmpeg work on files
+// and streams instead of byte slices and stop ffmpeg when their context is
+// done.
+type FFmpeg struct {
+	// Path and ProbePath are the ffmpeg and ffprobe binaries, "ffmpeg" and
+	// "ffprobe" by default.
+	Path      string
+	ProbePath string
+	// TempDir holds the working files of each operation, os.TempDir() by
+	// default.
+	TempDir string
+	// MaxInputSize and MaxOutputSize limit in bytes each input and each
+	// file ffmpeg writes. Zero means no limit.
+	MaxInputSize  int64
+	MaxOutputSize int64
+}
+
+// waitDelay bounds how long a stopped process may keep its output pipes
+// open before they are closed.
+const waitDelay = time.Second
+
+// defaultFFmpeg runs the package level helpers.
+var defaultFFmpeg = &FFmpeg{}
+
+func (f *FFmpeg) binary() string {
+	if f.Path != "" {
+		return f.Path
+	}
+	return "ffmpeg"
+}
+
+func (f *FFmpeg) probeBinary() string {
+	if f.ProbePath != "" {
+		return f.ProbePath
+	}
+	return "ffprobe"
+}
+
+// Run runs ffmpeg with args and reports its standard error on failure.
+func (f *FFmpeg) Run(ctx context.Context, args ...string) error {
+	return f.run(ctx, nil, args...)
+}
+
+func (f *FFmpeg) run(ctx context.Context, stdout io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, f.binary(), args...)
+	cmd.WaitDelay = waitDelay
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "ffmpeg stopped")
+		}
+		return fmt.Errorf("ffmpeg run error: %w, %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Probe inspects the media file at path with ffprobe.
+func (f *FFmpeg) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, f.probeBinary(), "-v", "error", "-show_streams", "-show_format", "-of", "json", path)
+	cmd.WaitDelay = waitDelay
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "ffprobe stopped")
+		}
+		return nil, fmt.Errorf("ffprobe run error: %w, %s", err, stderr.String())
+	}
+	return parseProbe(stdout.Bytes())
+}
+
+// checkSize reports whether size is within limit.
+func checkSize(name string, size, limit int64) error {
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w: %s is larger than %d bytes", ErrMediaTooLarge, name, limit)
+	}
+	return nil
+}
+
+// checkInput checks the size of the input file at path.
+func (f *FFmpeg) checkInput(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read input")
+	}
+	return checkSize(filepath.Base(path), st.Size(), f.MaxInputSize)
+}
+
+// checkOutput checks the size of a file written by ffmpeg, removing it when
+// it is too large.
+func (f *FFmpeg) checkOutput(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read output")
+	}
+	if err := checkSize("output", st.Size(), f.MaxOutputSize); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// limitOutput makes ffmpeg stop writing the output file, the last of args,
+// once it passes MaxOutputSize, so that checkOutput detects it.
+func (f *FFmpeg) limitOutput(args []string) []string {
+	if f.MaxOutputSize <= 0 || len(args) == 0 {
+		return args
+	}
+	return slices.Insert(slices.Clone(args), len(args)-1, "-fs", strconv.FormatInt(f.MaxOutputSize+1, 10))
+}
+
+// job is one operation run by an FFmpeg with its own working directory.
+type job struct {
+	ctx context.Context
+	f   *FFmpeg
+	dir string
+}
+
+func (f *FFmpeg) newJob(ctx context.Context, prefix string) (*job, error) {
+	dir, err := os.MkdirTemp(f.TempDir, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp dir")
+	}
+	return &job{ctx: ctx, f: f, dir: dir}, nil
+}
+
+func (j *job) close() { os.RemoveAll(j.dir) }
+
+// path returns the path of the working file called name.
+func (j *job) path(name string) string { return filepath.Join(j.dir, name) }
+
+// write stores data as the working file called name, checking it against
+// MaxInputSize.
+func (j *job) write(name string, data []byte) (string, error) {
+	if err := checkSize(name, int64(len(data)), j.f.MaxInputSize); err != nil {
+		return "", err
+	}
+	path := j.path(name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", errors.Wrapf(err, "failed to write %s", name)
+	}
+	return path, nil
+}
+
+// spool copies r to the working file called name, failing as soon as more
+// than MaxInputSize bytes are read.
+func (j *job) spool(name string, r io.Reader) (string, error) {
+	path := j.path(name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create %s", name)
+	}
+	if j.f.MaxInputSize > 0 {
+		r = io.LimitReader(r, j.f.MaxInputSize+1)
+	}
+	n, err := io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write %s", name)
+	}
+	if err := checkSize(name, n, j.f.MaxInputSize); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// run runs ffmpeg with args, whose last element is the output file.
+func (j *job) run(args ...string) error {
+	return j.f.run(j.ctx, nil, j.f.limitOutput(args)...)
+}
+
+// read returns the output file at path.
+func (j *job) read(path string) ([]byte, error) {
+	if err := j.f.checkOutput(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read output")
+	}
+	return data, nil
+}
+
+// probe probes the file at path and checks that it has the required
+// streams. name describes the file in errors.
+func (j *job) probe(path, name string, video, audio bool) (*MediaInfo, error) {
+	info, err := j.f.Probe(j.ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to probe %s", name)
+	}
+	if video && info.Video == nil {
+		return nil, fmt.Errorf("%w: %s has no video stream", ErrInvalidParameters, name)
+	}
+	if audio && info.Audio == nil {
+		return nil, fmt.Errorf("%w: %s has no audio stream", ErrInvalidParameters, name)
+	}
+	return info, nil
+}
+
+// VideoOp is an operation on a single video, such as TrimOp or GIFOp, run by
+// FFmpeg.Transform and FFmpeg.TransformFile. Invalid parameters are reported
+// when the operation runs.
+type VideoOp struct {
+	// format is the muxer of operations whose output ffmpeg can stream to a
+	// pipe. MP4 output needs a seekable file and leaves it empty.
+	format string
+	err    error
+	args   func(j *job, in, out string, info *MediaInfo) ([]string, error)
+}
+
+// invalidOp returns an operation failing with err.
+func invalidOp(err error) VideoOp {
+	return VideoOp{err: err}
+}
+
+// TransformFile applies op to the video file at in and writes the result
+// to out. The output container follows op, or the extension of out for
+// operations producing MP4.
+func (f *FFmpeg) TransformFile(ctx context.Context, in, out string, op VideoOp) error {
+	if op.err != nil {
+		return op.err
+	}
+	if err := f.checkInput(in); err != nil {
+		return err
+	}
+	j, err := f.newJob(ctx, "videoop")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	if err := j.transform(in, out, op, nil); err != nil {
+		return err
+	}
+	return f.checkOutput(out)
+}
+
+// Transform applies op to the video read from r and writes the result to w.
+// The input is spooled to a temp file, since ffmpeg needs to seek in MP4
+// files. GIF and WebM output is streamed from ffmpeg to w as it is encoded,
+// so when it exceeds MaxOutputSize w holds its first MaxOutputSize bytes
+// and ErrMediaTooLarge is returned. MP4 output is written to a temp file
+// first.
+func (f *FFmpeg) Transform(ctx context.Context, r io.Reader, w io.Writer, op VideoOp) error {
+	if op.err != nil {
+		return op.err
+	}
+	j, err := f.newJob(ctx, "videoop")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	in, err := j.spool("input", r)
+	if err != nil {
+		return err
+	}
+
+	if op.format != "" {
+		lw := &limitWriter{w: w, limit: f.MaxOutputSize}
+		err := j.transform(in, "pipe:1", op, lw)
+		if lw.err != nil {
+			// ffmpeg then fails on a broken pipe; report the limit instead.
+			return lw.err
+		}
+		return err
+	}
+
+	out := j.path("output.mp4")
+	if err := j.transform(in, out, op, nil); err != nil {
+		return err
+	}
+	return f.copyOutput(out, w)
+}
+
+// copyOutput checks the output file at path and copies it to w.
+func (f *FFmpeg) copyOutput(path string, w io.Writer) error {
+	if err := f.checkOutput(path); err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read output")
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return errors.Wrap(err, "failed to write output")
+	}
+	return nil
+}
+
+// transformBytes is Transform for videos held in memory.
+func (f *FFmpeg) transformBytes(ctx context.Context, video []byte, op VideoOp) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.Transform(ctx, bytes.NewReader(video), &buf, op); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// transform probes the video at in and runs op, sending ffmpeg's standard
+// output to stdout.
+func (j *job) transform(in, out string, op VideoOp, stdout io.Writer) error {
+	info, err := j.probe(in, "video", true, false)
+	if err != nil {
+		return err
+	}
+	args, err := op.args(j, in, out, info)
+	if err != nil {
+		return err
+	}
+	return j.f.run(j.ctx, stdout, j.f.limitOutput(args)...)
+}
+
+// limitWriter passes at most limit bytes to w, failing with
+// ErrMediaTooLarge past them. A zero limit means no limit.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+	err   error
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if l.limit > 0 && l.n+int64(len(p)) > l.limit {
+		n, err := l.w.Write(p[:l.limit-l.n])
+		l.n += int64(n)
+		if err != nil {
+			return n, err
+		}
+		l.err = checkSize("output", l.n+1, l.limit)
+		return n, l.err
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,203 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg returns an FFmpeg running shell scripts in place of ffmpeg and
+// ffprobe. The fake ffprobe reports a one second 320x240 video and the fake
+// ffmpeg writes "fake:" followed by its first argument to its output.
+func fakeFFmpeg(t *testing.T) *FFmpeg {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+	dir := t.TempDir()
+	probe := filepath.Join(dir, "ffprobe")
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	scripts := map[string]string{
+		probe: `echo '{"streams":[{"codec_type":"video","codec_name":"h264","width":320,"height":240}],"format":{"duration":"1.0"}}'`,
+		ffmpeg: `for a; do out=$a; done
+[ "$1" = sleep ] && sleep 5
+if [ "$out" = pipe:1 ]; then printf 'fake:%s' "$1"; else printf 'fake:%s' "$1" > "$out"; fi`,
+	}
+	for path, script := range scripts {
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return &FFmpeg{Path: ffmpeg, ProbePath: probe, TempDir: tmp}
+}
+
+func TestFFmpegTransform(t *testing.T) {
+	f := fakeFFmpeg(t)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := f.Transform(ctx, strings.NewReader("video"), &out, TrimOp(0, 500*time.Millisecond)); err != nil {
+		t.Fatalf("Transform returned error: %v", err)
+	}
+	if out.String() != "fake:-ss" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	out.Reset()
+	if err := f.Transform(ctx, strings.NewReader("video"), &out, GIFOp(GIFOptions{})); err != nil {
+		t.Fatalf("Transform returned error: %v", err)
+	}
+	if out.String() != "fake:-ss" {
+		t.Fatalf("unexpected streamed output %q", out.String())
+	}
+
+	in := filepath.Join(t.TempDir(), "in.mp4")
+	os.WriteFile(in, []byte("video"), 0o600)
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := f.TransformFile(ctx, in, dst, ReverseOp()); err != nil {
+		t.Fatalf("TransformFile returned error: %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "fake:-i" {
+		t.Fatalf("unexpected output file %q", data)
+	}
+
+	if err := f.Transform(ctx, strings.NewReader("video"), &out, TrimOp(2*time.Second, 0)); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for a segment past the end, got %v", err)
+	}
+	if err := f.Transform(ctx, strings.NewReader("video"), &out, LoopOp(0)); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for loop duration, got %v", err)
+	}
+	if entries, _ := os.ReadDir(f.TempDir); len(entries) != 0 {
+		t.Fatalf("temp dir not cleaned up: %v", entries)
+	}
+}
+
+func TestFFmpegFiles(t *testing.T) {
+	f := fakeFFmpeg(t)
+	ctx := context.Background()
+	in := filepath.Join(t.TempDir(), "in.mp4")
+	os.WriteFile(in, []byte("video"), 0o600)
+
+	frame, err := f.ExtractFrameFile(ctx, in, 500*time.Millisecond)
+	if err != nil || string(frame) != "fake:-ss" {
+		t.Fatalf("ExtractFrameFile returned %q: %v", frame, err)
+	}
+	frames, err := f.SampleFrames(ctx, strings.NewReader("video"), 2)
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("SampleFrames returned %d frames: %v", len(frames), err)
+	}
+	if _, err := f.ExtractFrameFile(ctx, in, 2*time.Second); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters past the end, got %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := f.AppendVideoFiles(ctx, in, in, dst); err != nil {
+		t.Fatalf("AppendVideoFiles returned error: %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "fake:-i" {
+		t.Fatalf("unexpected output file %q", data)
+	}
+	if entries, _ := os.ReadDir(f.TempDir); len(entries) != 0 {
+		t.Fatalf("temp dir not cleaned up: %v", entries)
+	}
+}
+
+func TestFFmpegHLSSizeCountsOwnFiles(t *testing.T) {
+	f := fakeFFmpeg(t)
+	// Write a playlist listing one four byte segment.
+	script := `for a; do out=$a; done
+dir=$(dirname "$out")
+printf '#EXTM3U\nsegment000.ts\n#EXT-X-ENDLIST\n' > "$out"
+printf 'abcd' > "$dir/segment000.ts"
+`
+	f.Path = filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(f.Path, []byte("#!/bin/sh\n"+script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(t.TempDir(), "in.mp4")
+	os.WriteFile(in, []byte("video"), 0o600)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "unrelated.bin"), make([]byte, 1000), 0o600)
+
+	f.MaxOutputSize = 100
+	playlist, err := f.ExportHLSFile(context.Background(), in, dir, HLSOptions{})
+	if err != nil {
+		t.Fatalf("ExportHLSFile returned error: %v", err)
+	}
+	if playlist != filepath.Join(dir, "index.m3u8") {
+		t.Fatalf("unexpected playlist %s", playlist)
+	}
+	f.MaxOutputSize = 30
+	if _, err := f.ExportHLSFile(context.Background(), in, dir, HLSOptions{}); !errors.Is(err, ErrMediaTooLarge) {
+		t.Fatalf("expected ErrMediaTooLarge, got %v", err)
+	}
+}
+
+func TestFFmpegLimits(t *testing.T) {
+	f := fakeFFmpeg(t)
+	ctx := context.Background()
+
+	f.MaxInputSize = 4
+	if err := f.Transform(ctx, strings.NewReader("video"), &bytes.Buffer{}, ReverseOp()); !errors.Is(err, ErrMediaTooLarge) {
+		t.Fatalf("expected ErrMediaTooLarge for input, got %v", err)
+	}
+	if _, err := f.mergeVideos(ctx, [][]byte{[]byte("video")}, MergeOptions{}); !errors.Is(err, ErrMediaTooLarge) {
+		t.Fatalf("expected ErrMediaTooLarge for merge input, got %v", err)
+	}
+
+	f.MaxInputSize, f.MaxOutputSize = 0, 4
+	for _, op := range []VideoOp{ReverseOp(), GIFOp(GIFOptions{})} {
+		var out bytes.Buffer
+		if err := f.Transform(ctx, strings.NewReader("video"), &out, op); !errors.Is(err, ErrMediaTooLarge) {
+			t.Fatalf("expected ErrMediaTooLarge for output, got %v", err)
+		}
+		if out.Len() > 4 {
+			t.Fatalf("wrote %d bytes past the limit", out.Len())
+		}
+	}
+
+	args := f.limitOutput([]string{"-i", "in.mp4", "-y", "out.mp4"})
+	if !slices.Equal(args, []string{"-i", "in.mp4", "-y", "-fs", "5", "out.mp4"}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestFFmpegCancel(t *testing.T) {
+	f := fakeFFmpeg(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := f.Run(ctx, "sleep", "out"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatal("ffmpeg was not stopped")
+	}
+}
+
+func TestWorkflowUsesConfiguredFFmpeg(t *testing.T) {
+	f := fakeFFmpeg(t)
+	svc := NewWorkflowService(WithFFmpeg(f))
+	wf := &Workflow{Steps: []WorkflowStep{{ID: "fast", FunctionType: FunctionTypeChangeVideoSpeed, Video: "clip", Options: map[string]any{"factor": 2}}}}
+	result, _, err := svc.Generate(context.Background(), wf, map[string]any{"clip": []byte("video")})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	art, ok := result.(*Artifact)
+	if !ok || string(art.Data) != "fake:-i" {
+		t.Fatalf("unexpected result %#v", result)
+	}
+	// The result is probed with the configured ffprobe too.
+	if art.Media == nil || art.Media.Video == nil || art.Media.Video.Width != 320 {
+		t.Fatalf("unexpected media %+v", art.Media)
+	}
+}
@@ -0,0 +1,232 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// frameSource is a video written to a job's directory for frame
+// extraction.
+type frameSource struct {
+	*job
+	path string
+	info *MediaInfo
+}
+
+// newFrameSource spools the video read from r for frame extraction.
+func (f *FFmpeg) newFrameSource(ctx context.Context, r io.Reader) (*frameSource, error) {
+	j, err := f.newJob(ctx, "frames")
+	if err != nil {
+		return nil, err
+	}
+	path, err := j.spool("input.mp4", r)
+	if err != nil {
+		j.close()
+		return nil, err
+	}
+	return j.frameSource(path)
+}
+
+// openFrameSource prepares the video file at path for frame extraction
+// without copying it.
+func (f *FFmpeg) openFrameSource(ctx context.Context, path string) (*frameSource, error) {
+	if err := f.checkInput(path); err != nil {
+		return nil, err
+	}
+	j, err := f.newJob(ctx, "frames")
+	if err != nil {
+		return nil, err
+	}
+	return j.frameSource(path)
+}
+
+// frameSource probes the video at path, closing the job when it is not
+// usable.
+func (j *job) frameSource(path string) (*frameSource, error) {
+	info, err := j.probe(path, "video", true, false)
+	if err != nil {
+		j.close()
+		return nil, err
+	}
+	return &frameSource{job: j, path: path, info: info}, nil
+}
+
+// grab runs ffmpeg with args followed by an output PNG file and returns the
+// image written.
+func (s *frameSource) grab(name string, args ...string) ([]byte, error) {
+	out := s.job.path(name + ".png")
+	if err := s.run(append(args, "-y", out)...); err != nil {
+		return nil, err
+	}
+	return s.read(out)
+}
+
+// at grabs the frame shown at t. The end of the video shows its last
+// frame, which ffmpeg cannot seek to.
+func (s *frameSource) at(name string, t time.Duration) ([]byte, error) {
+	if t < 0 || t > s.info.Duration {
+		return nil, fmt.Errorf("%w: timestamp %v is outside the video of %v", ErrInvalidParameters, t, s.info.Duration)
+	}
+	if t == s.info.Duration {
+		return s.last()
+	}
+	return s.grab(name, "-ss", fmt.Sprintf("%.3f", t.Seconds()), "-i", s.path, "-frames:v", "1")
+}
+
+// ExtractFrame returns the frame of video shown at t as a PNG image. ffmpeg
+// and ffprobe must be installed and accessible on the system PATH.
+func ExtractFrame(video []byte, t time.Duration) ([]byte, error) {
+	return defaultFFmpeg.ExtractFrame(context.Background(), bytes.NewReader(video), t)
+}
+
+// ExtractFrame is the package level ExtractFrame for the video read from r.
+func (f *FFmpeg) ExtractFrame(ctx context.Context, r io.Reader, t time.Duration) ([]byte, error) {
+	src, err := f.newFrameSource(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.at("frame", t)
+}
+
+// ExtractFrameFile is ExtractFrame for the video file at path.
+func (f *FFmpeg) ExtractFrameFile(ctx context.Context, path string, t time.Duration) ([]byte, error) {
+	src, err := f.openFrameSource(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.at("frame", t)
+}
+
+// FirstFrame returns the first frame of video as a PNG image.
+func FirstFrame(video []byte) ([]byte, error) {
+	return ExtractFrame(video, 0)
+}
+
+// LastFrame returns the last frame of video as a PNG image, e.g. to use as
+// the first frame of a follow-up clip.
+func LastFrame(video []byte) ([]byte, error) {
+	return defaultFFmpeg.LastFrame(context.Background(), bytes.NewReader(video))
+}
+
+// LastFrame is the package level LastFrame for the video read from r.
+func (f *FFmpeg) LastFrame(ctx context.Context, r io.Reader) ([]byte, error) {
+	src, err := f.newFrameSource(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.last()
+}
+
+// LastFrameFile is LastFrame for the video file at path.
+func (f *FFmpeg) LastFrameFile(ctx context.Context, path string) ([]byte, error) {
+	src, err := f.openFrameSource(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.last()
+}
+
+// last grabs the final frame.
+func (s *frameSource) last() ([]byte, error) {
+	// Decode the final second and keep overwriting the output, so that the
+	// last decoded frame remains.
+	return s.grab("last", "-sseof", "-1", "-i", s.path, "-update", "1")
+}
+
+// SampleFrames returns n PNG frames spread evenly over video, each taken
+// from the middle of one of n equal parts.
+func SampleFrames(video []byte, n int) ([][]byte, error) {
+	return defaultFFmpeg.SampleFrames(context.Background(), bytes.NewReader(video), n)
+}
+
+// SampleFrames is the package level SampleFrames for the video read from r.
+func (f *FFmpeg) SampleFrames(ctx context.Context, r io.Reader, n int) ([][]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("%w: frame count must be positive", ErrInvalidParameters)
+	}
+	src, err := f.newFrameSource(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.sample(n)
+}
+
+// SampleFramesFile is SampleFrames for the video file at path.
+func (f *FFmpeg) SampleFramesFile(ctx context.Context, path string, n int) ([][]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("%w: frame count must be positive", ErrInvalidParameters)
+	}
+	src, err := f.openFrameSource(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.sample(n)
+}
+
+// sample grabs n frames from the middles of n equal parts.
+func (s *frameSource) sample(n int) ([][]byte, error) {
+	frames := make([][]byte, n)
+	for i := range frames {
+		t := s.info.Duration * time.Duration(2*i+1) / time.Duration(2*n)
+		var err error
+		if frames[i], err = s.at(fmt.Sprintf("frame%d", i), t); err != nil {
+			return nil, errors.Wrapf(err, "failed to extract frame %d", i)
+		}
+	}
+	return frames, nil
+}
+
+// VideoThumbnail returns a representative frame of video as a PNG poster,
+// chosen by ffmpeg's thumbnail filter among the first frames. A positive
+// width scales the poster, keeping its aspect ratio.
+func VideoThumbnail(video []byte, width int) ([]byte, error) {
+	return defaultFFmpeg.VideoThumbnail(context.Background(), bytes.NewReader(video), width)
+}
+
+// VideoThumbnail is the package level VideoThumbnail for the video read
+// from r.
+func (f *FFmpeg) VideoThumbnail(ctx context.Context, r io.Reader, width int) ([]byte, error) {
+	if width < 0 {
+		return nil, fmt.Errorf("%w: negative thumbnail width", ErrInvalidParameters)
+	}
+	src, err := f.newFrameSource(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.poster(width)
+}
+
+// VideoThumbnailFile is VideoThumbnail for the video file at path.
+func (f *FFmpeg) VideoThumbnailFile(ctx context.Context, path string, width int) ([]byte, error) {
+	if width < 0 {
+		return nil, fmt.Errorf("%w: negative thumbnail width", ErrInvalidParameters)
+	}
+	src, err := f.openFrameSource(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	return src.poster(width)
+}
+
+// poster grabs the frame picked by the thumbnail filter, scaled to width
+// when it is positive.
+func (s *frameSource) poster(width int) ([]byte, error) {
+	filter := "thumbnail"
+	if width > 0 {
+		filter += fmt.Sprintf(",scale=%d:-2", width)
+	}
+	return s.grab("poster", "-i", s.path, "-vf", filter, "-frames:v", "1")
+}
@@ -0,0 +1,112 @@
+package genailib
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractFrames(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+
+	for name, extract := range map[string]func([]byte) ([]byte, error){
+		"first":  FirstFrame,
+		"last":   LastFrame,
+		"middle": func(v []byte) ([]byte, error) { return ExtractFrame(v, 500*time.Millisecond) },
+		"poster": func(v []byte) ([]byte, error) { return VideoThumbnail(v, 160) },
+	} {
+		frame, err := extract(vid)
+		if err != nil {
+			t.Fatalf("%s: returned error: %v", name, err)
+		}
+		img, format, err := DecodeImage(frame)
+		if err != nil || format != FormatPNG {
+			t.Fatalf("%s: expected a PNG, got %s: %v", name, format, err)
+		}
+		if r, g, _, _ := img.At(10, 10).RGBA(); r < 0xc000 || g > 0x4000 {
+			t.Fatalf("%s: expected a red frame, got %v", name, img.At(10, 10))
+		}
+		if name == "poster" && img.Bounds().Dx() != 160 {
+			t.Fatalf("poster: expected width 160, got %d", img.Bounds().Dx())
+		}
+	}
+
+	frames, err := SampleFrames(vid, 3)
+	if err != nil || len(frames) != 3 {
+		t.Fatalf("SampleFrames returned %d frames: %v", len(frames), err)
+	}
+	if _, err := ExtractFrame(vid, time.Minute); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters past the end, got %v", err)
+	}
+}
+
+func TestExtractFrameAtEnd(t *testing.T) {
+	f := fakeFFmpeg(t)
+	// The fake video lasts a second: its end is served as the last frame.
+	frame, err := f.ExtractFrame(context.Background(), strings.NewReader("video"), time.Second)
+	if err != nil || string(frame) != "fake:-sseof" {
+		t.Fatalf("ExtractFrame returned %q: %v", frame, err)
+	}
+}
+
+func TestSampleFramesInvalid(t *testing.T) {
+	if _, err := SampleFrames(nil, 0); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters, got %v", err)
+	}
+	if _, err := VideoThumbnail(nil, -1); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters, got %v", err)
+	}
+}
+
+func TestWorkflowVideoToImageOptions(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{ID: "frame", FunctionType: FunctionTypeVideoToImage, Video: "clip", Options: map[string]any{"index": 3}}}}
+	if _, _, err := svc.Generate(context.Background(), wf, map[string]any{"clip": []byte("video")}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for unknown option, got %v", err)
+	}
+	wf.Steps[0].Options = nil
+	wf.Steps[0].Video = ""
+	if _, _, err := svc.Generate(context.Background(), wf, nil); err == nil {
+		t.Fatal("expected error without a video")
+	}
+	if err := ValidateWorkflow(&Workflow{Steps: []WorkflowStep{{ID: "frame", FunctionType: FunctionTypeVideoToImage}}}); err != nil {
+		t.Fatalf("ValidateWorkflow returned error: %v", err)
+	}
+}
+
+func TestWorkflowVideoFromFrameReferences(t *testing.T) {
+	rep := fakeReplicateServer(t, "https://example.com/clip.mp4", func(input map[string]any) {
+		if image, _ := input["image"].(string); !strings.HasPrefix(image, "data:image/png;base64,") {
+			t.Errorf("expected the first frame as a data URI, got %v", input["image"])
+		}
+		if input["last_frame_image"] != "https://example.com/last.png" {
+			t.Errorf("unexpected last frame %v", input["last_frame_image"])
+		}
+	})
+	svc := NewWorkflowService(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "clip",
+		FunctionType: FunctionTypeTextAndImagesToVideo,
+		Provider:     ProviderSeedance1Lite,
+		Prompt:       "a cat walks",
+		FirstImage:   "frame",
+		LastImage:    "https://example.com/last.png",
+	}}}
+	inputs := map[string]any{"frame": &Artifact{Data: pngMagic, MIMEType: "image/png"}}
+	result, _, err := svc.Generate(context.Background(), wf, inputs)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if result != "https://example.com/clip.mp4" {
+		t.Fatalf("unexpected result %v", result)
+	}
+}
@@ -1,17 +1,170 @@
 package genailib
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"math"
+	"strings"
+
+	"github.com/iomodo/gen-ai-lib/external/gemini"
+	"github.com/iomodo/gen-ai-lib/external/openai"
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
+	goopenai "github.com/sashabaranov/go-openai"
 )
 
-// ServiceFunc defines the function signature for API services.
-type ServiceFunc func(args ...any) (any, error)
+// ImageRequest describes a text-to-image generation.
+type ImageRequest struct {
+	Prompt         string
+	NegativePrompt string
+	// Size is a WIDTHxHEIGHT string such as "1024x1024".
+	Size string
+	// N is the number of images to generate. Zero means one.
+	N     int
+	Seed  *int64
+	Style string
+	// Quality is a provider quality level such as "high" or "hd".
+	Quality string
+	// Format is the output format: "png", "jpeg" or "webp".
+	Format string
+	// Compression is the 0-100 compression level for jpeg and webp output.
+	Compression *int
+	// Background is "transparent", "opaque" or "auto".
+	Background string
+	// Moderation is the provider moderation level, e.g. "low" or "auto".
+	Moderation string
+	// Extra holds provider specific inputs that are passed through unchanged.
+	Extra map[string]any
+}
+
+// ImageResponse holds the images produced for an ImageRequest.
+type ImageResponse struct {
+	Images []Artifact
+	Usage  Usage
+}
+
+// ImageField identifies an optional ImageRequest field.
+type ImageField uint
+
+// Optional ImageRequest fields an adapter may support.
+const (
+	ImageFieldNegativePrompt ImageField = 1 << iota
+	ImageFieldSize
+	ImageFieldN
+	ImageFieldSeed
+	ImageFieldStyle
+	ImageFieldExtra
+	ImageFieldMask
+	ImageFieldReferenceImages
+	ImageFieldQuality
+	ImageFieldFormat
+	ImageFieldCompression
+	ImageFieldBackground
+	ImageFieldModeration
+)
+
+var imageFieldNames = []struct {
+	field ImageField
+	name  string
+}{
+	{ImageFieldNegativePrompt, "negative_prompt"},
+	{ImageFieldSize, "size"},
+	{ImageFieldN, "n"},
+	{ImageFieldSeed, "seed"},
+	{ImageFieldStyle, "style"},
+	{ImageFieldExtra, "extra options"},
+	{ImageFieldMask, "mask"},
+	{ImageFieldReferenceImages, "reference images"},
+	{ImageFieldQuality, "quality"},
+	{ImageFieldFormat, "output_format"},
+	{ImageFieldCompression, "output_compression"},
+	{ImageFieldBackground, "background"},
+	{ImageFieldModeration, "moderation"},
+}
+
+// fields returns the optional fields set on r.
+func (r ImageRequest) fields() ImageField {
+	var f ImageField
+	if r.NegativePrompt != "" {
+		f |= ImageFieldNegativePrompt
+	}
+	if r.Size != "" {
+		f |= ImageFieldSize
+	}
+	if r.N > 1 {
+		f |= ImageFieldN
+	}
+	if r.Seed != nil {
+		f |= ImageFieldSeed
+	}
+	if r.Style != "" {
+		f |= ImageFieldStyle
+	}
+	if r.Quality != "" {
+		f |= ImageFieldQuality
+	}
+	if r.Format != "" {
+		f |= ImageFieldFormat
+	}
+	if r.Compression != nil {
+		f |= ImageFieldCompression
+	}
+	if r.Background != "" {
+		f |= ImageFieldBackground
+	}
+	if r.Moderation != "" {
+		f |= ImageFieldModeration
+	}
+	if len(r.Extra) > 0 {
+		f |= ImageFieldExtra
+	}
+	return f
+}
+
+// ImageServiceFunc generates images for a validated request.
+type ImageServiceFunc func(ctx context.Context, req ImageRequest) (*ImageResponse, error)
+
+// imageService represents a single text-to-image endpoint.
+type imageService struct {
+	name     string
+	supports ImageField
+	fn       ImageServiceFunc
+}
+
+// maxImages is the largest N a single request may ask for.
+const maxImages = 10
 
-// service represents a single API endpoint.
-type service struct {
-	name string
-	fn   ServiceFunc
+// validate rejects requests that set fields the service does not support.
+// N is accepted by every service since the gateway repeats the request for
+// services that cannot produce several images at once.
+func (s *imageService) validate(req ImageRequest) error {
+	if req.N > maxImages {
+		return fmt.Errorf("%w: n must not exceed %d", ErrInvalidParameters, maxImages)
+	}
+	return validateRequest(s.name, req.Prompt, req.N, req.fields(), s.supports|ImageFieldN)
+}
+
+func validateRequest(name, prompt string, n int, set, supports ImageField) error {
+	if strings.TrimSpace(prompt) == "" {
+		return fmt.Errorf("%w: prompt is required", ErrInvalidParameters)
+	}
+	if n < 0 {
+		return fmt.Errorf("%w: n must not be negative", ErrInvalidParameters)
+	}
+	unsupported := set &^ supports
+	if unsupported == 0 {
+		return nil
+	}
+	var names []string
+	for _, f := range imageFieldNames {
+		if unsupported&f.field != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return fmt.Errorf("%w: %s does not support %s", ErrInvalidParameters, name, strings.Join(names, ", "))
 }
 
 // Standard errors used by the gateway.
@@ -23,50 +176,338 @@ var (
 )
 
 // APIGateway provides methods to select between different services.
-type APIGateway struct{}
-
-// NewAPIGateway returns a new APIGateway instance.
-func NewAPIGateway() *APIGateway { return &APIGateway{} }
+type APIGateway struct {
+	providers            *providers
+	textToImageServices  []*imageService
+	imageToImageServices []*editService
+}
 
-// Placeholder implementation for all service functions.
-func notImplemented(args ...any) (any, error) { return nil, errors.New("not implemented") }
+// NewAPIGateway returns a new APIGateway instance configured by opts.
+func NewAPIGateway(opts ...Option) *APIGateway {
+	return newAPIGateway(newProviders(NewConfig(opts...)))
+}
 
-var (
-	textToImageServices = []*service{
-		{name: "imagen-3.0-generate-002", fn: notImplemented},
-		{name: "gemini-2.0-flash-exp-image-generation", fn: notImplemented},
+func newAPIGateway(p *providers) *APIGateway {
+	g := &APIGateway{providers: p}
+	// Imagen only takes a seed on Vertex AI.
+	imagenFields := ImageFieldN
+	if p.cfg.Gemini.UseVertexAI {
+		imagenFields |= ImageFieldSeed
 	}
-)
+	g.textToImageServices = []*imageService{
+		{name: ProviderImagen3Generate002, supports: imagenFields, fn: g.imagen3},
+		{name: ProviderGemini20FlashExpImageGeneration, fn: g.geminiFlash},
+		{name: ProviderGPTImage1, supports: gptImage1Fields, fn: g.gptImage1},
+		{name: ProviderDallE3, supports: ImageFieldSize | ImageFieldQuality | ImageFieldStyle, fn: g.dallE3},
+	}
+	g.imageToImageServices = []*editService{
+		{name: ProviderGPTImage1, supports: ImageFieldMask | ImageFieldReferenceImages | ImageFieldSize | ImageFieldN, fn: g.editGPTImage1},
+		{name: ProviderGemini20FlashExpImageGeneration, supports: ImageFieldReferenceImages, fn: g.editGeminiFlash},
+	}
+	return g
+}
 
-func (g *APIGateway) getService(list []*service, preferredService string) *service {
-	if preferredService != "" {
-		for _, api := range list {
-			if api.name == preferredService {
-				return api
-			}
+// getService returns the preferred service, or the first one when no
+// preference is given. Names of the form "owner/model" select a Replicate
+// model.
+func (g *APIGateway) getService(list []*imageService, preferredService string) *imageService {
+	if preferredService == "" {
+		return list[0]
+	}
+	for _, api := range list {
+		if api.name == preferredService {
+			return api
 		}
 	}
-	return textToImageServices[0]
+	if strings.Contains(preferredService, "/") {
+		return g.replicateImageService(preferredService)
+	}
+	return nil
 }
 
 // TextToImage executes a text-to-image workflow step.
-func (g *APIGateway) TextToImage(prompt, preferredService string) (any, error) {
-	api := g.getService(textToImageServices, preferredService)
+func (g *APIGateway) TextToImage(ctx context.Context, req ImageRequest, preferredService string) (*ImageResponse, error) {
+	api := g.getService(g.textToImageServices, preferredService)
 	if api == nil {
 		log.Printf("TextToImage error: %v", ErrNoAPIAvailable)
 		return nil, ErrNoAPIAvailable
 	}
-	res, err := api.fn(prompt)
+	if err := api.validate(req); err != nil {
+		return nil, err
+	}
+	// Services generate several images in one call when they can, unless a
+	// seed is given: each image then gets its own seed, Seed+i, so that any
+	// single image can be reproduced later.
+	if req.N <= 1 || (api.supports&ImageFieldN != 0 && req.Seed == nil) {
+		return g.generate(ctx, api, req)
+	}
+	out := &ImageResponse{}
+	for i := range req.N {
+		one := req
+		one.N = 0
+		if req.Seed != nil {
+			seed := *req.Seed + int64(i)
+			one.Seed = &seed
+		}
+		res, err := g.generate(ctx, api, one)
+		if err != nil {
+			return nil, err
+		}
+		out.Images = append(out.Images, res.Images...)
+		out.Usage.Requests += res.Usage.Requests
+		out.Usage.Spend += res.Usage.Spend
+	}
+	return out, nil
+}
+
+// generate runs a single request on api, recording the seed it used on
+// every image.
+func (g *APIGateway) generate(ctx context.Context, api *imageService, req ImageRequest) (*ImageResponse, error) {
+	res, err := g.call(ctx, "TextToImage", api.name, req.N, func(ctx context.Context) (*ImageResponse, error) {
+		return api.fn(ctx, req)
+	})
 	if err != nil {
+		return nil, err
+	}
+	if req.Seed != nil {
+		for i := range res.Images {
+			res.Images[i].Seed = req.Seed
+		}
+	}
+	return res, nil
+}
+
+// call runs fn for a request asking for n images, mapping provider errors
+// onto the gateway errors and recording the usage of a successful request.
+// A tenant is charged for n images before the call and for the images
+// actually returned after it.
+func (g *APIGateway) call(ctx context.Context, op, name string, n int, fn func(ctx context.Context) (*ImageResponse, error)) (*ImageResponse, error) {
+	n = max(n, 1)
+	res, err := fn(withImageCount(ctx, n))
+	if err != nil {
+		err = classifyError(err)
 		if errors.Is(err, ErrContentPolicy) || errors.Is(err, ErrInvalidParameters) {
 			return nil, err
 		}
 		if errors.Is(err, ErrRateLimitExceeded) {
-			log.Printf("TextToImage %s rate limit exceeded", api.name)
+			log.Printf("%s %s rate limit exceeded", op, name)
 		} else {
-			log.Printf("TextToImage %s error: %v", api.name, err)
+			log.Printf("%s %s error: %v", op, name, err)
 		}
 		return nil, err
 	}
+	price := priceOf(ctx, name)
+	res.Usage = Usage{Requests: 1, Spend: price * float64(len(res.Images))}
+	if t, ok := TenantFromContext(ctx); ok {
+		t.settle(price*float64(n), res.Usage.Spend)
+	}
 	return res, nil
 }
+
+// imagenMaxImages is the most images Imagen returns for one request.
+const imagenMaxImages = 4
+
+func (g *APIGateway) imagen3(ctx context.Context, req ImageRequest) (*ImageResponse, error) {
+	if req.N > imagenMaxImages {
+		return nil, fmt.Errorf("%w: %s returns at most %d images per request", ErrInvalidParameters, ProviderImagen3Generate002, imagenMaxImages)
+	}
+	opts := gemini.ImagenOptions{N: req.N}
+	if req.Seed != nil {
+		if *req.Seed < math.MinInt32 || *req.Seed > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: %s seeds must fit in 32 bits", ErrInvalidParameters, ProviderImagen3Generate002)
+		}
+		seed := int32(*req.Seed)
+		opts.Seed = &seed
+	}
+	res, err := g.providers.withGemini(ctx, ProviderImagen3Generate002, func(svc gemini.GeminiService) (any, error) {
+		if ig, ok := svc.(gemini.ImagenGenerator); ok {
+			return ig.GenerateImagen3Images(ctx, req.Prompt, opts)
+		}
+		if opts.N > 1 || opts.Seed != nil {
+			return nil, fmt.Errorf("%w: the Gemini service does not support Imagen options", ErrInvalidParameters)
+		}
+		img, err := svc.GenerateImagen3Image(ctx, req.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{img}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytesResponse(GeminiProvider, ProviderImagen3Generate002, res.([][]byte)...)
+}
+
+func (g *APIGateway) geminiFlash(ctx context.Context, req ImageRequest) (*ImageResponse, error) {
+	return g.geminiImage(ctx, ProviderGemini20FlashExpImageGeneration, func(svc gemini.GeminiService) ([]byte, error) {
+		return svc.GenerateFlash2Image(ctx, req.Prompt)
+	})
+}
+
+func (g *APIGateway) geminiImage(ctx context.Context, model string, gen func(gemini.GeminiService) ([]byte, error)) (*ImageResponse, error) {
+	res, err := g.providers.withGemini(ctx, model, func(svc gemini.GeminiService) (any, error) {
+		return gen(svc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytesResponse(GeminiProvider, model, res.([]byte))
+}
+
+// gptImage1Fields are the ImageRequest fields gpt-image-1 accepts.
+const gptImage1Fields = ImageFieldSize | ImageFieldN | ImageFieldQuality | ImageFieldFormat |
+	ImageFieldCompression | ImageFieldBackground | ImageFieldModeration
+
+// gptImage1 keeps the defaults of openai.GenerateGPTImage1 for any setting
+// the request leaves empty.
+func (g *APIGateway) gptImage1(ctx context.Context, req ImageRequest) (*ImageResponse, error) {
+	opts := openAIImageOptions(req)
+	if opts.Size == "" {
+		opts.Size = goopenai.CreateImageSize1024x1024
+	}
+	if opts.Quality == "" {
+		opts.Quality = goopenai.CreateImageQualityHigh
+	}
+	if opts.OutputFormat == "" {
+		opts.OutputFormat = goopenai.CreateImageOutputFormatWEBP
+	}
+	if opts.Moderation == "" {
+		opts.Moderation = goopenai.CreateImageModerationLow
+	}
+	return g.openAIImage(ctx, ProviderGPTImage1, req.Prompt, opts)
+}
+
+func (g *APIGateway) dallE3(ctx context.Context, req ImageRequest) (*ImageResponse, error) {
+	opts := openAIImageOptions(req)
+	if opts.Size == "" {
+		opts.Size = goopenai.CreateImageSize1024x1024
+	}
+	if opts.Quality == "" {
+		opts.Quality = goopenai.CreateImageQualityHD
+	}
+	return g.openAIImage(ctx, ProviderDallE3, req.Prompt, opts)
+}
+
+func (g *APIGateway) openAIImage(ctx context.Context, model, prompt string, opts openai.ImageOptions) (*ImageResponse, error) {
+	if err := opts.Validate(model); err != nil {
+		return nil, err
+	}
+	res, err := g.providers.withOpenAI(ctx, model, func(svc openai.OpenAIService) (any, error) {
+		if ig, ok := svc.(openai.ImageGenerator); ok {
+			return ig.GenerateImage(ctx, model, prompt, opts)
+		}
+		// Services without ImageGenerator only offer the fixed settings of
+		// GenerateGPTImage1 and GenerateDallEImage.
+		if opts.N > 1 {
+			return nil, fmt.Errorf("%w: the OpenAI service returns one image per request", ErrInvalidParameters)
+		}
+		var img []byte
+		var err error
+		if model == ProviderDallE3 {
+			img, err = svc.GenerateDallEImage(ctx, prompt, opts.Size)
+		} else {
+			img, err = svc.GenerateGPTImage1(ctx, prompt)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{img}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytesResponse(OpenAIProvider, model, res.([][]byte)...)
+}
+
+func openAIImageOptions(req ImageRequest) openai.ImageOptions {
+	return openai.ImageOptions{
+		Size:              req.Size,
+		Quality:           req.Quality,
+		OutputFormat:      req.Format,
+		OutputCompression: req.Compression,
+		Background:        req.Background,
+		Moderation:        req.Moderation,
+		Style:             req.Style,
+		N:                 req.N,
+	}
+}
+
+// replicateImageService adapts a Replicate image model. The inputs follow
+// the naming most Replicate image models use.
+func (g *APIGateway) replicateImageService(model string) *imageService {
+	return &imageService{
+		name:     model,
+		supports: ImageFieldNegativePrompt | ImageFieldN | ImageFieldSeed | ImageFieldExtra,
+		fn: func(ctx context.Context, req ImageRequest) (*ImageResponse, error) {
+			input := maps.Clone(req.Extra)
+			if input == nil {
+				input = map[string]any{}
+			}
+			if req.NegativePrompt != "" {
+				input["negative_prompt"] = req.NegativePrompt
+			}
+			if req.Seed != nil {
+				input["seed"] = *req.Seed
+			}
+			if req.N > 1 {
+				input["num_outputs"] = req.N
+			}
+			res, err := g.providers.withReplicate(ctx, model, func(svc replicate.ReplicateService) (any, error) {
+				return runAll(ctx, svc, model, req.Prompt, input)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return urlResponse(ReplicateProvider, model, res.([]string)...)
+		},
+	}
+}
+
+// urlResponse converts the output URLs of a model into an ImageResponse.
+func urlResponse(provider, model string, urls ...string) (*ImageResponse, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s returned no image", model)
+	}
+	res := &ImageResponse{}
+	for _, url := range urls {
+		res.Images = append(res.Images, Artifact{URL: url, Provider: provider, Model: model})
+	}
+	return res, nil
+}
+
+func bytesResponse(provider, model string, images ...[]byte) (*ImageResponse, error) {
+	res := &ImageResponse{}
+	for _, data := range images {
+		if len(data) == 0 {
+			continue
+		}
+		res.Images = append(res.Images, Artifact{
+			Data:     data,
+			MIMEType: mediatype.Detect(data),
+			Provider: provider,
+			Model:    model,
+		})
+	}
+	if len(res.Images) == 0 {
+		return nil, fmt.Errorf("%s returned no image", model)
+	}
+	return res, nil
+}
+
+// classifyError maps provider specific errors onto the gateway errors.
+func classifyError(err error) error {
+	if isRateLimitError(err) && !errors.Is(err, ErrRateLimitExceeded) {
+		return fmt.Errorf("%w: %v", ErrRateLimitExceeded, err)
+	}
+	var oaiErr *goopenai.APIError
+	if errors.As(err, &oaiErr) && oaiErr.Code == "content_policy_violation" {
+		return fmt.Errorf("%w: %v", ErrContentPolicy, err)
+	}
+	if errors.Is(err, openai.ErrInvalidImageOptions) {
+		return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	if errors.Is(err, gemini.ErrSafetyBlocked) {
+		return fmt.Errorf("%w: %v", ErrContentPolicy, err)
+	}
+	return err
+}
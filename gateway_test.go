@@ -0,0 +1,142 @@
+package genailib
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+)
+
+// fakeOpenAIServer answers image generation and edit requests with a tiny
+// PNG. The fields of multipart edit requests are passed to check as strings,
+// with the number of uploaded images under "images".
+func fakeOpenAIServer(t *testing.T, check func(body map[string]any)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("parse form: %v", err)
+			}
+			for k, v := range r.MultipartForm.Value {
+				body[k] = v[0]
+			}
+			body["images"] = len(r.MultipartForm.File["image"]) + len(r.MultipartForm.File["image[]"])
+		} else if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if check != nil {
+			check(body)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": []map[string]any{{"b64_json": base64.StdEncoding.EncodeToString(pngMagic)}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+func TestGatewayTextToImage(t *testing.T) {
+	srv := fakeOpenAIServer(t, func(body map[string]any) {
+		if body["model"] != ProviderDallE3 || body["size"] != "1792x1024" {
+			t.Errorf("unexpected request: %v", body)
+		}
+	})
+	g := NewAPIGateway(WithOpenAIKey("test"), WithOpenAIBaseURL(srv.URL))
+
+	res, err := g.TextToImage(context.Background(), ImageRequest{Prompt: "a cat", Size: "1792x1024"}, ProviderDallE3)
+	if err != nil {
+		t.Fatalf("TextToImage returned error: %v", err)
+	}
+	if len(res.Images) != 1 || res.Images[0].MIMEType != "image/png" || res.Images[0].Model != ProviderDallE3 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	if res.Usage.Requests != 1 || res.Usage.Spend != estimatedPrice(ProviderDallE3) {
+		t.Fatalf("unexpected usage: %+v", res.Usage)
+	}
+}
+
+func TestGatewayRejectsUnsupportedFields(t *testing.T) {
+	g := NewAPIGateway()
+	seed := int64(1)
+	_, err := g.TextToImage(context.Background(), ImageRequest{Prompt: "a cat", Seed: &seed}, ProviderGPTImage1)
+	if !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters, got %v", err)
+	}
+	_, err = g.TextToImage(context.Background(), ImageRequest{}, ProviderDallE3)
+	if !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for empty prompt, got %v", err)
+	}
+
+	// Imagen takes seeds on Vertex AI only.
+	gem := fakeGeminiServer(t)
+	g = NewAPIGateway(WithGeminiKey("test"), WithGeminiBaseURL(gem.URL))
+	if _, err := g.TextToImage(context.Background(), ImageRequest{Prompt: "a cat", Seed: &seed}, ProviderImagen3Generate002); !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("expected ErrInvalidParameters for a seed on the Gemini API, got %v", err)
+	}
+	g = NewAPIGateway(WithGeminiKey("test"), WithGeminiBaseURL(gem.URL), WithVertexAI("", ""))
+	big := int64(math.MaxInt32) + 1
+	for _, req := range []ImageRequest{{Prompt: "a cat", N: 5}, {Prompt: "a cat", Seed: &big}} {
+		if _, err := g.TextToImage(context.Background(), req, ProviderImagen3Generate002); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for Imagen %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestGatewayUnknownService(t *testing.T) {
+	g := NewAPIGateway()
+	_, err := g.TextToImage(context.Background(), ImageRequest{Prompt: "a cat"}, "no-such-model")
+	if !errors.Is(err, ErrNoAPIAvailable) {
+		t.Fatalf("expected ErrNoAPIAvailable, got %v", err)
+	}
+}
+
+func TestGatewayOpenAIOptions(t *testing.T) {
+	srv := fakeOpenAIServer(t, func(body map[string]any) {
+		if body["model"] != ProviderGPTImage1 || body["background"] != "transparent" || body["output_format"] != "png" || body["quality"] != "high" {
+			t.Errorf("unexpected request: %v", body)
+		}
+	})
+	svc := NewImageService(WithOpenAIKey("test"), WithOpenAIBaseURL(srv.URL))
+	ctx := context.Background()
+
+	_, err := svc.Generate(ctx, OpenAIProvider, ProviderGPTImage1, "a logo", map[string]any{"background": "transparent", "output_format": "png"})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if _, err := svc.Generate(ctx, OpenAIProvider, ProviderGPTImage1, "a logo", map[string]any{"quality": "hd"}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for hd gpt-image-1, got %v", err)
+	}
+	if _, err := svc.Generate(ctx, OpenAIProvider, ProviderDallE3, "a logo", map[string]any{"background": "transparent"}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for dall-e-3 background, got %v", err)
+	}
+}
+
+// runOnlyService implements ReplicateService without the optional
+// MultiRunner interface.
+type runOnlyService struct {
+	replicate.ReplicateService
+}
+
+func (runOnlyService) Run(ctx context.Context, model, prompt string, options map[string]any) (any, error) {
+	return "https://example.com/out.png", nil
+}
+
+func TestRunAllFallsBackToRun(t *testing.T) {
+	urls, err := runAll(context.Background(), runOnlyService{}, "owner/model", "a cat", nil)
+	if err != nil {
+		t.Fatalf("runAll returned error: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != "https://example.com/out.png" {
+		t.Errorf("unexpected urls %v", urls)
+	}
+}
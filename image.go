@@ -2,13 +2,15 @@ package genailib
 
 import (
 	"context"
-	"os"
-
-	"github.com/iomodo/gen-ai-lib/external/replicate"
-	"github.com/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 const (
+	OpenAIProvider    = "openai"
+	GeminiProvider    = "gemini"
 	ReplicateProvider = "replicate"
 )
 
@@ -16,50 +18,321 @@ const (
 	ReplicateAPIToken = "REPLICATE_API_TOKEN"
 )
 
+// defaultImageModels is the model used for each provider when none is given.
+var defaultImageModels = map[string]string{
+	OpenAIProvider: ProviderGPTImage1,
+	GeminiProvider: ProviderImagen3Generate002,
+}
+
 // Image defines the interface for generative image models.
+//
+// Options use the same keys for every provider: "negative_prompt", "size",
+// "n", "seed", "style", "quality", "output_format", "output_compression",
+// "background" and "moderation". Values are checked against what the model
+// allows, e.g. "hd" quality only for dall-e-3 and "transparent" backgrounds
+// only for gpt-image-1. For Replicate models any other key is passed to
+// the model unchanged; other providers reject options they do not support
+// with ErrInvalidParameters. Edit additionally accepts "mask", an image whose
+// transparent pixels mark the area to repaint.
 type Image interface {
 	// Generate creates a new image based on the given prompt and options.
-	Generate(provider string, model string, prompt string, options map[string]interface{}) (any, error)
+	Generate(ctx context.Context, provider string, model string, prompt string, options map[string]interface{}) (*ImageResponse, error)
 
 	// Edit modifies an existing image based on the given prompt and options.
-	Edit(provider string, model string, input any, prompt string, options map[string]interface{}) (any, error)
+	// input is an image or a slice of images given as bytes, URLs, data URIs,
+	// ImageInputs or Artifacts. The first image is edited and the rest are
+	// used as references.
+	Edit(ctx context.Context, provider string, model string, input any, prompt string, options map[string]interface{}) (*ImageResponse, error)
+
+	// Upscale enlarges input, an image given like the input of Edit, with a
+	// Replicate model; an empty model selects ProviderRealESRGAN. Options
+	// are "scale" (4 by default) and "face_enhance"; other keys are passed
+	// to the model.
+	Upscale(ctx context.Context, model string, input any, options map[string]interface{}) (*ImageResponse, error)
+
+	// RemoveBackground returns input with a transparent background using a
+	// Replicate model; an empty model selects ProviderRembg. Options are
+	// passed to the model.
+	RemoveBackground(ctx context.Context, model string, input any, options map[string]interface{}) (*ImageResponse, error)
+}
+
+type imageAPI struct {
+	gateway *APIGateway
+}
+
+// NewImageService returns an Image implementation configured by opts.
+func NewImageService(opts ...Option) Image {
+	return &imageAPI{gateway: NewAPIGateway(opts...)}
+}
+
+func (i *imageAPI) Generate(ctx context.Context, provider string, model string, prompt string, options map[string]any) (*ImageResponse, error) {
+	model, err := resolveImageModel(provider, model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := imageRequestFromOptions(prompt, options)
+	if err != nil {
+		return nil, err
+	}
+	return i.gateway.TextToImage(ctx, req, model)
+}
+
+func (i *imageAPI) Edit(ctx context.Context, provider string, model string, input any, prompt string, options map[string]any) (*ImageResponse, error) {
+	model, err := resolveEditModel(provider, model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := editRequestFromOptions(prompt, input, options)
+	if err != nil {
+		return nil, err
+	}
+	return i.gateway.ImageToImage(ctx, req, model)
+}
+
+func (i *imageAPI) Upscale(ctx context.Context, model string, input any, options map[string]any) (*ImageResponse, error) {
+	req, err := upscaleRequestFromOptions(input, options)
+	if err != nil {
+		return nil, err
+	}
+	return i.gateway.Upscale(ctx, req, model)
 }
 
-type image struct {
-	replicateService replicate.ReplicateService
+func (i *imageAPI) RemoveBackground(ctx context.Context, model string, input any, options map[string]any) (*ImageResponse, error) {
+	req, err := backgroundRemovalRequestFromOptions(input, options)
+	if err != nil {
+		return nil, err
+	}
+	return i.gateway.RemoveBackground(ctx, req, model)
 }
 
-func NewImageService() Image {
-	return &image{}
+// resolveEditModel is resolveImageModel for edits; Gemini edits default to
+// the Flash image model since Imagen cannot take an input image.
+func resolveEditModel(provider, model string) (string, error) {
+	if provider == GeminiProvider && model == "" {
+		return ProviderGemini20FlashExpImageGeneration, nil
+	}
+	return resolveImageModel(provider, model)
 }
 
-func (i *image) Generate(provider string, model string, prompt string, options map[string]any) (any, error) {
-	if provider == ReplicateProvider {
-		replicateService, err := i.getReplicateService()
+// resolveImageModel checks that model belongs to provider, defaulting it
+// when empty.
+func resolveImageModel(provider, model string) (string, error) {
+	switch provider {
+	case OpenAIProvider, GeminiProvider:
+		if model == "" {
+			return defaultImageModels[provider], nil
+		}
+		if m, ok := LookupModel(model); !ok || m.Provider != provider {
+			return "", fmt.Errorf("%w: model %s is not a %s model", ErrInvalidParameters, model, provider)
+		}
+		return model, nil
+	case ReplicateProvider:
+		if !strings.Contains(model, "/") {
+			return "", fmt.Errorf("%w: replicate model must be of the form owner/name, got %q", ErrInvalidParameters, model)
+		}
+		return model, nil
+	default:
+		return "", fmt.Errorf("unsupported image provider: %s", provider)
+	}
+}
+
+// imageRequestFromOptions maps the uniform options map onto an ImageRequest.
+// Unrecognised keys are kept in Extra.
+func imageRequestFromOptions(prompt string, options map[string]any) (ImageRequest, error) {
+	req := ImageRequest{Prompt: prompt}
+	for k, v := range options {
+		var err error
+		switch k {
+		case "negative_prompt":
+			req.NegativePrompt, err = optionString(k, v)
+		case "size":
+			req.Size, err = optionString(k, v)
+		case "style":
+			req.Style, err = optionString(k, v)
+		case "quality":
+			req.Quality, err = optionString(k, v)
+		case "output_format":
+			req.Format, err = optionString(k, v)
+		case "background":
+			req.Background, err = optionString(k, v)
+		case "moderation":
+			req.Moderation, err = optionString(k, v)
+		case "output_compression":
+			var c int64
+			c, err = optionInt(k, v)
+			compression := int(c)
+			req.Compression = &compression
+		case "n":
+			var n int64
+			n, err = optionInt(k, v)
+			req.N = int(n)
+		case "seed":
+			var seed int64
+			seed, err = optionInt(k, v)
+			req.Seed = &seed
+		default:
+			if req.Extra == nil {
+				req.Extra = map[string]any{}
+			}
+			req.Extra[k] = v
+		}
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to get replicate service")
+			return ImageRequest{}, err
+		}
+	}
+	return req, nil
+}
+
+// editRequestFromOptions builds an EditRequest. input may be a single image
+// or a slice of images, as bytes, URLs, ImageInputs or Artifacts; the
+// "mask" option accepts the same single values.
+func editRequestFromOptions(prompt string, input any, options map[string]any) (EditRequest, error) {
+	images, err := imageInputs(input)
+	if err != nil {
+		return EditRequest{}, err
+	}
+	req := EditRequest{Prompt: prompt, Images: images}
+	for k, v := range options {
+		switch k {
+		case "mask":
+			var masks []ImageInput
+			if masks, err = imageInputs(v); err == nil && len(masks) != 1 {
+				err = fmt.Errorf("%w: option mask must be a single image", ErrInvalidParameters)
+			}
+			if err == nil {
+				req.Mask = &masks[0]
+			}
+		case "size":
+			req.Size, err = optionString(k, v)
+		case "n":
+			var n int64
+			n, err = optionInt(k, v)
+			req.N = int(n)
+		default:
+			if req.Extra == nil {
+				req.Extra = map[string]any{}
+			}
+			req.Extra[k] = v
 		}
-		output, err := replicateService.Run(context.Background(), model, prompt, options)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to run replicate model")
+			return EditRequest{}, err
 		}
-		return output, nil
 	}
-	return nil, nil
+	return req, nil
 }
 
-func (i *image) Edit(provider string, model string, input any, prompt string, options map[string]any) (any, error) {
-	return nil, nil
+// singleImageInput converts input into exactly one ImageInput.
+func singleImageInput(input any) (ImageInput, error) {
+	images, err := imageInputs(input)
+	if err != nil {
+		return ImageInput{}, err
+	}
+	if len(images) != 1 {
+		return ImageInput{}, fmt.Errorf("%w: expected a single image, got %d", ErrInvalidParameters, len(images))
+	}
+	return images[0], nil
 }
 
-func (i *image) getReplicateService() (replicate.ReplicateService, error) {
-	if i.replicateService == nil || !i.replicateService.IsInitialized() {
-		replicateService, err := replicate.NewReplicateService(os.Getenv(ReplicateAPIToken))
+func upscaleRequestFromOptions(input any, options map[string]any) (UpscaleRequest, error) {
+	img, err := singleImageInput(input)
+	if err != nil {
+		return UpscaleRequest{}, err
+	}
+	req := UpscaleRequest{Image: img}
+	for k, v := range options {
+		switch k {
+		case "scale":
+			var n int64
+			n, err = optionInt(k, v)
+			req.Scale = int(n)
+		case "face_enhance":
+			req.FaceEnhance, err = optionBool(k, v)
+		default:
+			if req.Extra == nil {
+				req.Extra = map[string]any{}
+			}
+			req.Extra[k] = v
+		}
 		if err != nil {
-			return nil, err
+			return UpscaleRequest{}, err
+		}
+	}
+	return req, nil
+}
+
+func backgroundRemovalRequestFromOptions(input any, options map[string]any) (BackgroundRemovalRequest, error) {
+	img, err := singleImageInput(input)
+	if err != nil {
+		return BackgroundRemovalRequest{}, err
+	}
+	return BackgroundRemovalRequest{Image: img, Extra: options}, nil
+}
+
+func optionString(key string, v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: option %s must be a string, got %T", ErrInvalidParameters, key, v)
+	}
+	return s, nil
+}
+
+// optionInt accepts the integer representations produced by Go code and by
+// decoding JSON or YAML.
+func optionInt(key string, v any) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), nil
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+	case string:
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: option %s must be an integer, got %v", ErrInvalidParameters, key, v)
+}
+
+// optionFloat accepts integers as well as floating point numbers.
+func optionFloat(key string, v any) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case json.Number:
+		if f, err := n.Float64(); err == nil {
+			return f, nil
+		}
+	case string:
+		if f, err := strconv.ParseFloat(n, 64); err == nil {
+			return f, nil
+		}
+	default:
+		if i, err := optionInt(key, v); err == nil {
+			return float64(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: option %s must be a number, got %v", ErrInvalidParameters, key, v)
+}
+
+func optionBool(key string, v any) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if parsed, err := strconv.ParseBool(b); err == nil {
+			return parsed, nil
 		}
-		i.replicateService = replicateService
-		return i.replicateService, nil
 	}
-	return i.replicateService, nil
+	return false, fmt.Errorf("%w: option %s must be a boolean, got %v", ErrInvalidParameters, key, v)
 }
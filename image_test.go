@@ -0,0 +1,122 @@
+package genailib
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeGeminiServer answers Imagen predict requests with a tiny PNG.
+func fakeGeminiServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":predict") {
+			t.Errorf("unexpected gemini path %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"predictions": []map[string]any{{
+				"bytesBase64Encoded": base64.StdEncoding.EncodeToString(pngMagic),
+				"mimeType":           "image/png",
+			}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fakeReplicateServer resolves every model to version "v1" and finishes
+// predictions immediately with output. Prediction inputs are passed to check.
+// Uploaded files are served back under /files/upload.
+func fakeReplicateServer(t *testing.T, output any, check func(input map[string]any)) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/files":
+			json.NewEncoder(w).Encode(map[string]any{"urls": map[string]any{"get": srv.URL + "/files/upload"}})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/models/"):
+			json.NewEncoder(w).Encode(map[string]any{"default_version": map[string]any{"id": "v1"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/predictions":
+			var body struct {
+				Input map[string]any `json:"input"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+			if check != nil {
+				check(body.Input)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"id": "p1", "status": "succeeded", "output": output})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestImageGenerateProviders(t *testing.T) {
+	oai := fakeOpenAIServer(t, nil)
+	gem := fakeGeminiServer(t)
+	rep := fakeReplicateServer(t, []string{"https://example.com/a.png"}, func(input map[string]any) {
+		if input["seed"] != float64(3) || input["aspect_ratio"] != "16:9" {
+			t.Errorf("unexpected replicate input %v", input)
+		}
+	})
+	svc := NewImageService(
+		WithOpenAIKey("test"), WithOpenAIBaseURL(oai.URL),
+		WithGeminiKey("test"), WithGeminiBaseURL(gem.URL),
+		WithReplicateToken("test"), WithReplicateBaseURL(rep.URL),
+	)
+	ctx := context.Background()
+
+	res, err := svc.Generate(ctx, OpenAIProvider, "", "a cat", nil)
+	if err != nil {
+		t.Fatalf("openai Generate returned error: %v", err)
+	}
+	if res.Images[0].Model != ProviderGPTImage1 || len(res.Images[0].Data) == 0 {
+		t.Fatalf("unexpected openai response: %+v", res)
+	}
+
+	res, err = svc.Generate(ctx, GeminiProvider, ProviderImagen3Generate002, "a cat", nil)
+	if err != nil {
+		t.Fatalf("gemini Generate returned error: %v", err)
+	}
+	if res.Images[0].MIMEType != "image/png" {
+		t.Fatalf("unexpected gemini response: %+v", res)
+	}
+
+	res, err = svc.Generate(ctx, ReplicateProvider, "owner/model", "a cat", map[string]any{"seed": 3, "aspect_ratio": "16:9"})
+	if err != nil {
+		t.Fatalf("replicate Generate returned error: %v", err)
+	}
+	if res.Images[0].URL != "https://example.com/a.png" {
+		t.Fatalf("unexpected replicate response: %+v", res)
+	}
+}
+
+func TestImageGenerateErrors(t *testing.T) {
+	svc := NewImageService()
+	ctx := context.Background()
+
+	if _, err := svc.Generate(ctx, "unknown", "", "a cat", nil); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+	if _, err := svc.Generate(ctx, OpenAIProvider, ProviderImagen3Generate002, "a cat", nil); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for mismatched model, got %v", err)
+	}
+	if _, err := svc.Generate(ctx, GeminiProvider, "", "a cat", map[string]any{"aspect_ratio": "16:9"}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for unsupported option, got %v", err)
+	}
+	if _, err := svc.Generate(ctx, OpenAIProvider, ProviderDallE3, "a cat", map[string]any{"n": "two"}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for bad option type, got %v", err)
+	}
+	if _, err := svc.Generate(ctx, OpenAIProvider, ProviderDallE3, "a cat", map[string]any{"n": json.Number("1.5")}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for a fractional json.Number, got %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+package genailib
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// ImageFormat is an encoded image format.
+type ImageFormat string
+
+// Supported image formats.
+const (
+	FormatPNG  ImageFormat = "png"
+	FormatJPEG ImageFormat = "jpeg"
+	FormatWEBP ImageFormat = "webp"
+)
+
+// MIMEType returns the MIME type of f.
+func (f ImageFormat) MIMEType() string {
+	return "image/" + string(f)
+}
+
+// ResampleFilter selects the interpolation used when resizing.
+type ResampleFilter string
+
+// Resampling filters, from fastest to best quality.
+const (
+	FilterNearest    ResampleFilter = "nearest"
+	FilterBilinear   ResampleFilter = "bilinear"
+	FilterCatmullRom ResampleFilter = "catmull-rom"
+)
+
+func (f ResampleFilter) scaler() (draw.Scaler, error) {
+	switch f {
+	case FilterNearest:
+		return draw.NearestNeighbor, nil
+	case FilterBilinear:
+		return draw.BiLinear, nil
+	case FilterCatmullRom, "":
+		return draw.CatmullRom, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown resample filter %q", ErrInvalidParameters, f)
+	}
+}
+
+// CropAnchor selects which part of an image CropToAspect keeps.
+type CropAnchor string
+
+// Crop anchors. AnchorSmart keeps the window with the most detail, which
+// usually holds the subject, instead of the centre.
+const (
+	AnchorCenter CropAnchor = "center"
+	AnchorSmart  CropAnchor = "smart"
+)
+
+// DefaultJPEGQuality is used when encoding JPEG without a quality.
+const DefaultJPEGQuality = 90
+
+// DecodeImage decodes a PNG, JPEG or WEBP image.
+func DecodeImage(data []byte) (image.Image, ImageFormat, error) {
+	var (
+		img image.Image
+		err error
+	)
+	format := sniffImageFormat(data)
+	switch format {
+	case FormatPNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case FormatJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case FormatWEBP:
+		img, err = webp.Decode(bytes.NewReader(data))
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported image format", ErrInvalidParameters)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s: %w", format, err)
+	}
+	return img, format, nil
+}
+
+func sniffImageFormat(data []byte) ImageFormat {
+	switch mediatype.Detect(data) {
+	case mediatype.PNG:
+		return FormatPNG
+	case mediatype.JPEG:
+		return FormatJPEG
+	case mediatype.WEBP:
+		return FormatWEBP
+	}
+	return ""
+}
+
+// EncodeImage encodes img in format. quality applies to JPEG only and
+// defaults to DefaultJPEGQuality. WEBP images are encoded losslessly but
+// without compression, so they are typically several times larger than
+// the same image as PNG.
+func EncodeImage(img image.Image, format ImageFormat, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatJPEG:
+		if quality <= 0 {
+			quality = DefaultJPEGQuality
+		}
+		err = jpeg.Encode(&buf, flatten(img, color.White), &jpeg.Options{Quality: min(quality, 100)})
+	case FormatWEBP:
+		err = encodeWEBP(&buf, img)
+	default:
+		return nil, fmt.Errorf("%w: unsupported image format %q", ErrInvalidParameters, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ConvertImage re-encodes an image in another format.
+func ConvertImage(data []byte, format ImageFormat, quality int) ([]byte, error) {
+	img, _, err := DecodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeImage(img, format, quality)
+}
+
+// ResizeImage scales img to width x height. When one dimension is zero it is
+// derived from the other to keep the aspect ratio.
+func ResizeImage(img image.Image, width, height int, filter ResampleFilter) (image.Image, error) {
+	b := img.Bounds()
+	switch {
+	case width <= 0 && height <= 0:
+		return nil, fmt.Errorf("%w: width or height is required", ErrInvalidParameters)
+	case width <= 0:
+		width = max(1, b.Dx()*height/b.Dy())
+	case height <= 0:
+		height = max(1, b.Dy()*width/b.Dx())
+	}
+	scaler, err := filter.scaler()
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst, nil
+}
+
+// ThumbnailImage shrinks img so that its longer side is at most size,
+// keeping the aspect ratio. Smaller images are returned unchanged.
+func ThumbnailImage(img image.Image, size int, filter ResampleFilter) (image.Image, error) {
+	b := img.Bounds()
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: thumbnail size must be positive", ErrInvalidParameters)
+	}
+	if b.Dx() <= size && b.Dy() <= size {
+		return img, nil
+	}
+	if b.Dx() >= b.Dy() {
+		return ResizeImage(img, size, 0, filter)
+	}
+	return ResizeImage(img, 0, size, filter)
+}
+
+// CropToAspect crops img to the aspect ratio w:h, keeping the part selected
+// by anchor.
+func CropToAspect(img image.Image, w, h int, anchor CropAnchor) (image.Image, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("%w: invalid aspect ratio %d:%d", ErrInvalidParameters, w, h)
+	}
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dx()*h/w
+	if ch > b.Dy() {
+		cw, ch = b.Dy()*w/h, b.Dy()
+	}
+	cw, ch = max(cw, 1), max(ch, 1)
+
+	var offset int
+	switch anchor {
+	case AnchorCenter, "":
+		if cw < b.Dx() {
+			offset = (b.Dx() - cw) / 2
+		} else {
+			offset = (b.Dy() - ch) / 2
+		}
+	case AnchorSmart:
+		offset = smartCropOffset(img, cw, ch)
+	default:
+		return nil, fmt.Errorf("%w: unknown crop anchor %q", ErrInvalidParameters, anchor)
+	}
+
+	rect := image.Rect(b.Min.X, b.Min.Y+offset, b.Min.X+cw, b.Min.Y+offset+ch)
+	if cw < b.Dx() {
+		rect = image.Rect(b.Min.X+offset, b.Min.Y, b.Min.X+offset+cw, b.Min.Y+ch)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst, nil
+}
+
+// smartCropOffset returns the offset along the cropped axis of the cw x ch
+// window holding the most edge energy.
+func smartCropOffset(img image.Image, cw, ch int) int {
+	b := img.Bounds()
+	horizontal := cw < b.Dx()
+	length, window := b.Dy(), ch
+	if horizontal {
+		length, window = b.Dx(), cw
+	}
+	if window >= length {
+		return 0
+	}
+
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+	energy := make([]int, length)
+	for y := b.Min.Y; y < b.Max.Y-1; y++ {
+		for x := b.Min.X; x < b.Max.X-1; x++ {
+			p := int(gray.GrayAt(x, y).Y)
+			e := abs(p-int(gray.GrayAt(x+1, y).Y)) + abs(p-int(gray.GrayAt(x, y+1).Y))
+			if horizontal {
+				energy[x-b.Min.X] += e
+			} else {
+				energy[y-b.Min.Y] += e
+			}
+		}
+	}
+
+	sum := 0
+	for i := 0; i < window; i++ {
+		sum += energy[i]
+	}
+	best, bestSum := 0, sum
+	for i := window; i < length; i++ {
+		sum += energy[i] - energy[i-window]
+		if sum > bestSum {
+			best, bestSum = i-window+1, sum
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// PadToAspect letterboxes img to the aspect ratio w:h, centring it on a
+// background of bg. A nil bg leaves the padding transparent.
+func PadToAspect(img image.Image, w, h int, bg color.Color) (image.Image, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("%w: invalid aspect ratio %d:%d", ErrInvalidParameters, w, h)
+	}
+	b := img.Bounds()
+	pw, ph := b.Dx(), b.Dx()*h/w
+	if ph < b.Dy() {
+		pw, ph = b.Dy()*w/h, b.Dy()
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, pw, ph))
+	if bg != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+	at := image.Pt((pw-b.Dx())/2, (ph-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(at), img, b.Min, draw.Over)
+	return dst, nil
+}
+
+// flatten composites img onto bg, for formats without transparency.
+func flatten(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+// ImageFit controls how an image is brought to a new aspect ratio.
+type ImageFit string
+
+// Fit modes. FitCover crops, FitContain letterboxes and FitFill stretches.
+const (
+	FitCover   ImageFit = "cover"
+	FitContain ImageFit = "contain"
+	FitFill    ImageFit = "fill"
+)
+
+// ImageOps describes a post-processing pipeline, applied in field order:
+// the image is brought to AspectRatio (or to Width:Height when both are
+// set) according to Fit, resized, thumbnailed and encoded.
+type ImageOps struct {
+	// AspectRatio is a "W:H" ratio such as "16:9".
+	AspectRatio string
+	// Fit defaults to FitCover.
+	Fit    ImageFit
+	Anchor CropAnchor
+	// Background fills letterbox padding; nil leaves it transparent.
+	Background color.Color
+	Width      int
+	Height     int
+	Filter     ResampleFilter
+	// Thumbnail limits the longer side of the result.
+	Thumbnail int
+	// Format defaults to the input format, except that WEBP input is
+	// written as PNG: EncodeImage stores WEBP uncompressed, several times
+	// larger than PNG. Set FormatWEBP to keep WEBP anyway.
+	Format  ImageFormat
+	Quality int
+}
+
+// ProcessImage decodes data, applies ops and encodes the result.
+func ProcessImage(data []byte, ops ImageOps) ([]byte, ImageFormat, error) {
+	img, format, err := DecodeImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if img, err = ops.apply(img); err != nil {
+		return nil, "", err
+	}
+	switch {
+	case ops.Format != "":
+		format = ops.Format
+	case format == FormatWEBP:
+		format = FormatPNG
+	}
+	out, err := EncodeImage(img, format, ops.Quality)
+	return out, format, err
+}
+
+func (ops ImageOps) apply(img image.Image) (image.Image, error) {
+	var (
+		aw, ah int
+		err    error
+	)
+	switch {
+	case ops.Width > 0 && ops.Height > 0:
+		aw, ah = ops.Width, ops.Height
+	case ops.AspectRatio != "":
+		if aw, ah, err = parseAspectRatio(ops.AspectRatio); err != nil {
+			return nil, err
+		}
+	}
+	if aw > 0 {
+		switch ops.Fit {
+		case FitCover, "":
+			img, err = CropToAspect(img, aw, ah, ops.Anchor)
+		case FitContain:
+			img, err = PadToAspect(img, aw, ah, ops.Background)
+		case FitFill:
+			// The resize below stretches to Width and Height, so derive the
+			// missing one from the ratio rather than keep the source aspect.
+			switch {
+			case ops.Width == 0 && ops.Height == 0:
+				b := img.Bounds()
+				img, err = ResizeImage(img, b.Dx(), b.Dx()*ah/aw, ops.Filter)
+			case ops.Height == 0:
+				ops.Height = max(ops.Width*ah/aw, 1)
+			case ops.Width == 0:
+				ops.Width = max(ops.Height*aw/ah, 1)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown fit %q", ErrInvalidParameters, ops.Fit)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ops.Width > 0 || ops.Height > 0 {
+		if img, err = ResizeImage(img, ops.Width, ops.Height, ops.Filter); err != nil {
+			return nil, err
+		}
+	}
+	if ops.Thumbnail > 0 {
+		if img, err = ThumbnailImage(img, ops.Thumbnail, ops.Filter); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// parseAspectRatio parses a "W:H" ratio.
+func parseAspectRatio(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(s, ":")
+	w, werr := strconv.Atoi(ws)
+	h, herr := strconv.Atoi(hs)
+	if !ok || werr != nil || herr != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("%w: invalid aspect ratio %q", ErrInvalidParameters, s)
+	}
+	return w, h, nil
+}
+
+// parseColor parses "#rrggbb", "#rrggbbaa" or "transparent".
+func parseColor(s string) (color.Color, error) {
+	if s == "transparent" {
+		return color.Transparent, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || (len(hex) != 6 && len(hex) != 8) {
+		return nil, fmt.Errorf("%w: invalid color %q", ErrInvalidParameters, s)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// imageOpsFromOptions maps workflow step options onto ImageOps. The keys are
+// the snake_case field names, e.g. "aspect_ratio" and "thumbnail".
+func imageOpsFromOptions(options map[string]any) (ImageOps, error) {
+	var ops ImageOps
+	for k, v := range options {
+		var (
+			s   string
+			n   int64
+			err error
+		)
+		switch k {
+		case "aspect_ratio", "fit", "anchor", "background", "filter", "format":
+			s, err = optionString(k, v)
+		case "width", "height", "thumbnail", "quality":
+			n, err = optionInt(k, v)
+		default:
+			err = fmt.Errorf("%w: unknown image option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return ImageOps{}, err
+		}
+		switch k {
+		case "aspect_ratio":
+			ops.AspectRatio = s
+		case "fit":
+			ops.Fit = ImageFit(s)
+		case "anchor":
+			ops.Anchor = CropAnchor(s)
+		case "background":
+			if ops.Background, err = parseColor(s); err != nil {
+				return ImageOps{}, err
+			}
+		case "filter":
+			ops.Filter = ResampleFilter(s)
+		case "format":
+			ops.Format = ImageFormat(strings.ToLower(s))
+			if ops.Format == "jpg" {
+				ops.Format = FormatJPEG
+			}
+		case "width":
+			ops.Width = int(n)
+		case "height":
+			ops.Height = int(n)
+		case "thumbnail":
+			ops.Thumbnail = int(n)
+		case "quality":
+			ops.Quality = int(n)
+		}
+	}
+	return ops, nil
+}
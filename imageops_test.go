@@ -0,0 +1,130 @@
+package genailib
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeDecodeFormats(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	for y := range 5 {
+		for x := range 7 {
+			src.Set(x, y, color.NRGBA{R: uint8(x * 30), G: uint8(y * 50), B: 9, A: uint8(128 + x)})
+		}
+	}
+	for _, format := range []ImageFormat{FormatPNG, FormatJPEG, FormatWEBP} {
+		data, err := EncodeImage(src, format, 0)
+		if err != nil {
+			t.Fatalf("EncodeImage(%s) returned error: %v", format, err)
+		}
+		img, got, err := DecodeImage(data)
+		if err != nil {
+			t.Fatalf("DecodeImage(%s) returned error: %v", format, err)
+		}
+		if got != format || img.Bounds().Dx() != 7 || img.Bounds().Dy() != 5 {
+			t.Fatalf("%s: decoded %s image of %v", format, got, img.Bounds())
+		}
+		if format == FormatJPEG {
+			continue
+		}
+		if c := color.NRGBAModel.Convert(img.At(3, 2)); c != src.At(3, 2) {
+			t.Fatalf("%s is not lossless: got %v, want %v", format, c, src.At(3, 2))
+		}
+	}
+	if _, _, err := DecodeImage([]byte("GIF89a")); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func TestCropToAspect(t *testing.T) {
+	// A flat image with a detailed stripe near the right edge.
+	src := image.NewGray(image.Rect(0, 0, 100, 50))
+	for y := range 50 {
+		for x := 80; x < 95; x++ {
+			src.SetGray(x, y, color.Gray{Y: uint8((x + y) % 2 * 255)})
+		}
+	}
+	img, err := CropToAspect(src, 1, 1, AnchorCenter)
+	if err != nil {
+		t.Fatalf("CropToAspect returned error: %v", err)
+	}
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 50 {
+		t.Fatalf("unexpected size %v", img.Bounds())
+	}
+	if offset := smartCropOffset(src, 50, 50); offset < 45 {
+		t.Fatalf("smart crop missed the detailed area, offset %d", offset)
+	}
+	if _, err := CropToAspect(src, 0, 1, AnchorCenter); err == nil {
+		t.Fatal("expected error for invalid ratio")
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	data := testPNG(t, 200, 100, color.RGBA{R: 255, A: 255})
+	cases := []struct {
+		name          string
+		ops           ImageOps
+		width, height int
+		format        ImageFormat
+	}{
+		{"resize keeps aspect", ImageOps{Width: 50}, 50, 25, FormatPNG},
+		{"cover", ImageOps{Width: 60, Height: 60}, 60, 60, FormatPNG},
+		{"letterbox", ImageOps{AspectRatio: "1:1", Fit: FitContain, Background: color.Black}, 200, 200, FormatPNG},
+		{"fill", ImageOps{AspectRatio: "1:1", Fit: FitFill}, 200, 200, FormatPNG},
+		{"fill to width", ImageOps{AspectRatio: "1:1", Fit: FitFill, Width: 50}, 50, 50, FormatPNG},
+		{"fill to height", ImageOps{AspectRatio: "16:9", Fit: FitFill, Height: 90}, 160, 90, FormatPNG},
+		{"thumbnail to jpeg", ImageOps{Thumbnail: 64, Format: FormatJPEG, Quality: 70}, 64, 32, FormatJPEG},
+		{"webp", ImageOps{AspectRatio: "16:9", Format: FormatWEBP}, 177, 100, FormatWEBP},
+	}
+	for _, c := range cases {
+		out, format, err := ProcessImage(data, c.ops)
+		if err != nil {
+			t.Fatalf("%s: ProcessImage returned error: %v", c.name, err)
+		}
+		img, got, err := DecodeImage(out)
+		if err != nil {
+			t.Fatalf("%s: decode result: %v", c.name, err)
+		}
+		if format != c.format || got != c.format || img.Bounds().Dx() != c.width || img.Bounds().Dy() != c.height {
+			t.Fatalf("%s: got %s %v, want %s %dx%d", c.name, got, img.Bounds(), c.format, c.width, c.height)
+		}
+	}
+	webpData, err := ConvertImage(data, FormatWEBP, 0)
+	if err != nil {
+		t.Fatalf("ConvertImage returned error: %v", err)
+	}
+	if _, format, err := ProcessImage(webpData, ImageOps{Width: 50}); err != nil || format != FormatPNG {
+		t.Fatalf("expected WEBP input to default to PNG, got %s: %v", format, err)
+	}
+	if _, format, err := ProcessImage(webpData, ImageOps{Width: 50, Format: FormatWEBP}); err != nil || format != FormatWEBP {
+		t.Fatalf("expected explicit WEBP output, got %s: %v", format, err)
+	}
+	if _, _, err := ProcessImage(data, ImageOps{AspectRatio: "wide"}); err == nil {
+		t.Fatal("expected error for invalid aspect ratio")
+	}
+}
+
+func TestWorkflowProcessImage(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "thumb",
+		FunctionType: FunctionTypeProcessImage,
+		Image:        "photo",
+		Options:      map[string]any{"thumbnail": 16, "format": "jpg", "background": "#ffffff"},
+	}}}
+	result, _, err := svc.Generate(context.Background(), wf, map[string]any{"photo": testPNG(t, 64, 32, color.White)})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	img, ok := result.(*Artifact)
+	if !ok || img.MIMEType != "image/jpeg" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	wf.Steps[0].Options = map[string]any{"rotate": 90}
+	if _, _, err := svc.Generate(context.Background(), wf, map[string]any{"photo": testPNG(t, 8, 8, color.White)}); err == nil {
+		t.Fatal("expected error for unknown option")
+	}
+}
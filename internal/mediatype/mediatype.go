@@ -0,0 +1,161 @@
+// Package mediatype identifies image, video and audio content from its
+// leading bytes and converts images to formats a provider accepts.
+package mediatype
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Media types recognised by Detect.
+const (
+	PNG       = "image/png"
+	JPEG      = "image/jpeg"
+	WEBP      = "image/webp"
+	GIF       = "image/gif"
+	HEIC      = "image/heic"
+	AVIF      = "image/avif"
+	MP4       = "video/mp4"
+	QuickTime = "video/quicktime"
+	WebM      = "video/webm"
+	MP3       = "audio/mpeg"
+	WAV       = "audio/wav"
+
+	// Unknown is returned for content that is not recognised.
+	Unknown = "application/octet-stream"
+)
+
+// Detect returns the media type of data from its magic bytes. Types not
+// listed above fall back to http.DetectContentType without parameters.
+func Detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return JPEG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return GIF
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return WEBP
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return WAV
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return isoBrand(data)
+	case bytes.HasPrefix(data, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return WebM
+	case bytes.HasPrefix(data, []byte("ID3")), len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		return MP3
+	}
+	if len(data) == 0 {
+		return Unknown
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return Unknown
+	}
+	return mediaType
+}
+
+// isoBrand tells apart the ISO base media files sharing the ftyp box by
+// their major brand.
+func isoBrand(data []byte) string {
+	if len(data) < 12 {
+		return MP4
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "mif1":
+		return HEIC
+	case "avif":
+		return AVIF
+	case "qt  ":
+		return QuickTime
+	}
+	return MP4
+}
+
+// IsImage reports whether mediaType is an image type.
+func IsImage(mediaType string) bool { return strings.HasPrefix(mediaType, "image/") }
+
+// IsVideo reports whether mediaType is a video type.
+func IsVideo(mediaType string) bool { return strings.HasPrefix(mediaType, "video/") }
+
+// IsAudio reports whether mediaType is an audio type.
+func IsAudio(mediaType string) bool { return strings.HasPrefix(mediaType, "audio/") }
+
+// Extension returns the usual file extension of mediaType, including the
+// dot, or "" for unknown types.
+func Extension(mediaType string) string {
+	switch mediaType {
+	case PNG:
+		return ".png"
+	case JPEG:
+		return ".jpg"
+	case WEBP:
+		return ".webp"
+	case GIF:
+		return ".gif"
+	case HEIC:
+		return ".heic"
+	case AVIF:
+		return ".avif"
+	case MP4:
+		return ".mp4"
+	case QuickTime:
+		return ".mov"
+	case WebM:
+		return ".webm"
+	case MP3:
+		return ".mp3"
+	case WAV:
+		return ".wav"
+	}
+	return ""
+}
+
+// Convert returns data unchanged with its media type when the type is one of
+// accepted. Other images are re-encoded as PNG, or as JPEG when PNG is not
+// accepted. Content that is not a decodable image is rejected.
+func Convert(data []byte, accepted ...string) ([]byte, string, error) {
+	mediaType := Detect(data)
+	if slices.Contains(accepted, mediaType) {
+		return data, mediaType, nil
+	}
+	if !IsImage(mediaType) {
+		return nil, "", fmt.Errorf("unsupported media type %s, want one of %s", mediaType, strings.Join(accepted, ", "))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s: %w", mediaType, err)
+	}
+	var buf bytes.Buffer
+	switch {
+	case slices.Contains(accepted, PNG):
+		err = png.Encode(&buf, img)
+		mediaType = PNG
+	case slices.Contains(accepted, JPEG):
+		// JPEG has no alpha channel, so transparent areas become white.
+		rgba := image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buf, rgba, nil)
+		mediaType = JPEG
+	default:
+		return nil, "", fmt.Errorf("cannot convert %s to any of %s", mediaType, strings.Join(accepted, ", "))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("encode %s: %w", mediaType, err)
+	}
+	return buf.Bytes(), mediaType, nil
+}
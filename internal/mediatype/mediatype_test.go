@@ -0,0 +1,92 @@
+package mediatype
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), PNG},
+		{"jpeg", []byte{0xff, 0xd8, 0xff, 0xe0}, JPEG},
+		{"gif", []byte("GIF89a\x01\x00"), GIF},
+		{"webp", []byte("RIFF\x10\x00\x00\x00WEBPVP8L"), WEBP},
+		{"wav", []byte("RIFF\x10\x00\x00\x00WAVEfmt "), WAV},
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom"), MP4},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic"), HEIC},
+		{"heif", []byte("\x00\x00\x00\x18ftypmif1"), HEIC},
+		{"avif", []byte("\x00\x00\x00\x1cftypavif"), AVIF},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  "), QuickTime},
+		{"webm", []byte{0x1a, 0x45, 0xdf, 0xa3, 0x9f}, WebM},
+		{"mp3 id3", []byte("ID3\x04\x00"), MP3},
+		{"mp3 frame", []byte{0xff, 0xfb, 0x90, 0x00}, MP3},
+		{"text", []byte("hello"), "text/plain"},
+		{"empty", nil, Unknown},
+	}
+	for _, c := range cases {
+		if got := Detect(c.data); got != c.want {
+			t.Errorf("%s: Detect=%q want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	cases := []struct {
+		mediaType string
+		want      string
+	}{
+		{JPEG, ".jpg"},
+		{PNG, ".png"},
+		{WEBP, ".webp"},
+		{MP4, ".mp4"},
+		{QuickTime, ".mov"},
+		{"application/json", ""},
+	}
+	for _, c := range cases {
+		if got := Extension(c.mediaType); got != c.want {
+			t.Errorf("Extension(%q)=%q want %q", c.mediaType, got, c.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 255})
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+
+	out, mediaType, err := Convert(pngData.Bytes(), PNG, JPEG)
+	if err != nil || mediaType != PNG || !bytes.Equal(out, pngData.Bytes()) {
+		t.Fatalf("expected accepted input unchanged, got %s: %v", mediaType, err)
+	}
+	out, mediaType, err = Convert(pngData.Bytes(), JPEG)
+	if err != nil || mediaType != JPEG || Detect(out) != JPEG {
+		t.Fatalf("expected a JPEG, got %s: %v", mediaType, err)
+	}
+
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	out, mediaType, err = Convert(gifData.Bytes(), PNG, JPEG)
+	if err != nil || mediaType != PNG || Detect(out) != PNG {
+		t.Fatalf("expected a PNG, got %s: %v", mediaType, err)
+	}
+
+	if _, _, err := Convert([]byte("\x00\x00\x00\x20ftypisom"), PNG); err == nil {
+		t.Fatal("expected error for a video")
+	}
+	if _, _, err := Convert(pngData.Bytes(), WEBP); err == nil {
+		t.Fatal("expected error without a PNG or JPEG target")
+	}
+}
@@ -0,0 +1,188 @@
+package genailib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Clips are normalized to this audio layout when any of them has sound.
+const (
+	normalizedSampleRate = 48000
+	normalizedChannels   = 2
+)
+
+// clipInfo describes the streams of a clip. Except for Duration, all fields
+// must match for the concat demuxer to join clips without re-encoding.
+type clipInfo struct {
+	// Duration is the length of the video stream in seconds, which places
+	// the transitions, or of the container when it is not reported.
+	Duration float64
+
+	VideoCodec string
+	Width      int
+	Height     int
+	FrameRate  string
+	PixFmt     string
+
+	HasAudio   bool
+	AudioCodec string
+	SampleRate int
+	Channels   int
+}
+
+// probeClip probes the video at path. name describes the clip in errors.
+func (j *job) probeClip(path, name string) (clipInfo, error) {
+	info, err := j.probe(path, name, true, false)
+	if err != nil {
+		return clipInfo{}, err
+	}
+	duration := info.Duration
+	if info.Video.Duration > 0 {
+		duration = info.Video.Duration
+	}
+	clip := clipInfo{
+		Duration:   duration.Seconds(),
+		VideoCodec: info.Video.Codec,
+		Width:      info.Video.Width,
+		Height:     info.Video.Height,
+		FrameRate:  strconv.FormatFloat(info.Video.FrameRate, 'f', -1, 64),
+		PixFmt:     info.Video.PixelFormat,
+	}
+	if info.Audio != nil {
+		clip.HasAudio = true
+		clip.AudioCodec = info.Audio.Codec
+		clip.SampleRate = info.Audio.SampleRate
+		clip.Channels = info.Audio.Channels
+	}
+	return clip, nil
+}
+
+// clipsCompatible reports whether clips can be concatenated as they are.
+func clipsCompatible(clips []clipInfo) bool {
+	first := clips[0]
+	first.Duration = 0
+	for _, c := range clips[1:] {
+		c.Duration = 0
+		if c != first {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFrameRate parses an ffprobe frame rate such as "30000/1001".
+func parseFrameRate(rate string) (float64, error) {
+	num, den, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid frame rate %q", rate)
+	}
+	if !ok {
+		return n, nil
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0, errors.Errorf("invalid frame rate %q", rate)
+	}
+	return n / d, nil
+}
+
+// normalizeTarget picks the common format for clips: the resolution and
+// frame rate of the first clip, yuv420p, and stereo audio if any clip has
+// sound.
+func normalizeTarget(clips []clipInfo) clipInfo {
+	target := clipInfo{
+		VideoCodec: "h264",
+		Width:      clips[0].Width,
+		Height:     clips[0].Height,
+		FrameRate:  clips[0].FrameRate,
+		PixFmt:     "yuv420p",
+	}
+	if fps, err := parseFrameRate(target.FrameRate); err != nil || fps <= 0 {
+		target.FrameRate = "30"
+	}
+	for _, c := range clips {
+		if c.HasAudio {
+			target.HasAudio = true
+			target.AudioCodec = "aac"
+			target.SampleRate = normalizedSampleRate
+			target.Channels = normalizedChannels
+			break
+		}
+	}
+	return target
+}
+
+// normalizeArgs returns the ffmpeg arguments that re-encode the clip at in,
+// described by src, to target. The picture is scaled to fit and padded with
+// black, and silence is added when the clip has no audio but target does.
+func normalizeArgs(in, out string, src, target clipInfo) []string {
+	args := []string{"-i", in}
+	if target.HasAudio && !src.HasAudio {
+		args = append(args,
+			"-f", "lavfi",
+			"-i", fmt.Sprintf("anullsrc=channel_layout=stereo:sample_rate=%d", target.SampleRate),
+		)
+	}
+	filter := fmt.Sprintf(
+		"scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%[3]s,format=%[4]s",
+		target.Width, target.Height, target.FrameRate, target.PixFmt,
+	)
+	args = append(args,
+		"-vf", filter,
+		"-map", "0:v:0",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "18",
+		"-video_track_timescale", "90000",
+	)
+	if target.HasAudio {
+		audio := "0:a:0"
+		if !src.HasAudio {
+			audio = "1:a:0"
+		}
+		args = append(args,
+			"-map", audio,
+			"-c:a", "aac",
+			"-ar", strconv.Itoa(target.SampleRate),
+			"-ac", strconv.Itoa(target.Channels),
+			"-shortest",
+		)
+	}
+	return append(args, "-y", out)
+}
+
+// normalizeClips probes the clips at paths and, when they differ in codec,
+// resolution, frame rate, pixel format or audio layout or when force is set,
+// re-encodes them into the job's directory to a common format. It returns
+// the paths to join and the clips as probed after any re-encoding, whose
+// lengths can differ from the originals.
+func (j *job) normalizeClips(paths []string, force bool) ([]string, []clipInfo, error) {
+	clips := make([]clipInfo, len(paths))
+	for i, path := range paths {
+		info, err := j.probeClip(path, fmt.Sprintf("video %d", i))
+		if err != nil {
+			return nil, nil, err
+		}
+		clips[i] = info
+	}
+	if !force && clipsCompatible(clips) {
+		return paths, clips, nil
+	}
+
+	target := normalizeTarget(clips)
+	out := make([]string, len(paths))
+	for i, path := range paths {
+		out[i] = j.path(fmt.Sprintf("normalized%d.mp4", i))
+		if err := j.run(normalizeArgs(path, out[i], clips[i], target)...); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to normalize video %d", i)
+		}
+		info, err := j.probeClip(out[i], fmt.Sprintf("normalized video %d", i))
+		if err != nil {
+			return nil, nil, err
+		}
+		clips[i] = info
+	}
+	return out, clips, nil
+}
@@ -0,0 +1,164 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MediaInfo describes a media file as reported by ffprobe.
+type MediaInfo struct {
+	// Format lists the names of the container format, such as
+	// "mov,mp4,m4a,3gp,3g2,mj2".
+	Format   string        `json:"format"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size"`
+	BitRate  int64         `json:"bit_rate,omitempty"`
+	// Video and Audio describe the first stream of each kind and are nil
+	// when the file has none. Cover art is not reported as video.
+	Video *VideoStream `json:"video,omitempty"`
+	Audio *AudioStream `json:"audio,omitempty"`
+}
+
+// VideoStream describes a video stream.
+type VideoStream struct {
+	Codec       string  `json:"codec"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	FrameRate   float64 `json:"frame_rate,omitempty"`
+	PixelFormat string  `json:"pixel_format,omitempty"`
+	BitRate     int64   `json:"bit_rate,omitempty"`
+	// Duration is the length of the stream itself, which may differ from
+	// that of the container. Zero when ffprobe does not report it.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// AudioStream describes an audio stream.
+type AudioStream struct {
+	Codec         string `json:"codec"`
+	SampleRate    int    `json:"sample_rate"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	BitRate       int64  `json:"bit_rate,omitempty"`
+}
+
+// ProbeMedia inspects a video, audio or image file with ffprobe, which must
+// be installed and accessible on the system PATH.
+func ProbeMedia(data []byte) (*MediaInfo, error) {
+	return ProbeMediaReader(bytes.NewReader(data))
+}
+
+// ProbeMediaReader is ProbeMedia for content read from r.
+func ProbeMediaReader(r io.Reader) (*MediaInfo, error) {
+	f, err := os.CreateTemp("", "probe-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp file")
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write media")
+	}
+	return ProbeMediaFile(f.Name())
+}
+
+// ProbeMediaFile is ProbeMedia for the file at path.
+func ProbeMediaFile(path string) (*MediaInfo, error) {
+	return defaultFFmpeg.Probe(context.Background(), path)
+}
+
+// probeBytes is Probe for media held in memory, written to a working file
+// within the limits of f.
+func (f *FFmpeg) probeBytes(ctx context.Context, data []byte) (*MediaInfo, error) {
+	j, err := f.newJob(ctx, "probe")
+	if err != nil {
+		return nil, err
+	}
+	defer j.close()
+	path, err := j.write("media", data)
+	if err != nil {
+		return nil, err
+	}
+	return f.Probe(ctx, path)
+}
+
+// parseProbe parses the JSON output of ffprobe -show_streams -show_format.
+func parseProbe(out []byte) (*MediaInfo, error) {
+	var probe struct {
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			CodecName     string `json:"codec_name"`
+			Width         int    `json:"width"`
+			Height        int    `json:"height"`
+			PixFmt        string `json:"pix_fmt"`
+			FrameRate     string `json:"r_frame_rate"`
+			SampleRate    string `json:"sample_rate"`
+			Channels      int    `json:"channels"`
+			ChannelLayout string `json:"channel_layout"`
+			BitRate       string `json:"bit_rate"`
+			Duration      string `json:"duration"`
+			Disposition   struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			Size       string `json:"size"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ffprobe output")
+	}
+
+	info := &MediaInfo{Format: probe.Format.FormatName, Duration: parseSeconds(probe.Format.Duration)}
+	info.Size, _ = strconv.ParseInt(probe.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	for _, s := range probe.Streams {
+		bitRate, _ := strconv.ParseInt(s.BitRate, 10, 64)
+		switch {
+		case s.CodecType == "video" && info.Video == nil && s.Disposition.AttachedPic == 0:
+			fps, _ := parseFrameRate(s.FrameRate)
+			info.Video = &VideoStream{
+				Codec:       s.CodecName,
+				Width:       s.Width,
+				Height:      s.Height,
+				FrameRate:   fps,
+				PixelFormat: s.PixFmt,
+				BitRate:     bitRate,
+				Duration:    parseSeconds(s.Duration),
+			}
+		case s.CodecType == "audio" && info.Audio == nil:
+			sampleRate, _ := strconv.Atoi(s.SampleRate)
+			info.Audio = &AudioStream{
+				Codec:         s.CodecName,
+				SampleRate:    sampleRate,
+				Channels:      s.Channels,
+				ChannelLayout: s.ChannelLayout,
+				BitRate:       bitRate,
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseSeconds parses an ffprobe duration in seconds, returning zero when it
+// is missing.
+func parseSeconds(s string) time.Duration {
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(math.Round(d * float64(time.Second)))
+}
@@ -0,0 +1,71 @@
+package genailib
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	out := []byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "mjpeg", "width": 500, "height": 500, "disposition": {"attached_pic": 1}},
+			{"codec_type": "video", "codec_name": "h264", "width": 1280, "height": 720, "pix_fmt": "yuv420p", "r_frame_rate": "30000/1001", "bit_rate": "2000000", "duration": "7.974000", "disposition": {"attached_pic": 0}},
+			{"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000", "channels": 2, "channel_layout": "stereo", "bit_rate": "128000"},
+			{"codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100", "channels": 1}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "8.008000", "size": "2100000", "bit_rate": "2097000"}
+	}`)
+	info, err := parseProbe(out)
+	if err != nil {
+		t.Fatalf("parseProbe returned error: %v", err)
+	}
+	if info.Duration != 8008*time.Millisecond || info.Size != 2100000 || info.BitRate != 2097000 || info.Format != "mov,mp4,m4a,3gp,3g2,mj2" {
+		t.Fatalf("unexpected format %+v", info)
+	}
+	v := info.Video
+	if v == nil || v.Codec != "h264" || v.Width != 1280 || v.Height != 720 || v.PixelFormat != "yuv420p" || v.FrameRate < 29.97 || v.FrameRate > 29.98 || v.BitRate != 2000000 || v.Duration != 7974*time.Millisecond {
+		t.Fatalf("unexpected video stream %+v", v)
+	}
+	a := info.Audio
+	if a == nil || a.Codec != "aac" || a.SampleRate != 48000 || a.Channels != 2 || a.ChannelLayout != "stereo" {
+		t.Fatalf("unexpected audio stream %+v", a)
+	}
+
+	info, err = parseProbe([]byte(`{"streams": [{"codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100", "channels": 1}], "format": {"format_name": "mp3", "duration": "1.0"}}`))
+	if err != nil || info.Video != nil || info.Audio == nil || info.Duration != time.Second {
+		t.Fatalf("unexpected audio-only info %+v: %v", info, err)
+	}
+	if _, err := parseProbe([]byte("not json")); err == nil {
+		t.Fatal("expected error for invalid output")
+	}
+}
+
+func TestProbeMedia(t *testing.T) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe not installed")
+	}
+	vid, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	info, err := ProbeMedia(vid)
+	if err != nil {
+		t.Fatalf("ProbeMedia returned error: %v", err)
+	}
+	if info.Video == nil || info.Video.Width != 320 || info.Video.Height != 240 || info.Audio != nil {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.Duration < 900*time.Millisecond || info.Duration > 1100*time.Millisecond {
+		t.Fatalf("unexpected duration %v", info.Duration)
+	}
+
+	aud, err := createToneAudio()
+	if err != nil {
+		t.Fatalf("failed to create audio: %v", err)
+	}
+	if _, err := AddAudioToVideo(aud, aud); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for audio passed as video, got %v", err)
+	}
+}
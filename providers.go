@@ -0,0 +1,211 @@
+package genailib
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/iomodo/gen-ai-lib/external/gemini"
+	"github.com/iomodo/gen-ai-lib/external/openai"
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+	"github.com/iomodo/gen-ai-lib/external/storage"
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
+)
+
+// providers builds provider clients from a Config. Clients are cached per
+// API key so that tenants with their own keys get their own clients.
+type providers struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	openAI    map[string]openai.OpenAIService
+	gemini    map[string]gemini.GeminiService
+	replicate map[string]replicate.ReplicateService
+}
+
+// ffmpeg returns the configured ffmpeg runner.
+func (p *providers) ffmpeg() *FFmpeg {
+	if p.cfg.FFmpeg != nil {
+		return p.cfg.FFmpeg
+	}
+	return defaultFFmpeg
+}
+
+func newProviders(cfg Config) *providers {
+	p := &providers{
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+		openAI:     map[string]openai.OpenAIService{},
+		gemini:     map[string]gemini.GeminiService{},
+		replicate:  map[string]replicate.ReplicateService{},
+	}
+	if client := cfg.httpClient(); client != nil {
+		p.httpClient = client
+		if p.cfg.OpenAI.HTTPClient == nil {
+			p.cfg.OpenAI.HTTPClient = client
+		}
+		// genai only looks up Google default credentials when it has no
+		// HTTP client, so Vertex AI without an API key keeps its own.
+		vertexADC := p.cfg.Gemini.UseVertexAI && p.cfg.Gemini.APIKey == ""
+		if p.cfg.Gemini.HTTPClient == nil && !vertexADC {
+			p.cfg.Gemini.HTTPClient = client
+		}
+		if p.cfg.Replicate.HTTPClient == nil {
+			p.cfg.Replicate.HTTPClient = client
+		}
+	}
+	return p
+}
+
+// withOpenAI calls fn with an OpenAI client for the key resolved from ctx.
+func (p *providers) withOpenAI(ctx context.Context, model string, fn func(openai.OpenAIService) (any, error)) (any, error) {
+	return withProviderKey(ctx, OpenAIProvider, model, p.cfg.OpenAI.APIKey, func(key string) (any, error) {
+		p.mu.Lock()
+		svc, ok := p.openAI[key]
+		p.mu.Unlock()
+		if !ok {
+			cfg := p.cfg.OpenAI
+			cfg.APIKey = key
+			var err error
+			if svc, err = openai.NewService(cfg); err != nil {
+				return nil, err
+			}
+			p.mu.Lock()
+			p.openAI[key] = svc
+			p.mu.Unlock()
+		}
+		return fn(svc)
+	})
+}
+
+// withGemini calls fn with a Gemini client for the key resolved from ctx.
+func (p *providers) withGemini(ctx context.Context, model string, fn func(gemini.GeminiService) (any, error)) (any, error) {
+	return withProviderKey(ctx, GeminiProvider, model, p.cfg.Gemini.APIKey, func(key string) (any, error) {
+		p.mu.Lock()
+		svc, ok := p.gemini[key]
+		p.mu.Unlock()
+		if !ok {
+			cfg := p.cfg.Gemini
+			cfg.APIKey = key
+			var err error
+			if svc, err = gemini.NewGeminiService(ctx, cfg); err != nil {
+				return nil, err
+			}
+			p.mu.Lock()
+			p.gemini[key] = svc
+			p.mu.Unlock()
+		}
+		return fn(svc)
+	})
+}
+
+// withReplicate calls fn with a Replicate client for the token resolved from
+// ctx. Cached clients keep their resolved model versions between calls.
+func (p *providers) withReplicate(ctx context.Context, model string, fn func(replicate.ReplicateService) (any, error)) (any, error) {
+	return withProviderKey(ctx, ReplicateProvider, model, p.cfg.Replicate.APIToken, func(token string) (any, error) {
+		p.mu.Lock()
+		svc, ok := p.replicate[token]
+		p.mu.Unlock()
+		if !ok {
+			cfg := p.cfg.Replicate
+			cfg.APIToken = token
+			var err error
+			if svc, err = replicate.NewReplicateService(cfg); err != nil {
+				return nil, err
+			}
+			p.mu.Lock()
+			p.replicate[token] = svc
+			p.mu.Unlock()
+		}
+		return fn(svc)
+	})
+}
+
+// download fetches url with the configured HTTP client.
+func (p *providers) download(ctx context.Context, url string) ([]byte, error) {
+	return downloadFile(ctx, p.httpClient, url)
+}
+
+// loadImage returns the content of in, fetching URLs and storage objects.
+func (p *providers) loadImage(ctx context.Context, in ImageInput) ([]byte, error) {
+	switch {
+	case len(in.Data) > 0:
+		return in.Data, nil
+	case strings.HasPrefix(in.URL, "data:"):
+		data, _, err := decodeDataURI(in.URL)
+		return data, err
+	case in.URL != "":
+		return p.download(ctx, in.URL)
+	case in.Object != "":
+		if p.cfg.Storage == nil {
+			return nil, fmt.Errorf("%w: no storage configured for object %s", ErrInvalidParameters, in.Object)
+		}
+		dl, ok := p.cfg.Storage.(storage.Downloader)
+		if !ok {
+			return nil, fmt.Errorf("%w: the configured storage cannot download object %s", ErrInvalidParameters, in.Object)
+		}
+		return dl.Download(ctx, in.Object)
+	default:
+		return nil, fmt.Errorf("%w: empty image input", ErrInvalidParameters)
+	}
+}
+
+// runAll runs model and returns the URLs of all its outputs. Services that
+// cannot return several outputs yield the single URL returned by Run.
+func runAll(ctx context.Context, svc replicate.ReplicateService, model, prompt string, input map[string]any) ([]string, error) {
+	if mr, ok := svc.(replicate.MultiRunner); ok {
+		return mr.RunAll(ctx, model, prompt, input)
+	}
+	out, err := svc.Run(ctx, model, prompt, input)
+	if err != nil {
+		return nil, err
+	}
+	url, ok := out.(string)
+	if !ok || url == "" {
+		return nil, fmt.Errorf("unexpected %s output %T", model, out)
+	}
+	return []string{url}, nil
+}
+
+// maxDataURIBytes is the largest file sent to Replicate inline as a data
+// URI. Larger files are uploaded first, as Replicate recommends.
+const maxDataURIBytes = 256 << 10
+
+// replicateInput returns a value Replicate accepts for a file input: the URL
+// of in, a data URI for small files or the URL of an upload. Services that
+// cannot upload get a data URI whatever the size.
+func (p *providers) replicateInput(ctx context.Context, svc replicate.ReplicateService, in ImageInput) (string, error) {
+	if in.URL != "" && len(in.Data) == 0 {
+		return in.URL, nil
+	}
+	data, err := p.loadImage(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	// Sniffed types win over labels, which are often wrong.
+	mimeType := mediatype.Detect(data)
+	if mimeType == mediatype.Unknown && in.MIMEType != "" {
+		mimeType = in.MIMEType
+	}
+	if up, ok := svc.(replicate.FileUploader); ok && len(data) > maxDataURIBytes {
+		return up.UploadFile(ctx, data, mimeType)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeDataURI decodes a base64 data URI.
+func decodeDataURI(uri string) ([]byte, string, error) {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, "", fmt.Errorf("%w: only base64 data URIs are supported", ErrInvalidParameters)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: invalid data URI: %v", ErrInvalidParameters, err)
+	}
+	return data, strings.TrimSuffix(meta, ";base64"), nil
+}
@@ -0,0 +1,329 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caption is a line of text shown from Start to End.
+type Caption struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	Text  string        `json:"text"`
+}
+
+// CaptionMode selects how captions are attached to a video.
+type CaptionMode string
+
+// Caption modes.
+const (
+	// CaptionsBurnIn draws the captions onto the frames.
+	CaptionsBurnIn CaptionMode = "burn"
+	// CaptionsSoft adds a subtitle track that players can turn on and off.
+	CaptionsSoft CaptionMode = "soft"
+)
+
+// CaptionPosition places burned-in captions.
+type CaptionPosition string
+
+// Caption positions.
+const (
+	CaptionBottom CaptionPosition = "bottom"
+	CaptionMiddle CaptionPosition = "middle"
+	CaptionTop    CaptionPosition = "top"
+)
+
+// CaptionStyle styles burned-in captions. Sizes follow the subtitle
+// renderer, which scales them to a frame 288 units high.
+type CaptionStyle struct {
+	// Font is a font family installed on the system, "Sans" by default.
+	Font string
+	// Size defaults to 18.
+	Size int
+	// Color defaults to white and OutlineColor to black.
+	Color        color.Color
+	OutlineColor color.Color
+	// Outline is the outline width, 1.5 by default. Use a negative value
+	// for no outline.
+	Outline float64
+	// Position defaults to CaptionBottom.
+	Position CaptionPosition
+	// Margin is the distance from the top or bottom edge, 20 by default.
+	Margin int
+}
+
+// CaptionOptions configures AddCaptionsToVideo.
+type CaptionOptions struct {
+	// Mode defaults to CaptionsBurnIn.
+	Mode  CaptionMode
+	Style CaptionStyle
+	// Language is the ISO 639-2 code of a soft subtitle track, "eng" by
+	// default.
+	Language string
+}
+
+// ParseSubtitles parses SRT or WebVTT subtitles.
+func ParseSubtitles(data []byte) ([]Caption, error) {
+	text := strings.ReplaceAll(string(bytes.TrimPrefix(data, []byte("\ufeff"))), "\r\n", "\n")
+	var captions []Caption
+	for i, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if lines[0] == "" {
+			continue
+		}
+		if i == 0 && strings.HasPrefix(lines[0], "WEBVTT") {
+			continue
+		}
+		if first := strings.Fields(lines[0]); len(first) > 0 && (first[0] == "NOTE" || first[0] == "STYLE" || first[0] == "REGION") {
+			continue
+		}
+		// The timing line may follow a cue number or identifier.
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			timing = 1
+		}
+		if timing >= len(lines) || !strings.Contains(lines[timing], "-->") {
+			return nil, fmt.Errorf("%w: subtitle block %d has no timing", ErrInvalidParameters, i+1)
+		}
+		from, to, _ := strings.Cut(lines[timing], "-->")
+		start, err := parseSubtitleTime(from)
+		if err != nil {
+			return nil, err
+		}
+		// WebVTT cue settings may follow the end time.
+		end, err := parseSubtitleTime(strings.Fields(to + " ")[0])
+		if err != nil {
+			return nil, err
+		}
+		captions = append(captions, Caption{Start: start, End: end, Text: strings.Join(lines[timing+1:], "\n")})
+	}
+	if len(captions) == 0 {
+		return nil, fmt.Errorf("%w: no captions found", ErrInvalidParameters)
+	}
+	return captions, nil
+}
+
+// parseSubtitleTime parses "hh:mm:ss,mmm" (SRT) or "[hh:]mm:ss.mmm" (WebVTT).
+func parseSubtitleTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("%w: invalid subtitle time %q", ErrInvalidParameters, s)
+	}
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("%w: invalid subtitle time %q", ErrInvalidParameters, s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)).Round(time.Millisecond), nil
+}
+
+// formatSRT writes captions as SRT subtitles.
+func formatSRT(captions []Caption) []byte {
+	var buf bytes.Buffer
+	stamp := func(d time.Duration) string {
+		ms := d.Milliseconds()
+		return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+	}
+	for i, c := range captions {
+		fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", i+1, stamp(c.Start), stamp(c.End), c.Text)
+	}
+	return buf.Bytes()
+}
+
+func (s CaptionStyle) withDefaults() (CaptionStyle, error) {
+	if s.Font == "" {
+		s.Font = "Sans"
+	}
+	if strings.ContainsAny(s.Font, `',:\=`) {
+		return s, fmt.Errorf("%w: invalid font name %q", ErrInvalidParameters, s.Font)
+	}
+	if s.Size == 0 {
+		s.Size = 18
+	}
+	if s.Color == nil {
+		s.Color = color.White
+	}
+	if s.OutlineColor == nil {
+		s.OutlineColor = color.Black
+	}
+	switch {
+	case s.Outline == 0:
+		s.Outline = 1.5
+	case s.Outline < 0:
+		s.Outline = 0
+	}
+	if s.Position == "" {
+		s.Position = CaptionBottom
+	}
+	if s.Margin == 0 {
+		s.Margin = 20
+	}
+	if s.Size < 0 || s.Margin < 0 {
+		return s, fmt.Errorf("%w: caption size and margin must be positive", ErrInvalidParameters)
+	}
+	return s, nil
+}
+
+// forceStyle returns s as an ASS style override for ffmpeg's subtitles
+// filter.
+func (s CaptionStyle) forceStyle() (string, error) {
+	// Alignment uses the numeric keypad layout.
+	alignment := map[CaptionPosition]int{CaptionBottom: 2, CaptionMiddle: 5, CaptionTop: 8}[s.Position]
+	if alignment == 0 {
+		return "", fmt.Errorf("%w: unknown caption position %q", ErrInvalidParameters, s.Position)
+	}
+	return fmt.Sprintf(
+		"FontName=%s,FontSize=%d,PrimaryColour=%s,OutlineColour=%s,BorderStyle=1,Outline=%g,Alignment=%d,MarginV=%d",
+		s.Font, s.Size, assColor(s.Color), assColor(s.OutlineColor), s.Outline, alignment, s.Margin,
+	), nil
+}
+
+// assColor formats c as an ASS colour, &HAABBGGRR with 00 meaning opaque.
+func assColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("&H%02X%02X%02X%02X", 255-n.A, n.B, n.G, n.R)
+}
+
+// AddCaptionsToVideo attaches captions to video, burning them into the
+// frames with the given style or adding them as a soft mov_text subtitle
+// track. Use ParseSubtitles to read SRT or WebVTT files. Burning in requires
+// an ffmpeg built with libass. ffmpeg and ffprobe must be installed and
+// accessible on the system PATH.
+func AddCaptionsToVideo(video []byte, captions []Caption, opts CaptionOptions) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, CaptionsOp(captions, opts))
+}
+
+// CaptionsOp is the operation run by AddCaptionsToVideo.
+func CaptionsOp(captions []Caption, opts CaptionOptions) VideoOp {
+	if len(captions) == 0 {
+		return invalidOp(fmt.Errorf("%w: no captions", ErrInvalidParameters))
+	}
+	for i, c := range captions {
+		if c.Start < 0 || c.End <= c.Start || strings.TrimSpace(c.Text) == "" {
+			return invalidOp(fmt.Errorf("%w: caption %d needs text and an end after its start", ErrInvalidParameters, i+1))
+		}
+	}
+	if opts.Mode == "" {
+		opts.Mode = CaptionsBurnIn
+	}
+	if opts.Language == "" {
+		opts.Language = "eng"
+	}
+	var filter string
+	switch opts.Mode {
+	case CaptionsBurnIn:
+		style, err := opts.Style.withDefaults()
+		if err != nil {
+			return invalidOp(err)
+		}
+		if filter, err = style.forceStyle(); err != nil {
+			return invalidOp(err)
+		}
+	case CaptionsSoft:
+	default:
+		return invalidOp(fmt.Errorf("%w: unknown caption mode %q", ErrInvalidParameters, opts.Mode))
+	}
+
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		subFile, err := j.write("captions.srt", formatSRT(captions))
+		if err != nil {
+			return nil, err
+		}
+		if opts.Mode == CaptionsBurnIn {
+			return []string{
+				"-i", in,
+				"-vf", fmt.Sprintf("subtitles=filename=%s:force_style='%s'", escapeFilterPath(subFile), filter),
+				"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p",
+				"-c:a", "copy",
+				"-y", out,
+			}, nil
+		}
+		return []string{
+			"-i", in,
+			"-i", subFile,
+			"-map", "0:v", "-map", "0:a?", "-map", "1:s",
+			"-c:v", "copy", "-c:a", "copy", "-c:s", "mov_text",
+			"-metadata:s:s:0", "language=" + opts.Language,
+			"-y", out,
+		}, nil
+	}}
+}
+
+// escapeFilterPath escapes a file name for use as a filter option value.
+func escapeFilterPath(path string) string {
+	r := strings.NewReplacer(`\`, `\\\\`, `:`, `\\:`, `'`, `\\\'`)
+	return r.Replace(path)
+}
+
+// captionsFromOption reads a list of captions, each a map with "start" and
+// "end" in seconds and "text".
+func captionsFromOption(key string, v any) ([]Caption, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, key, v)
+	}
+	captions := make([]Caption, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: caption %d must be a map, got %T", ErrInvalidParameters, i+1, item)
+		}
+		var err error
+		if captions[i].Start, err = optionSeconds(key+".start", m["start"]); err != nil {
+			return nil, err
+		}
+		if captions[i].End, err = optionSeconds(key+".end", m["end"]); err != nil {
+			return nil, err
+		}
+		if captions[i].Text, err = optionString(key+".text", m["text"]); err != nil {
+			return nil, err
+		}
+	}
+	return captions, nil
+}
+
+// captionStyleFromOption reads the style settings shared by the caption_video
+// step and the texts of a render_timeline step: font, size, color,
+// outline_color, outline, position and margin.
+func captionStyleFromOption(style *CaptionStyle, k string, v any) (bool, error) {
+	var (
+		err error
+		n   int64
+		str string
+	)
+	switch k {
+	case "font":
+		style.Font, err = optionString(k, v)
+	case "size":
+		n, err = optionInt(k, v)
+		style.Size = int(n)
+	case "color":
+		if str, err = optionString(k, v); err == nil {
+			style.Color, err = parseColor(str)
+		}
+	case "outline_color":
+		if str, err = optionString(k, v); err == nil {
+			style.OutlineColor, err = parseColor(str)
+		}
+	case "outline":
+		style.Outline, err = optionFloat(k, v)
+	case "position":
+		str, err = optionString(k, v)
+		style.Position = CaptionPosition(str)
+	case "margin":
+		n, err = optionInt(k, v)
+		style.Margin = int(n)
+	default:
+		return false, nil
+	}
+	return true, err
+}
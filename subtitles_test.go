@@ -0,0 +1,127 @@
+package genailib
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "1\r\n00:00:00,000 --> 00:00:00,500\r\nHello\r\n\r\n2\r\n00:00:00,500 --> 00:00:01,000\r\nsecond\r\nline\r\n"
+
+func TestParseSubtitles(t *testing.T) {
+	captions, err := ParseSubtitles([]byte(testSRT))
+	if err != nil {
+		t.Fatalf("ParseSubtitles returned error: %v", err)
+	}
+	if len(captions) != 2 || captions[1] != (Caption{500 * time.Millisecond, time.Second, "second\nline"}) {
+		t.Fatalf("unexpected captions %+v", captions)
+	}
+	if got := string(formatSRT(captions)); got != strings.ReplaceAll(testSRT, "\r\n", "\n")+"\n" {
+		t.Fatalf("formatSRT did not round trip:\n%s", got)
+	}
+
+	vtt := "WEBVTT - demo\n\nNOTE a comment\n\nintro\n00:01.250 --> 00:02.000 align:start\nHi\n\n01:00:00.000 --> 01:00:01.000\nLate\n"
+	captions, err = ParseSubtitles([]byte(vtt))
+	if err != nil {
+		t.Fatalf("ParseSubtitles returned error: %v", err)
+	}
+	if len(captions) != 2 || captions[0] != (Caption{1250 * time.Millisecond, 2 * time.Second, "Hi"}) || captions[1].Start != time.Hour {
+		t.Fatalf("unexpected captions %+v", captions)
+	}
+
+	for _, bad := range []string{"", "WEBVTT\n", "1\nHello\n", "00:00:xx,000 --> 00:00:01,000\nHi"} {
+		if _, err := ParseSubtitles([]byte(bad)); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestCaptionStyle(t *testing.T) {
+	style, err := CaptionStyle{Color: color.RGBA{R: 255, G: 204, A: 255}, Position: CaptionTop, Outline: -1}.withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults returned error: %v", err)
+	}
+	got, err := style.forceStyle()
+	if err != nil {
+		t.Fatalf("forceStyle returned error: %v", err)
+	}
+	want := "FontName=Sans,FontSize=18,PrimaryColour=&H0000CCFF,OutlineColour=&H00000000,BorderStyle=1,Outline=0,Alignment=8,MarginV=20"
+	if got != want {
+		t.Fatalf("unexpected style\n got %s\nwant %s", got, want)
+	}
+	if _, err := (CaptionStyle{Font: "Sans,Bold"}).withDefaults(); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for font name, got %v", err)
+	}
+	if _, err := (CaptionStyle{Position: "left"}).forceStyle(); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for position, got %v", err)
+	}
+	if got := escapeFilterPath(`C:\tmp\it's.srt`); got != `C\\:\\\\tmp\\\\it\\\'s.srt` {
+		t.Fatalf("unexpected escaped path %s", got)
+	}
+}
+
+func TestAddCaptionsToVideoInvalid(t *testing.T) {
+	captions := []Caption{{0, time.Second, "Hi"}}
+	cases := []struct {
+		captions []Caption
+		opts     CaptionOptions
+	}{
+		{nil, CaptionOptions{}},
+		{[]Caption{{time.Second, time.Second, "Hi"}}, CaptionOptions{}},
+		{[]Caption{{0, time.Second, " "}}, CaptionOptions{}},
+		{captions, CaptionOptions{Mode: "karaoke"}},
+		{captions, CaptionOptions{Style: CaptionStyle{Size: -3}}},
+	}
+	for _, c := range cases {
+		if _, err := AddCaptionsToVideo(nil, c.captions, c.opts); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %+v %+v, got %v", c.captions, c.opts, err)
+		}
+	}
+}
+
+func TestAddCaptionsToVideo(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("blue")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	captions, _ := ParseSubtitles([]byte(testSRT))
+	for _, mode := range []CaptionMode{CaptionsSoft, CaptionsBurnIn} {
+		out, err := AddCaptionsToVideo(vid, captions, CaptionOptions{Mode: mode, Style: CaptionStyle{Size: 24, Color: color.White}})
+		if err != nil {
+			t.Fatalf("%s: AddCaptionsToVideo returned error: %v", mode, err)
+		}
+		if len(out) == 0 {
+			t.Fatalf("%s: output video is empty", mode)
+		}
+	}
+}
+
+func TestWorkflowCaptionVideoOptions(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "captions",
+		FunctionType: FunctionTypeCaptionVideo,
+		Video:        "clip",
+		Options:      map[string]any{"subtitles": "srt", "mode": "karaoke"},
+	}}}
+	inputs := map[string]any{"clip": []byte("video"), "srt": []byte(testSRT)}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for caption mode, got %v", err)
+	}
+
+	wf.Steps[0].Options = map[string]any{"captions": []any{map[string]any{"start": 0, "end": 1, "text": "Hi"}}, "colour": "#fff"}
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for unknown option, got %v", err)
+	}
+	wf.Steps[0].Options = nil
+	if _, _, err := svc.Generate(context.Background(), wf, inputs); err == nil {
+		t.Fatal("expected error without captions")
+	}
+}
@@ -0,0 +1,288 @@
+package genailib
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+	"github.com/pkg/errors"
+	goopenai "github.com/sashabaranov/go-openai"
+	"google.golang.org/genai"
+)
+
+// ErrQuotaExceeded is returned when a tenant has used up its request or spend quota.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// ErrNoTenantKey is returned when a tenant has no keys for the provider a
+// call needs and may not use the configured ones.
+var ErrNoTenantKey = errors.New("tenant has no key for provider")
+
+// DefaultKeyCooldown is how long a rate-limited key is skipped by a KeyPool.
+const DefaultKeyCooldown = time.Minute
+
+// Quota limits the usage of a tenant. Zero values mean unlimited.
+type Quota struct {
+	MaxRequests int
+	MaxSpend    float64
+}
+
+// Usage reports what a tenant has consumed so far.
+type Usage struct {
+	Requests int
+	Spend    float64
+}
+
+// Tenant holds the credentials and quota of a single customer. A Tenant is
+// meant to be long lived so that its usage and key rotation state persist
+// across calls; attach it to a request context with WithTenant.
+type Tenant struct {
+	ID string
+	// Keys maps a provider name (OpenAIProvider, GeminiProvider,
+	// ReplicateProvider) to the pool of keys used for that provider.
+	Keys  map[string]*KeyPool
+	Quota Quota
+	// Prices overrides the catalog price of a model when charging spend.
+	Prices map[string]float64
+	// UseDefaultKeys lets calls to a provider without keys in Keys fall
+	// back to the keys of the configuration, billing their owner rather
+	// than the tenant. Otherwise such calls fail with ErrNoTenantKey.
+	UseDefaultKeys bool
+
+	mu    sync.Mutex
+	usage Usage
+}
+
+// NewTenant returns a tenant with the given ID and quota.
+func NewTenant(id string, quota Quota) *Tenant {
+	return &Tenant{ID: id, Quota: quota, Keys: map[string]*KeyPool{}}
+}
+
+// SetKeys registers a pool of API keys for provider.
+func (t *Tenant) SetKeys(provider string, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Keys == nil {
+		t.Keys = map[string]*KeyPool{}
+	}
+	t.Keys[provider] = NewKeyPool(keys...)
+}
+
+// Usage returns a snapshot of the tenant's consumption.
+func (t *Tenant) Usage() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+func (t *Tenant) keyPool(provider string) *KeyPool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Keys[provider]
+}
+
+func (t *Tenant) price(model string) float64 {
+	if p, ok := t.Prices[model]; ok {
+		return p
+	}
+	return estimatedPrice(model)
+}
+
+// reserve charges a request of the given cost against the quota.
+func (t *Tenant) reserve(cost float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Quota.MaxRequests > 0 && t.usage.Requests+1 > t.Quota.MaxRequests {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %s reached %d requests", t.ID, t.Quota.MaxRequests)
+	}
+	if t.Quota.MaxSpend > 0 && t.usage.Spend+cost > t.Quota.MaxSpend {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %s reached spend limit %.2f", t.ID, t.Quota.MaxSpend)
+	}
+	t.usage.Requests++
+	t.usage.Spend += cost
+	return nil
+}
+
+// refund gives back a reservation for a request that did not complete.
+func (t *Tenant) refund(cost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage.Requests--
+	t.usage.Spend -= cost
+}
+
+// settle corrects the spend of a completed request that was reserved at
+// reserved but cost actual.
+func (t *Tenant) settle(reserved, actual float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage.Spend += actual - reserved
+}
+
+// KeyPool rotates across several API keys of one provider, skipping keys
+// that were recently rate limited.
+type KeyPool struct {
+	Cooldown time.Duration
+
+	mu      sync.Mutex
+	keys    []string
+	next    int
+	limited map[string]time.Time
+}
+
+// NewKeyPool returns a pool over keys using DefaultKeyCooldown.
+func NewKeyPool(keys ...string) *KeyPool {
+	return &KeyPool{Cooldown: DefaultKeyCooldown, keys: keys, limited: map[string]time.Time{}}
+}
+
+// Len returns the number of keys in the pool.
+func (p *KeyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// Acquire returns the next key that is not cooling down after a rate limit.
+func (p *KeyPool) Acquire() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) == 0 {
+		return "", errors.New("key pool is empty")
+	}
+	now := time.Now()
+	for range p.keys {
+		key := p.keys[p.next%len(p.keys)]
+		p.next = (p.next + 1) % len(p.keys)
+		if until, ok := p.limited[key]; ok && now.Before(until) {
+			continue
+		}
+		delete(p.limited, key)
+		return key, nil
+	}
+	return "", ErrRateLimitExceeded
+}
+
+// MarkRateLimited takes key out of rotation for the pool's cooldown.
+func (p *KeyPool) MarkRateLimited(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cooldown := p.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultKeyCooldown
+	}
+	p.limited[key] = time.Now().Add(cooldown)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant's credentials and quota.
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFromContext returns the tenant attached to ctx, if any.
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return t, ok && t != nil
+}
+
+type imageCountKey struct{}
+
+// withImageCount returns a copy of ctx telling withProviderKey that the call
+// asks for n images, so that a tenant is charged for all of them up front.
+func withImageCount(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, imageCountKey{}, n)
+}
+
+// imageCount returns the number of images requested in ctx, at least one.
+func imageCount(ctx context.Context) int {
+	n, _ := ctx.Value(imageCountKey{}).(int)
+	return max(n, 1)
+}
+
+// withProviderKey calls fn with an API key for provider. When ctx carries a
+// tenant, the call is charged against its quota, at the model's price times
+// the number of images requested (see withImageCount), and the key is taken
+// from its pool, rotating to the next key whenever the provider reports a rate
+// limit. Without a tenant, or when the tenant has no keys for provider,
+// defaultKey is used.
+func withProviderKey(ctx context.Context, provider, model, defaultKey string, fn func(key string) (any, error)) (any, error) {
+	t, ok := TenantFromContext(ctx)
+	if !ok {
+		return fn(defaultKey)
+	}
+
+	pool := t.keyPool(provider)
+	if (pool == nil || pool.Len() == 0) && !t.UseDefaultKeys {
+		return nil, errors.Wrapf(ErrNoTenantKey, "tenant %s, provider %s", t.ID, provider)
+	}
+	cost := t.price(model) * float64(imageCount(ctx))
+	if err := t.reserve(cost); err != nil {
+		return nil, err
+	}
+
+	res, err := callWithPool(pool, defaultKey, fn)
+	if err != nil {
+		t.refund(cost)
+		return nil, err
+	}
+	return res, nil
+}
+
+func callWithPool(pool *KeyPool, defaultKey string, fn func(key string) (any, error)) (any, error) {
+	if pool == nil || pool.Len() == 0 {
+		return fn(defaultKey)
+	}
+
+	var lastErr error
+	for i := 0; i < pool.Len(); i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			break
+		}
+		res, err := fn(key)
+		if err == nil {
+			return res, nil
+		}
+		if !isRateLimitError(err) {
+			return nil, err
+		}
+		pool.MarkRateLimited(key)
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, errors.Wrap(ErrRateLimitExceeded, lastErr.Error())
+	}
+	return nil, ErrRateLimitExceeded
+}
+
+// isRateLimitError reports whether err is a rate limit response from any of
+// the supported providers.
+func isRateLimitError(err error) bool {
+	if errors.Is(err, ErrRateLimitExceeded) || errors.Is(err, replicate.ErrRateLimited) {
+		return true
+	}
+	var oaiErr *goopenai.APIError
+	if errors.As(err, &oaiErr) && oaiErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var reqErr *goopenai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var genaiErr genai.APIError
+	if errors.As(err, &genaiErr) && genaiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	return false
+}
+
+// priceOf returns the price of one request to model, using the prices of
+// the tenant attached to ctx when present.
+func priceOf(ctx context.Context, model string) float64 {
+	if t, ok := TenantFromContext(ctx); ok {
+		return t.price(model)
+	}
+	return estimatedPrice(model)
+}
@@ -0,0 +1,176 @@
+package genailib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/iomodo/gen-ai-lib/external/replicate"
+)
+
+func TestKeyPoolRotation(t *testing.T) {
+	pool := NewKeyPool("a", "b", "c")
+	var got []string
+	for i := 0; i < 4; i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire returned error: %v", err)
+		}
+		got = append(got, key)
+	}
+	want := []string{"a", "b", "c", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected rotation %v, want %v", got, want)
+		}
+	}
+
+	pool.MarkRateLimited("b")
+	for i := 0; i < 3; i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire returned error: %v", err)
+		}
+		if key == "b" {
+			t.Fatalf("rate limited key was returned")
+		}
+	}
+}
+
+func TestKeyPoolExhausted(t *testing.T) {
+	pool := NewKeyPool("a")
+	pool.MarkRateLimited("a")
+	if _, err := pool.Acquire(); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("expected ErrRateLimitExceeded, got %v", err)
+	}
+}
+
+func TestWithProviderKeyRotatesOnRateLimit(t *testing.T) {
+	tenant := NewTenant("acme", Quota{})
+	tenant.SetKeys(ReplicateProvider, "k1", "k2")
+	ctx := WithTenant(context.Background(), tenant)
+
+	var used []string
+	res, err := withProviderKey(ctx, ReplicateProvider, ProviderSeedance1Lite, "default", func(key string) (any, error) {
+		used = append(used, key)
+		if key == "k1" {
+			return nil, replicate.ErrRateLimited
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("withProviderKey returned error: %v", err)
+	}
+	if res != "ok" {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	if len(used) != 2 || used[0] != "k1" || used[1] != "k2" {
+		t.Fatalf("unexpected keys used: %v", used)
+	}
+	if u := tenant.Usage(); u.Requests != 1 || u.Spend != estimatedPrice(ProviderSeedance1Lite) {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+}
+
+func TestWithProviderKeyDefaultKey(t *testing.T) {
+	res, err := withProviderKey(context.Background(), OpenAIProvider, ProviderGPTImage1, "env-key", func(key string) (any, error) {
+		return key, nil
+	})
+	if err != nil {
+		t.Fatalf("withProviderKey returned error: %v", err)
+	}
+	if res != "env-key" {
+		t.Fatalf("expected default key, got %v", res)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	tenant := NewTenant("acme", Quota{MaxRequests: 1})
+	tenant.SetKeys(OpenAIProvider, "k1")
+	ctx := WithTenant(context.Background(), tenant)
+	call := func(string) (any, error) { return nil, nil }
+
+	if _, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "", call); err != nil {
+		t.Fatalf("first call returned error: %v", err)
+	}
+	if _, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "", call); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	spend := NewTenant("spend", Quota{MaxSpend: 0.1})
+	spend.Prices = map[string]float64{ProviderDallE3: 0.08}
+	spend.SetKeys(OpenAIProvider, "k1")
+	ctx = WithTenant(context.Background(), spend)
+	if _, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "", call); err != nil {
+		t.Fatalf("first call returned error: %v", err)
+	}
+	if _, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "", call); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestWithProviderKeyTenantWithoutKeys(t *testing.T) {
+	tenant := NewTenant("acme", Quota{})
+	ctx := WithTenant(context.Background(), tenant)
+	called := false
+	_, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "env-key", func(string) (any, error) {
+		called = true
+		return nil, nil
+	})
+	if !errors.Is(err, ErrNoTenantKey) || called {
+		t.Fatalf("expected ErrNoTenantKey without a call, got %v", err)
+	}
+	if u := tenant.Usage(); u.Requests != 0 {
+		t.Fatalf("rejected call was charged: %+v", u)
+	}
+
+	tenant.UseDefaultKeys = true
+	res, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "env-key", func(key string) (any, error) {
+		return key, nil
+	})
+	if err != nil || res != "env-key" {
+		t.Fatalf("expected the default key, got %v: %v", res, err)
+	}
+}
+
+func TestFailedCallIsRefunded(t *testing.T) {
+	tenant := NewTenant("acme", Quota{MaxRequests: 1})
+	tenant.SetKeys(OpenAIProvider, "k1")
+	ctx := WithTenant(context.Background(), tenant)
+	_, err := withProviderKey(ctx, OpenAIProvider, ProviderDallE3, "", func(string) (any, error) {
+		return nil, errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if u := tenant.Usage(); u.Requests != 0 || u.Spend != 0 {
+		t.Fatalf("failed call was charged: %+v", u)
+	}
+}
+
+func TestTenantChargedPerRequestedImage(t *testing.T) {
+	calls := 0
+	rep := fakeReplicateServer(t, []string{"https://example.com/a.png", "https://example.com/b.png"}, func(map[string]any) { calls++ })
+	g := NewAPIGateway(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+
+	tenant := NewTenant("acme", Quota{MaxSpend: 2})
+	tenant.Prices = map[string]float64{"owner/model": 1}
+	tenant.SetKeys(ReplicateProvider, "tenant-token")
+	ctx := WithTenant(context.Background(), tenant)
+	if _, err := g.TextToImage(ctx, ImageRequest{Prompt: "a cat", N: 3}, "owner/model"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("provider was called %d times past the quota", calls)
+	}
+
+	tenant.Quota.MaxSpend = 3
+	res, err := g.TextToImage(ctx, ImageRequest{Prompt: "a cat", N: 3}, "owner/model")
+	if err != nil {
+		t.Fatalf("TextToImage returned error: %v", err)
+	}
+	// The model returned two of the three images asked for.
+	if u := tenant.Usage(); len(res.Images) != 2 || u.Requests != 1 || u.Spend != 2 || res.Usage.Spend != 2 {
+		t.Fatalf("unexpected usage %+v for %d images", u, len(res.Images))
+	}
+}
@@ -0,0 +1,530 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultImageDuration is how long a timeline shows an image clip without a
+// duration.
+const DefaultImageDuration = 5 * time.Second
+
+// kenBurnsScale enlarges images before zoompan, which moves in whole
+// pixels, so that slow pans and zooms do not judder.
+const kenBurnsScale = 4
+
+// timelineAudioFormat is the common format of the clips' sound, which the
+// concat and acrossfade filters need.
+const timelineAudioFormat = "aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo"
+
+// Timeline describes a video assembled from video clips, still images,
+// audio tracks and text. RenderTimeline renders it with a single ffmpeg
+// filter graph, so every clip is encoded once.
+type Timeline struct {
+	// Width and Height are the output size, 1920x1080 by default. FPS
+	// defaults to 30.
+	Width  int
+	Height int
+	FPS    float64
+	// Background fills the borders of clips letterboxed with FitContain
+	// and the transparent parts of images, black by default.
+	Background color.Color
+	// Clips play one after another on the video track.
+	Clips []TimelineClip
+	// Audio tracks are mixed over the clips' own sound. Their Start is a
+	// position on the timeline.
+	Audio []AudioTrack
+	// ClipVolume scales the clips' own sound. Zero means 1.
+	ClipVolume float64
+	// Ducking, when set, lowers the clips' sound and the music tracks under
+	// the voice tracks.
+	Ducking *Ducking
+	// Texts are burned into the video, which requires an ffmpeg built with
+	// libass.
+	Texts []TextOverlay
+}
+
+// TimelineClip is a video or a still image on a Timeline.
+type TimelineClip struct {
+	// Video or Image holds the clip. Images may be in any format
+	// DecodeImage reads.
+	Video []byte
+	Image []byte
+	// Start and End select a part of a video. A zero End plays it to the
+	// end.
+	Start time.Duration
+	End   time.Duration
+	// Duration is how long an image is shown, DefaultImageDuration by
+	// default.
+	Duration time.Duration
+	// Fit brings the clip to the timeline's size. FitContain, the default,
+	// letterboxes it, FitCover crops it and FitFill stretches it.
+	Fit ImageFit
+	// KenBurns pans and zooms across an image.
+	KenBurns *KenBurns
+	// Mute drops the sound of a video.
+	Mute bool
+	// Transition blends the clip into the next one. The zero value cuts.
+	Transition Transition
+}
+
+// KenBurns slowly zooms and pans across an image.
+type KenBurns struct {
+	// StartZoom and EndZoom magnify the image at the start and end of the
+	// clip, 1 showing all of it. They default to 1 and 1.2.
+	StartZoom float64
+	EndZoom   float64
+	// The view moves from (FromX, FromY) to (ToX, ToY), given as offsets
+	// from the centre of the image in fractions of its size, between -0.5
+	// and 0.5. The view never leaves the image, so panning needs zoom.
+	FromX, FromY float64
+	ToX, ToY     float64
+}
+
+// TextOverlay is text shown over a Timeline from Start to End.
+type TextOverlay struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+	Style CaptionStyle
+}
+
+// RenderTimeline renders tl to an MP4 video. ffmpeg and ffprobe must be
+// installed and accessible on the system PATH.
+func RenderTimeline(tl Timeline) ([]byte, error) {
+	return defaultFFmpeg.renderTimeline(context.Background(), tl)
+}
+
+func (f *FFmpeg) renderTimeline(ctx context.Context, tl Timeline) ([]byte, error) {
+	j, err := f.newJob(ctx, "timeline")
+	if err != nil {
+		return nil, err
+	}
+	defer j.close()
+	out := j.path("output.mp4")
+	if err := j.renderTimeline(tl, out); err != nil {
+		return nil, err
+	}
+	return j.read(out)
+}
+
+// RenderTimelineFile is RenderTimeline writing the video to out.
+func (f *FFmpeg) RenderTimelineFile(ctx context.Context, tl Timeline, out string) error {
+	j, err := f.newJob(ctx, "timeline")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	if err := j.renderTimeline(tl, out); err != nil {
+		return err
+	}
+	return f.checkOutput(out)
+}
+
+func (j *job) renderTimeline(tl Timeline, out string) error {
+	tl, transitions, err := tl.withDefaults()
+	if err != nil {
+		return err
+	}
+	layers, err := textLayers(tl.Texts)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	clips := make([]timelineInput, len(tl.Clips))
+	for i, c := range tl.Clips {
+		name := fmt.Sprintf("clip %d", i+1)
+		if len(c.Image) > 0 {
+			// Images are converted to PNG, which ffmpeg reads in every
+			// build, with transparency flattened onto the background.
+			img, _, err := DecodeImage(c.Image)
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode %s", name)
+			}
+			data, err := EncodeImage(flatten(img, tl.Background), FormatPNG, 0)
+			if err != nil {
+				return err
+			}
+			path, err := j.write(fmt.Sprintf("clip%d.png", i), data)
+			if err != nil {
+				return err
+			}
+			// Images are looped for the length of the clip, except with Ken
+			// Burns, where zoompan turns the single frame into the clip.
+			if c.KenBurns == nil {
+				args = append(args, "-loop", "1", "-framerate", strconv.FormatFloat(tl.FPS, 'f', -1, 64), "-t", seconds(c.Duration))
+			}
+			args = append(args, "-i", path)
+			clips[i] = timelineInput{length: c.Duration}
+			continue
+		}
+
+		path, err := j.write(fmt.Sprintf("clip%d.mp4", i), c.Video)
+		if err != nil {
+			return err
+		}
+		info, err := j.probe(path, name, true, false)
+		if err != nil {
+			return err
+		}
+		end := c.End
+		if end == 0 || end > info.Duration {
+			end = info.Duration
+		}
+		if c.Start >= end {
+			return fmt.Errorf("%w: %s starts after the video ends", ErrInvalidParameters, name)
+		}
+		args = append(args, "-ss", seconds(c.Start), "-t", seconds(end-c.Start), "-i", path)
+		clips[i] = timelineInput{length: end - c.Start, audio: info.Audio != nil && !c.Mute}
+	}
+
+	tracks := make([]mixInput, len(tl.Audio))
+	for i, t := range tl.Audio {
+		name := fmt.Sprintf("audio track %d", i+1)
+		path, err := j.write(fmt.Sprintf("track%d", i), t.Audio)
+		if err != nil {
+			return err
+		}
+		info, err := j.probe(path, name, false, true)
+		if err != nil {
+			return err
+		}
+		if t.Loop {
+			args = append(args, "-stream_loop", "-1")
+		}
+		args = append(args, "-i", path)
+		tracks[i] = mixInput{AudioTrack: t, duration: info.Duration}
+	}
+
+	for i := range layers {
+		if layers[i].path, err = j.write(fmt.Sprintf("texts%d.srt", i), formatSRT(layers[i].captions)); err != nil {
+			return err
+		}
+	}
+
+	filter, total, err := timelineFilter(tl, transitions, clips, tracks, layers)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-filter_complex", filter, "-map", "[vout]", "-map", "[aout]")
+	args = append(args, encodeArgs...)
+	args = append(args, "-t", seconds(total), "-y", out)
+	return j.run(args...)
+}
+
+// withDefaults validates tl and fills in its defaults, returning the
+// transitions between its clips.
+func (tl Timeline) withDefaults() (Timeline, []Transition, error) {
+	if tl.Width == 0 && tl.Height == 0 {
+		tl.Width, tl.Height = 1920, 1080
+	}
+	if tl.Width < 2 || tl.Height < 2 || tl.Width%2 != 0 || tl.Height%2 != 0 {
+		return tl, nil, fmt.Errorf("%w: timeline size %dx%d must be even and positive", ErrInvalidParameters, tl.Width, tl.Height)
+	}
+	if tl.FPS == 0 {
+		tl.FPS = 30
+	}
+	if tl.FPS < 0 {
+		return tl, nil, fmt.Errorf("%w: frame rate must be positive", ErrInvalidParameters)
+	}
+	if tl.Background == nil {
+		tl.Background = color.Black
+	}
+	if len(tl.Clips) == 0 {
+		return tl, nil, fmt.Errorf("%w: timeline has no clips", ErrInvalidParameters)
+	}
+
+	clips := slices.Clone(tl.Clips)
+	opts := MergeOptions{Transitions: make([]Transition, len(clips)-1)}
+	for i, c := range clips {
+		name := fmt.Sprintf("clip %d", i+1)
+		if (len(c.Video) == 0) == (len(c.Image) == 0) {
+			return tl, nil, fmt.Errorf("%w: %s needs either a video or an image", ErrInvalidParameters, name)
+		}
+		switch c.Fit {
+		case "":
+			c.Fit = FitContain
+		case FitCover, FitContain, FitFill:
+		default:
+			return tl, nil, fmt.Errorf("%w: %s has unknown fit %q", ErrInvalidParameters, name, c.Fit)
+		}
+		if c.Start < 0 || c.End < 0 || c.Duration < 0 {
+			return tl, nil, fmt.Errorf("%w: %s has a negative time", ErrInvalidParameters, name)
+		}
+		if len(c.Video) > 0 {
+			if c.Duration != 0 || c.KenBurns != nil {
+				return tl, nil, fmt.Errorf("%w: %s is a video, duration and Ken Burns apply to images", ErrInvalidParameters, name)
+			}
+			if c.End != 0 && c.End <= c.Start {
+				return tl, nil, fmt.Errorf("%w: %s ends before it starts", ErrInvalidParameters, name)
+			}
+		} else {
+			if c.Start != 0 || c.End != 0 {
+				return tl, nil, fmt.Errorf("%w: %s is an image, start and end apply to videos", ErrInvalidParameters, name)
+			}
+			if c.Duration == 0 {
+				c.Duration = DefaultImageDuration
+			}
+			if c.KenBurns != nil {
+				kb, err := c.KenBurns.withDefaults()
+				if err != nil {
+					return tl, nil, fmt.Errorf("%s: %w", name, err)
+				}
+				c.KenBurns = &kb
+			}
+		}
+		if i < len(clips)-1 {
+			opts.Transitions[i] = c.Transition
+		} else if c.Transition != (Transition{}) {
+			return tl, nil, fmt.Errorf("%w: the last clip has no clip to transition into", ErrInvalidParameters)
+		}
+		clips[i] = c
+	}
+	tl.Clips = clips
+	transitions, err := opts.boundaries(len(clips))
+	if err != nil {
+		return tl, nil, err
+	}
+
+	for i, t := range tl.Audio {
+		if err := checkAudioTrack(t); err != nil {
+			return tl, nil, fmt.Errorf("audio track %d: %w", i+1, err)
+		}
+	}
+	if tl.ClipVolume < 0 {
+		return tl, nil, fmt.Errorf("%w: clip volume must not be negative", ErrInvalidParameters)
+	}
+	if tl.Ducking != nil {
+		d, err := tl.Ducking.withDefaults()
+		if err != nil {
+			return tl, nil, err
+		}
+		tl.Ducking = &d
+	}
+	return tl, transitions, nil
+}
+
+func (k KenBurns) withDefaults() (KenBurns, error) {
+	if k.StartZoom == 0 {
+		k.StartZoom = 1
+	}
+	if k.EndZoom == 0 {
+		k.EndZoom = 1.2
+	}
+	// zoompan magnifies at most tenfold.
+	if k.StartZoom < 1 || k.StartZoom > 10 || k.EndZoom < 1 || k.EndZoom > 10 {
+		return k, fmt.Errorf("%w: Ken Burns zoom must be between 1 and 10", ErrInvalidParameters)
+	}
+	for _, v := range []float64{k.FromX, k.FromY, k.ToX, k.ToY} {
+		if v < -0.5 || v > 0.5 {
+			return k, fmt.Errorf("%w: Ken Burns offsets must be between -0.5 and 0.5", ErrInvalidParameters)
+		}
+	}
+	return k, nil
+}
+
+// filter returns a zoompan filter turning a single frame into frames frames
+// of w x h at fps.
+func (k KenBurns) filter(w, h, frames int, fps float64) string {
+	// on counts the output frames, so p runs from 0 to 1 across the clip.
+	p := fmt.Sprintf("on/%d", max(frames-1, 1))
+	lerp := func(from, to float64) string {
+		step := math.Round((to-from)*1e6) / 1e6
+		return fmt.Sprintf("(%g+%g*%s)", from, step, p)
+	}
+	// x and y place the top left corner of the view, centred on the focus
+	// point. zoompan keeps the view inside the frame.
+	return fmt.Sprintf("zoompan=z='%s':x='iw*(0.5+%s)-iw/zoom/2':y='ih*(0.5+%s)-ih/zoom/2':d=%d:s=%dx%d:fps=%g",
+		lerp(k.StartZoom, k.EndZoom), lerp(k.FromX, k.ToX), lerp(k.FromY, k.ToY), frames, w, h, fps)
+}
+
+// fitFilter returns the filters bringing a frame to w x h as described by
+// fit, letterboxing over bg.
+func fitFilter(fit ImageFit, w, h int, bg color.Color) string {
+	switch fit {
+	case FitCover:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d", w, h, w, h)
+	case FitFill:
+		return fmt.Sprintf("scale=%d:%d", w, h)
+	}
+	n := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=0x%02X%02X%02X",
+		w, h, w, h, n.R, n.G, n.B)
+}
+
+// textLayer holds the texts of a Timeline sharing a style, burned in by a
+// single subtitles filter.
+type textLayer struct {
+	style    string
+	captions []Caption
+	// path is the SRT file holding the captions.
+	path string
+}
+
+// textLayers validates texts and groups them by style.
+func textLayers(texts []TextOverlay) ([]textLayer, error) {
+	var layers []textLayer
+	for i, t := range texts {
+		if t.Start < 0 || t.End <= t.Start || strings.TrimSpace(t.Text) == "" {
+			return nil, fmt.Errorf("%w: text %d needs text and an end after its start", ErrInvalidParameters, i+1)
+		}
+		style, err := t.Style.withDefaults()
+		if err != nil {
+			return nil, err
+		}
+		force, err := style.forceStyle()
+		if err != nil {
+			return nil, err
+		}
+		k := slices.IndexFunc(layers, func(l textLayer) bool { return l.style == force })
+		if k < 0 {
+			layers = append(layers, textLayer{style: force})
+			k = len(layers) - 1
+		}
+		layers[k].captions = append(layers[k].captions, Caption{Start: t.Start, End: t.End, Text: t.Text})
+	}
+	return layers, nil
+}
+
+// timelineInput is a clip of a Timeline as read by ffmpeg.
+type timelineInput struct {
+	length time.Duration
+	// audio is set when the clip's own sound is used.
+	audio bool
+}
+
+// timelineFilter returns the filter graph rendering tl and the length of the
+// result. ffmpeg input i holds clip i, with audio tracks following the
+// clips. The output pads are [vout] and [aout].
+func timelineFilter(tl Timeline, transitions []Transition, clips []timelineInput, tracks []mixInput, layers []textLayer) (string, time.Duration, error) {
+	var (
+		graph                []string
+		videoPads, audioPads []string
+		joined               = make([]clipInfo, len(clips))
+		total                time.Duration
+	)
+	for i, c := range tl.Clips {
+		length := seconds(clips[i].length)
+		fit := fitFilter(c.Fit, tl.Width, tl.Height, tl.Background)
+		if c.KenBurns != nil {
+			frames := max(int(math.Round(clips[i].length.Seconds()*tl.FPS)), 1)
+			fit = fitFilter(c.Fit, tl.Width*kenBurnsScale, tl.Height*kenBurnsScale, tl.Background) + "," +
+				c.KenBurns.filter(tl.Width, tl.Height, frames, tl.FPS)
+		}
+		video, audio := fmt.Sprintf("[c%dv]", i), fmt.Sprintf("[c%da]", i)
+		graph = append(graph, fmt.Sprintf("[%d:v]%s,setsar=1,fps=%g,format=yuv420p,trim=duration=%s,setpts=PTS-STARTPTS%s",
+			i, fit, tl.FPS, length, video))
+		// Clips without sound get silence, so that every clip can be joined
+		// and crossfaded the same way.
+		if clips[i].audio {
+			graph = append(graph, fmt.Sprintf("[%d:a]%s,apad,atrim=0:%s,asetpts=PTS-STARTPTS%s", i, timelineAudioFormat, length, audio))
+		} else {
+			graph = append(graph, fmt.Sprintf("anullsrc=r=48000:cl=stereo,%s,atrim=0:%s%s", timelineAudioFormat, length, audio))
+		}
+		videoPads, audioPads = append(videoPads, video), append(audioPads, audio)
+		joined[i] = clipInfo{Duration: clips[i].length.Seconds(), HasAudio: true}
+		total += clips[i].length
+	}
+	for _, t := range transitions {
+		total -= t.Duration
+	}
+	join, err := joinFilter(videoPads, audioPads, joined, transitions)
+	if err != nil {
+		return "", 0, err
+	}
+	graph = append(graph, join)
+
+	text := "null"
+	if len(layers) > 0 {
+		filters := make([]string, len(layers))
+		for i, l := range layers {
+			filters[i] = fmt.Sprintf("subtitles=filename=%s:force_style='%s'", escapeFilterPath(l.path), l.style)
+		}
+		text = strings.Join(filters, ",")
+	}
+	graph = append(graph, "[v]"+text+"[vout]")
+
+	for i, t := range tracks {
+		if t.Start >= total {
+			return "", 0, fmt.Errorf("%w: audio track %d starts after the timeline ends", ErrInvalidParameters, i+1)
+		}
+	}
+	graph = append(graph, mixFilter(tracks, len(clips), "[a]", MixOptions{OriginalVolume: tl.ClipVolume, Ducking: tl.Ducking}, total))
+	return strings.Join(graph, ";"), total, nil
+}
+
+// kenBurnsFromOption accepts true for the default Ken Burns effect or a map
+// with start_zoom, end_zoom, from_x, from_y, to_x and to_y.
+func kenBurnsFromOption(key string, v any) (*KenBurns, error) {
+	if m, ok := v.(map[string]any); ok {
+		var kb KenBurns
+		fields := map[string]*float64{
+			"start_zoom": &kb.StartZoom,
+			"end_zoom":   &kb.EndZoom,
+			"from_x":     &kb.FromX,
+			"from_y":     &kb.FromY,
+			"to_x":       &kb.ToX,
+			"to_y":       &kb.ToY,
+		}
+		for k, val := range m {
+			field, ok := fields[k]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown Ken Burns option %s", ErrInvalidParameters, k)
+			}
+			var err error
+			if *field, err = optionFloat(key+"."+k, val); err != nil {
+				return nil, err
+			}
+		}
+		return &kb, nil
+	}
+	on, err := optionBool(key, v)
+	if err != nil || !on {
+		return nil, err
+	}
+	return &KenBurns{}, nil
+}
+
+// textOverlaysFromOption reads a list of texts, each a map with "text",
+// "start" and "end" in seconds and the caption style settings.
+func textOverlaysFromOption(key string, v any) ([]TextOverlay, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, key, v)
+	}
+	texts := make([]TextOverlay, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: text %d must be a map, got %T", ErrInvalidParameters, i+1, item)
+		}
+		for k, v := range m {
+			var err error
+			switch k {
+			case "text":
+				texts[i].Text, err = optionString(k, v)
+			case "start":
+				texts[i].Start, err = optionSeconds(k, v)
+			case "end":
+				texts[i].End, err = optionSeconds(k, v)
+			default:
+				if ok, err = captionStyleFromOption(&texts[i].Style, k, v); !ok {
+					err = fmt.Errorf("%w: unknown text option %s", ErrInvalidParameters, k)
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("text %d: %w", i+1, err)
+			}
+		}
+	}
+	return texts, nil
+}
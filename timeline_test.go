@@ -0,0 +1,193 @@
+package genailib
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimelineFilter(t *testing.T) {
+	tl, transitions, err := Timeline{
+		Width: 640, Height: 360,
+		Clips: []TimelineClip{
+			{Video: []byte("v"), Fit: FitCover},
+			{Image: []byte("i"), Duration: 2 * time.Second},
+		},
+	}.withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults returned error: %v", err)
+	}
+	clips := []timelineInput{{length: 3 * time.Second, audio: true}, {length: 2 * time.Second}}
+	got, total, err := timelineFilter(tl, transitions, clips, nil, nil)
+	if err != nil {
+		t.Fatalf("timelineFilter returned error: %v", err)
+	}
+	want := "[0:v]scale=640:360:force_original_aspect_ratio=increase,crop=640:360,setsar=1,fps=30,format=yuv420p,trim=duration=3.000,setpts=PTS-STARTPTS[c0v];" +
+		"[0:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,apad,atrim=0:3.000,asetpts=PTS-STARTPTS[c0a];" +
+		"[1:v]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2:color=0x000000,setsar=1,fps=30,format=yuv420p,trim=duration=2.000,setpts=PTS-STARTPTS[c1v];" +
+		"anullsrc=r=48000:cl=stereo,aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,atrim=0:2.000[c1a];" +
+		"[c0v][c1v]concat=n=2:v=1:a=0[v];[c0a][c1a]concat=n=2:v=0:a=1[a];" +
+		"[v]null[vout];" +
+		"[a]aformat=sample_rates=48000:channel_layouts=stereo,volume=1[orig];[orig]anull[mix];[mix]apad[aout]"
+	if got != want {
+		t.Fatalf("unexpected filter\n got %s\nwant %s", got, want)
+	}
+	if total != 5*time.Second {
+		t.Fatalf("unexpected length %v", total)
+	}
+
+	tl.Clips[0].Transition = Transition{Type: TransitionFade}
+	tl.Clips[1].KenBurns = &KenBurns{ToX: 0.25}
+	tl.Ducking = &Ducking{}
+	tl.Texts = []TextOverlay{{Text: "Hi", End: time.Second}, {Text: "Bye", Start: 3 * time.Second, End: 4 * time.Second}}
+	tl, transitions, err = tl.withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults returned error: %v", err)
+	}
+	layers, err := textLayers(tl.Texts)
+	if err != nil {
+		t.Fatalf("textLayers returned error: %v", err)
+	}
+	if len(layers) != 1 || len(layers[0].captions) != 2 {
+		t.Fatalf("expected texts sharing a style in one layer, got %+v", layers)
+	}
+	layers[0].path = "/tmp/texts0.srt"
+	tracks := []mixInput{{AudioTrack: AudioTrack{Role: AudioVoice, Start: time.Second}, duration: 2 * time.Second}}
+	got, total, err = timelineFilter(tl, transitions, clips, tracks, layers)
+	if err != nil {
+		t.Fatalf("timelineFilter returned error: %v", err)
+	}
+	for _, part := range []string{
+		"scale=2560:1440:force_original_aspect_ratio=decrease,pad=2560:1440",
+		"zoompan=z='(1+0.2*on/59)':x='iw*(0.5+(0+0.25*on/59))-iw/zoom/2':y='ih*(0.5+(0+0*on/59))-ih/zoom/2':d=60:s=640x360:fps=30",
+		"[c0v][c1v]xfade=transition=fade:duration=1:offset=2[v]",
+		"[c0a][c1a]acrossfade=d=1[a]",
+		"[v]subtitles=filename=/tmp/texts0.srt:force_style='FontName=Sans,",
+		"[2:a]aformat=sample_rates=48000:channel_layouts=stereo,atrim=0:2.000,volume=1,adelay=1000:all=1[t1]",
+		"[sc]apad[scp];[bed][scp]sidechaincompress",
+	} {
+		if !strings.Contains(got, part) {
+			t.Errorf("filter lacks %s:\n%s", part, got)
+		}
+	}
+	if total != 4*time.Second {
+		t.Fatalf("unexpected length %v", total)
+	}
+
+	tracks[0].Start = 4 * time.Second
+	if _, _, err := timelineFilter(tl, transitions, clips, tracks, layers); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for a track after the end, got %v", err)
+	}
+}
+
+func TestTimelineInvalid(t *testing.T) {
+	video, img := []byte("video"), testPNG(t, 64, 48, color.White)
+	cases := map[string]Timeline{
+		"no clips":        {},
+		"odd size":        {Width: 641, Height: 360, Clips: []TimelineClip{{Video: video}}},
+		"empty clip":      {Clips: []TimelineClip{{}}},
+		"video and image": {Clips: []TimelineClip{{Video: video, Image: img}}},
+		"fit":             {Clips: []TimelineClip{{Video: video, Fit: "stretch"}}},
+		"video duration":  {Clips: []TimelineClip{{Video: video, Duration: time.Second}}},
+		"image end":       {Clips: []TimelineClip{{Image: img, End: time.Second}}},
+		"end before":      {Clips: []TimelineClip{{Video: video, Start: 2 * time.Second, End: time.Second}}},
+		"zoom":            {Clips: []TimelineClip{{Image: img, KenBurns: &KenBurns{EndZoom: 0.5}}}},
+		"offset":          {Clips: []TimelineClip{{Image: img, KenBurns: &KenBurns{ToX: 0.75}}}},
+		"transition":      {Clips: []TimelineClip{{Video: video, Transition: Transition{Type: "spin"}}, {Video: video}}},
+		"last transition": {Clips: []TimelineClip{{Video: video, Transition: Transition{Type: TransitionFade}}}},
+		"audio":           {Clips: []TimelineClip{{Video: video}}, Audio: []AudioTrack{{Audio: video, Volume: -1}}},
+		"ducking":         {Clips: []TimelineClip{{Video: video}}, Ducking: &Ducking{Ratio: 50}},
+		"text":            {Clips: []TimelineClip{{Video: video}}, Texts: []TextOverlay{{Text: "Hi", Start: time.Second}}},
+	}
+	for name, tl := range cases {
+		if _, err := RenderTimeline(tl); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("%s: expected ErrInvalidParameters, got %v", name, err)
+		}
+	}
+}
+
+func TestRenderTimeline(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	img := testPNG(t, 64, 48, color.RGBA{0, 128, 0, 255})
+	red, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	blue, err := createColorVideo("blue")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	tone, err := createToneAudio()
+	if err != nil {
+		t.Fatalf("failed to create audio: %v", err)
+	}
+
+	out, err := RenderTimeline(Timeline{
+		Width: 320, Height: 180, FPS: 25, Background: color.White,
+		Clips: []TimelineClip{
+			{Video: red, Transition: Transition{Type: TransitionFade, Duration: 500 * time.Millisecond}},
+			{Image: img, Duration: time.Second, KenBurns: &KenBurns{EndZoom: 1.5}},
+			{Video: blue, Start: 200 * time.Millisecond, Fit: FitCover},
+		},
+		Audio:   []AudioTrack{{Audio: tone, Role: AudioVoice, Loop: true}},
+		Ducking: &Ducking{},
+	})
+	if err != nil {
+		t.Fatalf("RenderTimeline returned error: %v", err)
+	}
+	info, err := ProbeMedia(out)
+	if err != nil {
+		t.Fatalf("ProbeMedia returned error: %v", err)
+	}
+	if info.Video == nil || info.Video.Width != 320 || info.Video.Height != 180 || info.Audio == nil {
+		t.Fatalf("unexpected streams %+v", info)
+	}
+	if d := info.Duration; d < 2*time.Second || d > 2600*time.Millisecond {
+		t.Fatalf("unexpected duration %v", d)
+	}
+}
+
+func TestWorkflowRenderTimeline(t *testing.T) {
+	f := fakeFFmpeg(t)
+	svc := NewWorkflowService(WithFFmpeg(f))
+	inputs := map[string]any{"clip": []byte("video"), "still": testPNG(t, 64, 48, color.White)}
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "cut",
+		FunctionType: FunctionTypeRenderTimeline,
+		Options: map[string]any{
+			"width": 640, "height": 360, "background": "#ffffff",
+			"clips": []any{
+				map[string]any{"video": "clip", "end": 0.5, "mute": true,
+					"transition": map[string]any{"type": "fade", "duration": 0.25}},
+				map[string]any{"image": "still", "duration": 2, "ken_burns": map[string]any{"end_zoom": 1.5}},
+			},
+			"texts": []any{map[string]any{"text": "Hello", "end": 1, "position": "top"}},
+		},
+	}}}
+	res, _, err := svc.Generate(context.Background(), wf, inputs)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if art, ok := res.(*Artifact); !ok || string(art.Data) != "fake:-ss" {
+		t.Fatalf("unexpected result %#v", res)
+	}
+
+	for _, opts := range []map[string]any{
+		{"clips": []any{map[string]any{"video": "clip", "speed": 2}}},
+		{"clips": []any{map[string]any{"duration": 2}}},
+		{"clips": []any{map[string]any{"image": "still", "ken_burns": map[string]any{"zoom": 2}}}},
+		{"clips": []any{map[string]any{"video": "clip"}}, "texts": []any{map[string]any{"text": "Hi", "end": 1, "shadow": 2}}},
+		{"clips": []any{map[string]any{"video": "clip"}}, "resolution": "hd"},
+	} {
+		wf := &Workflow{Steps: []WorkflowStep{{ID: "cut", FunctionType: FunctionTypeRenderTimeline, Options: opts}}}
+		if _, _, err := svc.Generate(context.Background(), wf, inputs); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %v, got %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package genailib
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TransitionType names an ffmpeg xfade transition.
+type TransitionType string
+
+// Transition types. TransitionCut joins clips without a transition.
+const (
+	TransitionCut        TransitionType = "cut"
+	TransitionFade       TransitionType = "fade"
+	TransitionFadeBlack  TransitionType = "fadeblack"
+	TransitionFadeWhite  TransitionType = "fadewhite"
+	TransitionWipeLeft   TransitionType = "wipeleft"
+	TransitionWipeRight  TransitionType = "wiperight"
+	TransitionWipeUp     TransitionType = "wipeup"
+	TransitionWipeDown   TransitionType = "wipedown"
+	TransitionSlideLeft  TransitionType = "slideleft"
+	TransitionSlideRight TransitionType = "slideright"
+	TransitionSlideUp    TransitionType = "slideup"
+	TransitionSlideDown  TransitionType = "slidedown"
+	TransitionDissolve   TransitionType = "dissolve"
+)
+
+var transitionTypes = []TransitionType{
+	TransitionCut,
+	TransitionFade,
+	TransitionFadeBlack,
+	TransitionFadeWhite,
+	TransitionWipeLeft,
+	TransitionWipeRight,
+	TransitionWipeUp,
+	TransitionWipeDown,
+	TransitionSlideLeft,
+	TransitionSlideRight,
+	TransitionSlideUp,
+	TransitionSlideDown,
+	TransitionDissolve,
+}
+
+// DefaultTransitionDuration is used for transitions without a duration.
+const DefaultTransitionDuration = time.Second
+
+// Transition describes how one clip turns into the next. The clips overlap
+// for Duration, so the merged video is shorter than the clips combined.
+type Transition struct {
+	Type     TransitionType
+	Duration time.Duration
+}
+
+// MergeOptions configures MergeVideosWithOptions.
+type MergeOptions struct {
+	// Transition is used at every boundary without its own transition. The
+	// zero value is a hard cut.
+	Transition Transition
+	// Transitions holds one transition per boundary, the first between the
+	// first and second clip. Zero values fall back to Transition.
+	Transitions []Transition
+}
+
+// boundaries returns the transition for each of the n-1 boundaries between
+// n clips, with defaults applied.
+func (o MergeOptions) boundaries(n int) ([]Transition, error) {
+	if len(o.Transitions) > n-1 {
+		return nil, fmt.Errorf("%w: %d transitions for %d videos", ErrInvalidParameters, len(o.Transitions), n)
+	}
+	out := make([]Transition, n-1)
+	for i := range out {
+		t := o.Transition
+		if i < len(o.Transitions) && o.Transitions[i] != (Transition{}) {
+			t = o.Transitions[i]
+		}
+		if t.Type == "" {
+			t.Type = TransitionCut
+		}
+		if !slices.Contains(transitionTypes, t.Type) {
+			return nil, fmt.Errorf("%w: unknown transition %q", ErrInvalidParameters, t.Type)
+		}
+		switch {
+		case t.Type == TransitionCut:
+			t.Duration = 0
+		case t.Duration == 0:
+			t.Duration = DefaultTransitionDuration
+		case t.Duration < 0:
+			return nil, fmt.Errorf("%w: negative transition duration", ErrInvalidParameters)
+		}
+		out[i] = t
+	}
+	return out, nil
+}
+
+// hasTransitions reports whether any boundary is more than a hard cut.
+func hasTransitions(transitions []Transition) bool {
+	return slices.ContainsFunc(transitions, func(t Transition) bool { return t.Type != TransitionCut })
+}
+
+// transitionFilter returns an ffmpeg filter graph joining clips, which must
+// share resolution, frame rate and audio layout, with transitions. The
+// output pads are [v] and, when the clips have audio, [a].
+func transitionFilter(clips []clipInfo, transitions []Transition) (string, error) {
+	video, audio := make([]string, len(clips)), make([]string, len(clips))
+	for i := range clips {
+		video[i], audio[i] = fmt.Sprintf("[%d:v]", i), fmt.Sprintf("[%d:a]", i)
+	}
+	return joinFilter(video, audio, clips, transitions)
+}
+
+// joinFilter is transitionFilter for clips read from the labelled pads
+// video and audio.
+func joinFilter(videoPads, audioPads []string, clips []clipInfo, transitions []Transition) (string, error) {
+	audio := clips[0].HasAudio
+	if len(transitions) == 0 {
+		graph := videoPads[0] + "null[v]"
+		if audio {
+			graph += ";" + audioPads[0] + "anull[a]"
+		}
+		return graph, nil
+	}
+	var graph []string
+	video, sound := videoPads[0], audioPads[0]
+	length := clips[0].Duration
+	for i, t := range transitions {
+		next := clips[i+1]
+		outV, outA := fmt.Sprintf("[v%d]", i+1), fmt.Sprintf("[a%d]", i+1)
+		if i == len(transitions)-1 {
+			outV, outA = "[v]", "[a]"
+		}
+		if t.Type == TransitionCut {
+			graph = append(graph, fmt.Sprintf("%s%sconcat=n=2:v=1:a=0%s", video, videoPads[i+1], outV))
+			if audio {
+				graph = append(graph, fmt.Sprintf("%s%sconcat=n=2:v=0:a=1%s", sound, audioPads[i+1], outA))
+			}
+			length += next.Duration
+		} else {
+			d := t.Duration.Seconds()
+			if d >= length || d >= next.Duration {
+				return "", fmt.Errorf("%w: %s transition %d of %gs is longer than its clips", ErrInvalidParameters, t.Type, i+1, d)
+			}
+			graph = append(graph, fmt.Sprintf("%s%sxfade=transition=%s:duration=%g:offset=%g%s", video, videoPads[i+1], t.Type, d, length-d, outV))
+			if audio {
+				graph = append(graph, fmt.Sprintf("%s%sacrossfade=d=%g%s", sound, audioPads[i+1], d, outA))
+			}
+			length += next.Duration - d
+		}
+		video, sound = outV, outA
+	}
+	return strings.Join(graph, ";"), nil
+}
+
+// mergeOptionsFromOptions reads the options of a videos_to_video step:
+// "transition" and "duration" (in seconds) set the default transition, and
+// "transitions" lists one transition per boundary, each either a type or a
+// map with "type" and "duration".
+func mergeOptionsFromOptions(options map[string]any) (MergeOptions, error) {
+	var opts MergeOptions
+	for k, v := range options {
+		var err error
+		switch k {
+		case "transition":
+			var t string
+			t, err = optionString(k, v)
+			opts.Transition.Type = TransitionType(t)
+		case "duration":
+			opts.Transition.Duration, err = optionSeconds(k, v)
+		case "transitions":
+			items, ok := v.([]any)
+			if !ok {
+				return MergeOptions{}, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, k, v)
+			}
+			for _, item := range items {
+				var t Transition
+				if t, err = transitionFromOption(k, item); err != nil {
+					break
+				}
+				opts.Transitions = append(opts.Transitions, t)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown merge option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return MergeOptions{}, err
+		}
+	}
+	if opts.Transition.Type == "" && opts.Transition.Duration > 0 {
+		opts.Transition.Type = TransitionFade
+	}
+	return opts, nil
+}
+
+func transitionFromOption(key string, v any) (Transition, error) {
+	switch item := v.(type) {
+	case string:
+		return Transition{Type: TransitionType(item)}, nil
+	case map[string]any:
+		var t Transition
+		for k, v := range item {
+			var err error
+			switch k {
+			case "type":
+				var s string
+				s, err = optionString(key+"."+k, v)
+				t.Type = TransitionType(s)
+			case "duration":
+				t.Duration, err = optionSeconds(key+"."+k, v)
+			default:
+				err = fmt.Errorf("%w: unknown transition option %s", ErrInvalidParameters, k)
+			}
+			if err != nil {
+				return Transition{}, err
+			}
+		}
+		if t.Type == "" {
+			t.Type = TransitionFade
+		}
+		return t, nil
+	}
+	return Transition{}, fmt.Errorf("%w: option %s must hold strings or maps, got %T", ErrInvalidParameters, key, v)
+}
+
+// optionSeconds reads a duration given in seconds.
+func optionSeconds(key string, v any) (time.Duration, error) {
+	f, err := optionFloat(key, v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
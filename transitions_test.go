@@ -0,0 +1,127 @@
+package genailib
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeOptionsBoundaries(t *testing.T) {
+	opts := MergeOptions{
+		Transition:  Transition{Type: TransitionFadeBlack},
+		Transitions: []Transition{{}, {Type: TransitionCut, Duration: time.Second}, {Type: TransitionWipeLeft, Duration: 500 * time.Millisecond}},
+	}
+	got, err := opts.boundaries(5)
+	if err != nil {
+		t.Fatalf("boundaries returned error: %v", err)
+	}
+	want := []Transition{
+		{TransitionFadeBlack, DefaultTransitionDuration},
+		{TransitionCut, 0},
+		{TransitionWipeLeft, 500 * time.Millisecond},
+		{TransitionFadeBlack, DefaultTransitionDuration},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("boundary %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got, _ := (MergeOptions{}).boundaries(3); hasTransitions(got) {
+		t.Fatalf("expected hard cuts by default, got %+v", got)
+	}
+
+	for _, bad := range []MergeOptions{
+		{Transition: Transition{Type: "spin"}},
+		{Transition: Transition{Type: TransitionFade, Duration: -time.Second}},
+		{Transitions: make([]Transition, 3)},
+	} {
+		if _, err := bad.boundaries(3); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %+v, got %v", bad, err)
+		}
+	}
+}
+
+func TestTransitionFilter(t *testing.T) {
+	clips := []clipInfo{{Duration: 5, HasAudio: true}, {Duration: 4, HasAudio: true}, {Duration: 3, HasAudio: true}}
+	filter, err := transitionFilter(clips, []Transition{
+		{TransitionFade, time.Second},
+		{TransitionCut, 0},
+	})
+	if err != nil {
+		t.Fatalf("transitionFilter returned error: %v", err)
+	}
+	want := strings.Join([]string{
+		"[0:v][1:v]xfade=transition=fade:duration=1:offset=4[v1]",
+		"[0:a][1:a]acrossfade=d=1[a1]",
+		"[v1][2:v]concat=n=2:v=1:a=0[v]",
+		"[a1][2:a]concat=n=2:v=0:a=1[a]",
+	}, ";")
+	if filter != want {
+		t.Fatalf("unexpected filter\n got %s\nwant %s", filter, want)
+	}
+
+	// The offset of a later transition accounts for earlier overlaps.
+	clips = []clipInfo{{Duration: 5}, {Duration: 4}, {Duration: 3}}
+	filter, err = transitionFilter(clips, []Transition{{TransitionFade, time.Second}, {TransitionSlideLeft, 2 * time.Second}})
+	if err != nil {
+		t.Fatalf("transitionFilter returned error: %v", err)
+	}
+	if !strings.HasSuffix(filter, "[v1][2:v]xfade=transition=slideleft:duration=2:offset=6[v]") || strings.Contains(filter, "acrossfade") {
+		t.Fatalf("unexpected filter %s", filter)
+	}
+
+	if _, err := transitionFilter(clips, []Transition{{TransitionFade, 4 * time.Second}, {TransitionCut, 0}}); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for a transition longer than a clip, got %v", err)
+	}
+}
+
+func TestMergeOptionsFromOptions(t *testing.T) {
+	opts, err := mergeOptionsFromOptions(map[string]any{
+		"transition":  "fadeblack",
+		"duration":    0.5,
+		"transitions": []any{"wipeleft", map[string]any{"type": "slideup", "duration": 2}},
+	})
+	if err != nil {
+		t.Fatalf("mergeOptionsFromOptions returned error: %v", err)
+	}
+	if opts.Transition != (Transition{TransitionFadeBlack, 500 * time.Millisecond}) ||
+		len(opts.Transitions) != 2 || opts.Transitions[1] != (Transition{TransitionSlideUp, 2 * time.Second}) {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	if opts, _ := mergeOptionsFromOptions(map[string]any{"duration": 1}); opts.Transition.Type != TransitionFade {
+		t.Fatalf("expected a crossfade when only a duration is set, got %+v", opts)
+	}
+	for _, bad := range []map[string]any{
+		{"transitions": "fade"},
+		{"transitions": []any{42}},
+		{"transitions": []any{map[string]any{"speed": 2}}},
+		{"style": "fade"},
+	} {
+		if _, err := mergeOptionsFromOptions(bad); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %v, got %v", bad, err)
+		}
+	}
+}
+
+func TestMergeVideosWithTransitions(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	v1, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create first video: %v", err)
+	}
+	v2, err := createColorVideo("blue")
+	if err != nil {
+		t.Fatalf("failed to create second video: %v", err)
+	}
+	merged, err := AppendVideosWithTransition(v1, v2, Transition{Type: TransitionFade, Duration: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("AppendVideosWithTransition returned error: %v", err)
+	}
+	if len(merged) == 0 {
+		t.Fatalf("merged video is empty")
+	}
+}
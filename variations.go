@@ -0,0 +1,214 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// Variation is one cell of a variation grid: the service to run and,
+// optionally, the seed to run it with.
+type Variation struct {
+	Service string
+	Seed    *int64
+}
+
+// Label describes the variation, e.g. "dall-e-3" or "imagen-3 seed 7".
+func (v Variation) Label() string {
+	if v.Seed == nil {
+		return v.Service
+	}
+	return fmt.Sprintf("%s seed %d", v.Service, *v.Seed)
+}
+
+// VariationResult is the outcome of running one Variation.
+type VariationResult struct {
+	Variation
+	Image *Artifact
+	Err   error
+}
+
+// Variations runs req once per variation, concurrently, and returns the
+// results in the order of variations. A failing variation is reported in
+// its result; an error is returned only when every variation failed.
+func (g *APIGateway) Variations(ctx context.Context, req ImageRequest, variations []Variation) ([]VariationResult, error) {
+	results := make([]VariationResult, len(variations))
+	var wg sync.WaitGroup
+	for i, v := range variations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			one := req
+			one.N = 0
+			one.Seed = v.Seed
+			results[i].Variation = v
+			res, err := g.TextToImage(ctx, one, v.Service)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Image = &res.Images[0]
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Err == nil {
+			return results, nil
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: no variations given", ErrInvalidParameters)
+	}
+	return results, fmt.Errorf("all variations failed: %w", results[0].Err)
+}
+
+// ContactSheet is a labeled grid of variation results.
+type ContactSheet struct {
+	// Image is the composed PNG.
+	Image   Artifact
+	Results []VariationResult
+}
+
+// VariationGrid runs req for every service and seed and composes the results
+// into a contact sheet with a row per service and a column per seed. With no
+// seeds each service runs once without a seed. Failed cells are drawn as grey
+// tiles labeled with the error.
+func (g *APIGateway) VariationGrid(ctx context.Context, req ImageRequest, services []string, seeds []int64) (*ContactSheet, error) {
+	var variations []Variation
+	for _, service := range services {
+		if len(seeds) == 0 {
+			variations = append(variations, Variation{Service: service})
+		}
+		for _, seed := range seeds {
+			variations = append(variations, Variation{Service: service, Seed: &seed})
+		}
+	}
+	results, err := g.Variations(ctx, req, variations)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make([]ContactSheetCell, len(results))
+	for i, r := range results {
+		cells[i].Label = r.Label()
+		if r.Err != nil {
+			cells[i].Label += ": " + r.Err.Error()
+			continue
+		}
+		data := r.Image.Data
+		if len(data) == 0 {
+			if data, err = g.providers.download(ctx, r.Image.URL); err != nil {
+				cells[i].Label += ": " + err.Error()
+				continue
+			}
+		}
+		cells[i].Image = data
+	}
+	sheet, err := ComposeContactSheet(cells, max(len(seeds), 1), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ContactSheet{
+		Image:   Artifact{Data: sheet, MIMEType: "image/png"},
+		Results: results,
+	}, nil
+}
+
+// ContactSheetCell is one labeled image of a contact sheet. Cells without an
+// image are drawn as grey tiles.
+type ContactSheetCell struct {
+	Label string
+	Image []byte
+}
+
+// DefaultContactSheetCellSize is the width and height of a contact sheet
+// thumbnail when none is given.
+const DefaultContactSheetCellSize = 256
+
+const (
+	contactSheetPadding = 8
+	contactSheetLabel   = 20
+)
+
+// ComposeContactSheet lays cells out in a grid with the given number of
+// columns, scaling each image to fit a cellSize square and writing its label
+// underneath, and returns the sheet as a PNG. A columns value of zero makes
+// the grid roughly square and a cellSize of zero uses
+// DefaultContactSheetCellSize. PNG, JPEG, GIF and WEBP images are supported.
+func ComposeContactSheet(cells []ContactSheetCell, columns, cellSize int) ([]byte, error) {
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("%w: no cells to compose", ErrInvalidParameters)
+	}
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(cells)))))
+	}
+	if cellSize <= 0 {
+		cellSize = DefaultContactSheetCellSize
+	}
+	rows := (len(cells) + columns - 1) / columns
+	cellW := cellSize + contactSheetPadding
+	cellH := cellSize + contactSheetLabel + contactSheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellW+contactSheetPadding, rows*cellH+contactSheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	maxChars := cellSize / face.Advance
+	for i, cell := range cells {
+		x := contactSheetPadding + (i%columns)*cellW
+		y := contactSheetPadding + (i/columns)*cellH
+		tile := image.Rect(x, y, x+cellSize, y+cellSize)
+
+		if len(cell.Image) == 0 {
+			draw.Draw(sheet, tile, image.NewUniform(color.Gray{Y: 0xcc}), image.Point{}, draw.Src)
+		} else {
+			img, _, err := image.Decode(bytes.NewReader(cell.Image))
+			if err != nil {
+				return nil, fmt.Errorf("decode image of cell %d: %w", i, err)
+			}
+			draw.CatmullRom.Scale(sheet, fitRect(img.Bounds(), tile), img, img.Bounds(), draw.Over, nil)
+		}
+
+		label := cell.Label
+		if len(label) > maxChars {
+			label = label[:max(maxChars-3, 0)] + "..."
+		}
+		d := font.Drawer{
+			Dst:  sheet,
+			Src:  image.Black,
+			Face: face,
+			Dot:  fixed.P(x, y+cellSize+face.Ascent+4),
+		}
+		d.DrawString(label)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitRect returns the largest rectangle with the aspect ratio of src that
+// fits in dst, centred.
+func fitRect(src, dst image.Rectangle) image.Rectangle {
+	scale := min(float64(dst.Dx())/float64(src.Dx()), float64(dst.Dy())/float64(src.Dy()))
+	w := int(float64(src.Dx()) * scale)
+	h := int(float64(src.Dy()) * scale)
+	x := dst.Min.X + (dst.Dx()-w)/2
+	y := dst.Min.Y + (dst.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
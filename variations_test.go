@@ -0,0 +1,131 @@
+package genailib
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGatewayMultipleImagesWithSeeds(t *testing.T) {
+	var seeds []any
+	rep := fakeReplicateServer(t, []string{"https://example.com/a.png", "https://example.com/b.png"}, func(input map[string]any) {
+		seeds = append(seeds, input["seed"])
+		if input["seed"] != nil && input["num_outputs"] != nil {
+			t.Errorf("seeded requests must generate one image each: %v", input)
+		}
+	})
+	g := NewAPIGateway(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+	ctx := context.Background()
+
+	seed := int64(10)
+	res, err := g.TextToImage(ctx, ImageRequest{Prompt: "a cat", N: 3, Seed: &seed}, "owner/model")
+	if err != nil {
+		t.Fatalf("TextToImage returned error: %v", err)
+	}
+	if len(seeds) != 3 || seeds[0] != float64(10) || seeds[2] != float64(12) {
+		t.Fatalf("unexpected seeds sent: %v", seeds)
+	}
+	if len(res.Images) != 6 || *res.Images[0].Seed != 10 || *res.Images[5].Seed != 12 || res.Usage.Requests != 3 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	res, err = g.TextToImage(ctx, ImageRequest{Prompt: "a cat", N: 2}, "owner/model")
+	if err != nil {
+		t.Fatalf("TextToImage returned error: %v", err)
+	}
+	if len(res.Images) != 2 || res.Images[1].URL != "https://example.com/b.png" || res.Usage.Requests != 1 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+}
+
+func TestGatewayRepeatsRequestsWithoutNativeN(t *testing.T) {
+	calls := 0
+	srv := fakeOpenAIServer(t, func(body map[string]any) {
+		calls++
+		if body["n"] != float64(1) {
+			t.Errorf("unexpected n: %v", body["n"])
+		}
+	})
+	g := NewAPIGateway(WithOpenAIKey("test"), WithOpenAIBaseURL(srv.URL))
+	res, err := g.TextToImage(context.Background(), ImageRequest{Prompt: "a cat", N: 2}, ProviderDallE3)
+	if err != nil {
+		t.Fatalf("TextToImage returned error: %v", err)
+	}
+	if calls != 2 || len(res.Images) != 2 || res.Usage.Spend != 2*estimatedPrice(ProviderDallE3) {
+		t.Fatalf("unexpected response after %d calls: %+v", calls, res)
+	}
+}
+
+func TestComposeContactSheet(t *testing.T) {
+	cells := []ContactSheetCell{
+		{Label: "red", Image: testPNG(t, 40, 20, color.RGBA{R: 255, A: 255})},
+		{Label: "blue", Image: testPNG(t, 20, 40, color.RGBA{B: 255, A: 255})},
+		{Label: "failed with a label far too long to fit under the tile"},
+	}
+	data, err := ComposeContactSheet(cells, 2, 32)
+	if err != nil {
+		t.Fatalf("ComposeContactSheet returned error: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode sheet: %v", err)
+	}
+	b := img.Bounds()
+	if b.Dx() != 2*(32+8)+8 || b.Dy() != 2*(32+20+8)+8 {
+		t.Fatalf("unexpected sheet size %v", b)
+	}
+	if r, _, _, _ := img.At(8+16, 8+16).RGBA(); r>>8 != 255 {
+		t.Fatalf("expected the first cell to be red, got %v", img.At(8+16, 8+16))
+	}
+
+	if _, err := ComposeContactSheet([]ContactSheetCell{{Image: []byte("nope")}}, 0, 0); err == nil {
+		t.Fatal("expected error for undecodable image")
+	}
+}
+
+func TestVariationGrid(t *testing.T) {
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG(t, 8, 8, color.White))
+	}))
+	t.Cleanup(images.Close)
+	rep := fakeReplicateServer(t, images.URL+"/out.png", nil)
+	g := NewAPIGateway(WithReplicateToken("test"), WithReplicateBaseURL(rep.URL))
+
+	sheet, err := g.VariationGrid(context.Background(), ImageRequest{Prompt: "a cat"}, []string{"owner/model", "no-such-model"}, []int64{1, 2})
+	if err != nil {
+		t.Fatalf("VariationGrid returned error: %v", err)
+	}
+	if len(sheet.Results) != 4 || sheet.Results[1].Label() != "owner/model seed 2" {
+		t.Fatalf("unexpected results: %+v", sheet.Results)
+	}
+	if sheet.Results[0].Err != nil || sheet.Results[2].Err == nil {
+		t.Fatalf("unexpected errors: %v, %v", sheet.Results[0].Err, sheet.Results[2].Err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(sheet.Image.Data))
+	if err != nil {
+		t.Fatalf("decode sheet: %v", err)
+	}
+	if cfg.Width != 2*(DefaultContactSheetCellSize+8)+8 {
+		t.Fatalf("expected two columns, got width %d", cfg.Width)
+	}
+}
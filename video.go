@@ -2,121 +2,254 @@ package genailib
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
 // AppendVideos takes two video byte slices and appends the second video to the first.
 // It returns the merged video as a byte slice using ffmpeg under the hood.
+// Both videos must have the same resolution. ffmpeg and ffprobe must be
+// installed and accessible on the system PATH.
+// Use AppendVideosWithTransition to blend the clips instead of cutting.
 func AppendVideos(video1, video2 []byte) ([]byte, error) {
-	tmpDir, err := os.MkdirTemp("", "mergevideo")
+	j, err := defaultFFmpeg.newJob(context.Background(), "mergevideo")
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create temp dir")
+		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+	defer j.close()
 
-	input1 := filepath.Join(tmpDir, "input1.mp4")
-	input2 := filepath.Join(tmpDir, "input2.mp4")
-	output := filepath.Join(tmpDir, "output.mp4")
-
-	if err := os.WriteFile(input1, video1, 0o600); err != nil {
-		return nil, errors.Wrap(err, "failed to write first video")
+	input1, err := j.write("input1.mp4", video1)
+	if err != nil {
+		return nil, err
 	}
-	if err := os.WriteFile(input2, video2, 0o600); err != nil {
-		return nil, errors.Wrap(err, "failed to write second video")
+	input2, err := j.write("input2.mp4", video2)
+	if err != nil {
+		return nil, err
 	}
+	output := j.path("output.mp4")
+	if err := j.appendVideos(input1, input2, output); err != nil {
+		return nil, err
+	}
+	return j.read(output)
+}
 
-	cmd := exec.Command("ffmpeg", "-i", input1, "-i", input2, "-filter_complex", "[0:v][1:v]concat=n=2:v=1[out]", "-map", "[out]", "-y", output)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("ffmpeg run error: %w, %s", err, stderr.String())
+// AppendVideoFiles is AppendVideos for the video files at first and second,
+// writing the result to out.
+func (f *FFmpeg) AppendVideoFiles(ctx context.Context, first, second, out string) error {
+	for _, path := range []string{first, second} {
+		if err := f.checkInput(path); err != nil {
+			return err
+		}
+	}
+	j, err := f.newJob(ctx, "mergevideo")
+	if err != nil {
+		return err
 	}
+	defer j.close()
+	if err := j.appendVideos(first, second, out); err != nil {
+		return err
+	}
+	return f.checkOutput(out)
+}
 
-	merged, err := os.ReadFile(output)
+func (j *job) appendVideos(input1, input2, output string) error {
+	info1, err := j.probe(input1, "first video", true, false)
+	if err != nil {
+		return err
+	}
+	info2, err := j.probe(input2, "second video", true, false)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read merged video")
+		return err
 	}
+	if info1.Video.Width != info2.Video.Width || info1.Video.Height != info2.Video.Height {
+		return fmt.Errorf("%w: videos differ in resolution (%dx%d and %dx%d), use MergeVideos to normalize them",
+			ErrInvalidParameters, info1.Video.Width, info1.Video.Height, info2.Video.Width, info2.Video.Height)
+	}
+	return j.run("-i", input1, "-i", input2, "-filter_complex", "[0:v][1:v]concat=n=2:v=1[out]", "-map", "[out]", "-y", output)
+}
 
-	return merged, nil
+// AppendVideosWithTransition appends video2 to video1, blending them with t.
+func AppendVideosWithTransition(video1, video2 []byte, t Transition) ([]byte, error) {
+	return MergeVideosWithOptions([][]byte{video1, video2}, MergeOptions{Transition: t})
 }
 
 // MergeVideos concatenates multiple MP4 video clips into a single video using
-// ffmpeg. The input videos must all be encoded with compatible codecs for the
-// concat demuxer to work correctly.
+// ffmpeg. Clips that share codecs, resolution, frame rate, pixel format and
+// audio layout are joined without re-encoding. Otherwise every clip is first
+// re-encoded to the resolution and frame rate of the first clip, letterboxed
+// where the aspect ratio differs, with silent audio added to clips without
+// sound. ffmpeg and ffprobe must be installed and accessible on the system
+// PATH.
 func MergeVideos(videos [][]byte) ([]byte, error) {
+	return MergeVideosWithOptions(videos, MergeOptions{})
+}
+
+// MergeVideosWithOptions is MergeVideos with transitions between clips.
+// Transitions other than hard cuts are rendered with ffmpeg's xfade and
+// acrossfade filters, which always re-encodes the clips.
+func MergeVideosWithOptions(videos [][]byte, opts MergeOptions) ([]byte, error) {
+	return defaultFFmpeg.mergeVideos(context.Background(), videos, opts)
+}
+
+func (f *FFmpeg) mergeVideos(ctx context.Context, videos [][]byte, opts MergeOptions) ([]byte, error) {
 	if len(videos) == 0 {
 		return nil, errors.New("no videos provided")
 	}
-
-	tmpDir, err := os.MkdirTemp("", "mergevideos")
+	j, err := f.newJob(ctx, "mergevideos")
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create temp dir")
+		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
-
-	listFile := filepath.Join(tmpDir, "inputs.txt")
-	output := filepath.Join(tmpDir, "output.mp4")
+	defer j.close()
 
-	var list bytes.Buffer
+	paths := make([]string, len(videos))
 	for i, data := range videos {
-		filePath := filepath.Join(tmpDir, fmt.Sprintf("input%d.mp4", i))
-		if err := os.WriteFile(filePath, data, 0o600); err != nil {
-			return nil, errors.Wrapf(err, "failed to write video %d", i)
+		if paths[i], err = j.write(fmt.Sprintf("input%d.mp4", i), data); err != nil {
+			return nil, err
 		}
-		fmt.Fprintf(&list, "file '%s'\n", filePath)
 	}
-
-	if err := os.WriteFile(listFile, list.Bytes(), 0o600); err != nil {
-		return nil, errors.Wrap(err, "failed to write list file")
+	output := j.path("output.mp4")
+	if err := j.merge(paths, output, opts); err != nil {
+		return nil, err
 	}
+	return j.read(output)
+}
 
-	cmd := exec.Command("ffmpeg", "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", "-y", output)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("ffmpeg run error: %w, %s", err, stderr.String())
+// MergeVideoFiles is MergeVideosWithOptions for the video files at paths,
+// writing the merged video to out. Clips that can be joined without
+// re-encoding are never loaded into memory, which suits long videos.
+func (f *FFmpeg) MergeVideoFiles(ctx context.Context, paths []string, out string, opts MergeOptions) error {
+	if len(paths) == 0 {
+		return errors.New("no videos provided")
+	}
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		if err := f.checkInput(path); err != nil {
+			return err
+		}
+		var err error
+		if abs[i], err = filepath.Abs(path); err != nil {
+			return errors.Wrapf(err, "failed to resolve video %d", i)
+		}
 	}
+	j, err := f.newJob(ctx, "mergevideos")
+	if err != nil {
+		return err
+	}
+	defer j.close()
+	if err := j.merge(abs, out, opts); err != nil {
+		return err
+	}
+	return f.checkOutput(out)
+}
 
-	merged, err := os.ReadFile(output)
+// merge joins the clips at paths into output, normalizing them in the job's
+// directory where needed.
+func (j *job) merge(paths []string, output string, opts MergeOptions) error {
+	transitions, err := opts.boundaries(len(paths))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read merged video")
+		return err
+	}
+	fade := hasTransitions(transitions)
+	paths, clips, err := j.normalizeClips(paths, fade)
+	if err != nil {
+		return err
+	}
+
+	if fade {
+		target := normalizeTarget(clips)
+		for i := range clips {
+			clips[i].HasAudio = target.HasAudio
+		}
+		filter, err := transitionFilter(clips, transitions)
+		if err != nil {
+			return err
+		}
+		var args []string
+		for _, path := range paths {
+			args = append(args, "-i", path)
+		}
+		args = append(args, "-filter_complex", filter, "-map", "[v]")
+		if target.HasAudio {
+			args = append(args, "-map", "[a]", "-c:a", "aac")
+		}
+		args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-y", output)
+		return j.run(args...)
 	}
 
-	return merged, nil
+	var list bytes.Buffer
+	for _, path := range paths {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(path, "'", `'\''`))
+	}
+	listFile := j.path("inputs.txt")
+	if err := os.WriteFile(listFile, list.Bytes(), 0o600); err != nil {
+		return errors.Wrap(err, "failed to write list file")
+	}
+	return j.run("-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", "-y", output)
 }
 
 // AddAudioToVideo adds the given audio track to a video clip. If the audio is
 // shorter than the video, it will be looped until the video ends. If the audio
 // is longer, it will be truncated to match the video's duration. The resulting
-// video with audio is returned as a byte slice. ffmpeg must be installed and
-// accessible on the system PATH.
+// video with audio is returned as a byte slice. ffmpeg and ffprobe must be
+// installed and accessible on the system PATH.
 func AddAudioToVideo(video, audio []byte) ([]byte, error) {
-	tmpDir, err := os.MkdirTemp("", "addaudio")
+	return defaultFFmpeg.addAudio(context.Background(), video, audio)
+}
+
+func (f *FFmpeg) addAudio(ctx context.Context, video, audio []byte) ([]byte, error) {
+	j, err := f.newJob(ctx, "addaudio")
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create temp dir")
+		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+	defer j.close()
 
-	vidFile := filepath.Join(tmpDir, "input.mp4")
-	audFile := filepath.Join(tmpDir, "input.mp3")
-	outFile := filepath.Join(tmpDir, "output.mp4")
+	vidFile, err := j.write("input.mp4", video)
+	if err != nil {
+		return nil, err
+	}
+	audFile, err := j.write("input.mp3", audio)
+	if err != nil {
+		return nil, err
+	}
+	outFile := j.path("output.mp4")
+	if err := j.addAudio(vidFile, audFile, outFile); err != nil {
+		return nil, err
+	}
+	return j.read(outFile)
+}
 
-	if err := os.WriteFile(vidFile, video, 0o600); err != nil {
-		return nil, errors.Wrap(err, "failed to write video")
+// AddAudioToVideoFile is AddAudioToVideo for the files at video and audio,
+// writing the result to out.
+func (f *FFmpeg) AddAudioToVideoFile(ctx context.Context, video, audio, out string) error {
+	for _, path := range []string{video, audio} {
+		if err := f.checkInput(path); err != nil {
+			return err
+		}
+	}
+	j, err := f.newJob(ctx, "addaudio")
+	if err != nil {
+		return err
 	}
-	if err := os.WriteFile(audFile, audio, 0o600); err != nil {
-		return nil, errors.Wrap(err, "failed to write audio")
+	defer j.close()
+	if err := j.addAudio(video, audio, out); err != nil {
+		return err
 	}
+	return f.checkOutput(out)
+}
 
-	cmd := exec.Command(
-		"ffmpeg",
+func (j *job) addAudio(vidFile, audFile, outFile string) error {
+	if _, err := j.probe(vidFile, "video", true, false); err != nil {
+		return err
+	}
+	if _, err := j.probe(audFile, "audio", false, true); err != nil {
+		return err
+	}
+	return j.run(
 		"-stream_loop", "-1", "-i", audFile,
 		"-i", vidFile,
 		"-shortest",
@@ -125,17 +258,4 @@ func AddAudioToVideo(video, audio []byte) ([]byte, error) {
 		"-c:v", "copy",
 		"-y", outFile,
 	)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("ffmpeg run error: %w, %s", err, stderr.String())
-	}
-
-	merged, err := os.ReadFile(outFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read output video")
-	}
-
-	return merged, nil
 }
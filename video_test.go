@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -107,3 +108,75 @@ func TestAddAudioToVideo(t *testing.T) {
 		t.Fatalf("output video is empty")
 	}
 }
+
+func TestMergeVideosNormalizesClips(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	v1, err := createColorVideo("red")
+	if err != nil {
+		t.Fatalf("failed to create first video: %v", err)
+	}
+	tmpFile, err := os.CreateTemp("", "wide-*.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	cmd := exec.Command("ffmpeg",
+		"-f", "lavfi", "-i", "color=c=blue:s=640x360:r=24:d=1",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=1",
+		"-shortest", "-pix_fmt", "yuv444p", "-y", tmpFile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ffmpeg create video: %v, %s", err, output)
+	}
+	v2, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := MergeVideos([][]byte{v1, v2})
+	if err != nil {
+		t.Fatalf("MergeVideos returned error: %v", err)
+	}
+	info, err := ProbeMedia(merged)
+	if err != nil {
+		t.Fatalf("ProbeMedia returned error: %v", err)
+	}
+	if info.Video.Width != 320 || info.Video.Height != 240 || info.Video.PixelFormat != "yuv420p" || info.Audio == nil {
+		t.Fatalf("unexpected merged video %+v", info)
+	}
+}
+
+func TestNormalizeTarget(t *testing.T) {
+	a := clipInfo{VideoCodec: "h264", Width: 1280, Height: 720, FrameRate: "24/1", PixFmt: "yuv420p"}
+	b := clipInfo{VideoCodec: "h264", Width: 1280, Height: 720, FrameRate: "24/1", PixFmt: "yuv420p"}
+	if !clipsCompatible([]clipInfo{a, b}) {
+		t.Fatal("expected identical clips to be compatible")
+	}
+	b.HasAudio, b.AudioCodec, b.SampleRate, b.Channels = true, "aac", 44100, 1
+	if clipsCompatible([]clipInfo{a, b}) {
+		t.Fatal("expected clips with different audio to be incompatible")
+	}
+
+	target := normalizeTarget([]clipInfo{a, b})
+	if target.Width != 1280 || target.FrameRate != "24/1" || !target.HasAudio || target.SampleRate != 48000 || target.Channels != 2 {
+		t.Fatalf("unexpected target %+v", target)
+	}
+	args := strings.Join(normalizeArgs("in.mp4", "out.mp4", a, target), " ")
+	if !strings.Contains(args, "anullsrc") || !strings.Contains(args, "-map 1:a:0") || !strings.Contains(args, "pad=1280:720") {
+		t.Fatalf("expected silence and padding in %s", args)
+	}
+	args = strings.Join(normalizeArgs("in.mp4", "out.mp4", b, target), " ")
+	if strings.Contains(args, "anullsrc") || !strings.Contains(args, "-map 0:a:0") {
+		t.Fatalf("expected the clip's own audio in %s", args)
+	}
+
+	if fps, err := parseFrameRate("30000/1001"); err != nil || fps < 29.9 || fps > 30 {
+		t.Fatalf("parseFrameRate returned %v, %v", fps, err)
+	}
+	if _, err := parseFrameRate("0/0"); err == nil {
+		t.Fatal("expected error for 0/0")
+	}
+}
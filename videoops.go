@@ -0,0 +1,260 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodeArgs re-encode video to H.264 and audio to AAC, the formats the
+// rest of this package produces.
+var encodeArgs = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p", "-c:a", "aac"}
+
+// Segment is a part of a video between Start and End.
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// seconds formats d for ffmpeg.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// checkSegment validates s against a video of the given duration. A zero
+// End means the end of the video.
+func checkSegment(s Segment, duration time.Duration) (Segment, error) {
+	if s.End == 0 {
+		s.End = duration
+	}
+	if s.Start < 0 || s.End <= s.Start || s.End > duration {
+		return s, fmt.Errorf("%w: segment %v-%v is not within the video of %v", ErrInvalidParameters, s.Start, s.End, duration)
+	}
+	return s, nil
+}
+
+// TrimVideo keeps the part of video between start and end, re-encoding it so
+// that the cut is frame accurate. A zero end keeps the rest of the video.
+// ffmpeg and ffprobe must be installed and accessible on the system PATH.
+func TrimVideo(video []byte, start, end time.Duration) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, TrimOp(start, end))
+}
+
+// TrimOp is the operation run by TrimVideo.
+func TrimOp(start, end time.Duration) VideoOp {
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		s, err := checkSegment(Segment{start, end}, info.Duration)
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"-ss", seconds(s.Start), "-i", in, "-t", seconds(s.End - s.Start)}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// CutVideo keeps the given segments of video and joins them in order, e.g.
+// to drop a glitch in the middle of a clip.
+func CutVideo(video []byte, segments []Segment) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, CutOp(segments))
+}
+
+// CutOp is the operation run by CutVideo.
+func CutOp(segments []Segment) VideoOp {
+	if len(segments) == 0 {
+		return invalidOp(fmt.Errorf("%w: no segments to keep", ErrInvalidParameters))
+	}
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		audio := info.Audio != nil
+		var graph []string
+		var pads strings.Builder
+		for i, s := range segments {
+			s, err := checkSegment(s, info.Duration)
+			if err != nil {
+				return nil, err
+			}
+			graph = append(graph, fmt.Sprintf("[0:v]trim=start=%s:end=%s,setpts=PTS-STARTPTS[v%d]", seconds(s.Start), seconds(s.End), i))
+			fmt.Fprintf(&pads, "[v%d]", i)
+			if audio {
+				graph = append(graph, fmt.Sprintf("[0:a]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[a%d]", seconds(s.Start), seconds(s.End), i))
+				fmt.Fprintf(&pads, "[a%d]", i)
+			}
+		}
+		if audio {
+			graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=1[v][a]", pads.String(), len(segments)))
+		} else {
+			graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[v]", pads.String(), len(segments)))
+		}
+
+		args := []string{"-i", in, "-filter_complex", strings.Join(graph, ";"), "-map", "[v]"}
+		if audio {
+			args = append(args, "-map", "[a]")
+		}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// ChangeVideoSpeed plays video factor times faster, between 0.25 and 4. The
+// audio is sped up or slowed down with its pitch preserved.
+func ChangeVideoSpeed(video []byte, factor float64) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, SpeedOp(factor))
+}
+
+// SpeedOp is the operation run by ChangeVideoSpeed.
+func SpeedOp(factor float64) VideoOp {
+	if factor < 0.25 || factor > 4 {
+		return invalidOp(fmt.Errorf("%w: speed factor must be between 0.25 and 4", ErrInvalidParameters))
+	}
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		f := strconv.FormatFloat(factor, 'f', -1, 64)
+		args := []string{"-i", in, "-filter:v", "setpts=PTS/" + f}
+		if info.Audio != nil {
+			args = append(args, "-filter:a", atempoChain(factor))
+		}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// atempoChain returns atempo filters changing the tempo by factor. Each
+// atempo filter is kept within 0.5 to 2, where it works best.
+func atempoChain(factor float64) string {
+	var filters []string
+	for factor > 2 {
+		filters = append(filters, "atempo=2")
+		factor /= 2
+	}
+	for factor < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		factor /= 0.5
+	}
+	return strings.Join(append(filters, "atempo="+strconv.FormatFloat(factor, 'f', -1, 64)), ",")
+}
+
+// ReverseVideo plays video backwards, including its audio. The whole clip is
+// buffered by ffmpeg, so it is meant for short generated clips.
+func ReverseVideo(video []byte) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, ReverseOp())
+}
+
+// ReverseOp is the operation run by ReverseVideo.
+func ReverseOp() VideoOp {
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		args := []string{"-i", in, "-vf", "reverse"}
+		if info.Audio != nil {
+			args = append(args, "-af", "areverse")
+		}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// LoopVideo repeats video until it lasts duration, cutting the last
+// repetition short where needed.
+func LoopVideo(video []byte, duration time.Duration) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, LoopOp(duration))
+}
+
+// LoopOp is the operation run by LoopVideo.
+func LoopOp(duration time.Duration) VideoOp {
+	if duration <= 0 {
+		return invalidOp(fmt.Errorf("%w: loop duration must be positive", ErrInvalidParameters))
+	}
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		args := []string{"-stream_loop", "-1", "-i", in, "-t", seconds(duration)}
+		args = append(args, encodeArgs...)
+		return append(args, "-y", out), nil
+	}}
+}
+
+// videoOperation returns the operation run by a trim_video, cut_video,
+// change_video_speed, reverse_video or loop_video step, configured from the
+// step options. Times are given in seconds.
+func videoOperation(functionType string, options map[string]any) (VideoOp, error) {
+	var (
+		start, end, duration time.Duration
+		factor               float64
+		segments             []Segment
+	)
+	allowed := map[string][]string{
+		FunctionTypeTrimVideo:        {"start", "end"},
+		FunctionTypeCutVideo:         {"segments"},
+		FunctionTypeChangeVideoSpeed: {"factor"},
+		FunctionTypeReverseVideo:     nil,
+		FunctionTypeLoopVideo:        {"duration"},
+	}[functionType]
+	for k, v := range options {
+		if !slices.Contains(allowed, k) {
+			return VideoOp{}, fmt.Errorf("%w: unknown %s option %s", ErrInvalidParameters, functionType, k)
+		}
+		var err error
+		switch k {
+		case "start":
+			start, err = optionSeconds(k, v)
+		case "end":
+			end, err = optionSeconds(k, v)
+		case "duration":
+			duration, err = optionSeconds(k, v)
+		case "factor":
+			factor, err = optionFloat(k, v)
+		case "segments":
+			segments, err = segmentsFromOption(k, v)
+		}
+		if err != nil {
+			return VideoOp{}, err
+		}
+	}
+
+	var op VideoOp
+	switch functionType {
+	case FunctionTypeTrimVideo:
+		op = TrimOp(start, end)
+	case FunctionTypeCutVideo:
+		op = CutOp(segments)
+	case FunctionTypeChangeVideoSpeed:
+		op = SpeedOp(factor)
+	case FunctionTypeReverseVideo:
+		op = ReverseOp()
+	case FunctionTypeLoopVideo:
+		op = LoopOp(duration)
+	default:
+		return VideoOp{}, fmt.Errorf("%w: %s is not a video operation", ErrInvalidParameters, functionType)
+	}
+	return op, op.err
+}
+
+// segmentsFromOption reads a list of segments, each a [start, end] pair or
+// a map with "start" and "end", in seconds.
+func segmentsFromOption(key string, v any) ([]Segment, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, key, v)
+	}
+	segments := make([]Segment, len(items))
+	for i, item := range items {
+		var start, end any
+		switch s := item.(type) {
+		case []any:
+			if len(s) != 2 {
+				return nil, fmt.Errorf("%w: segment %d must be a [start, end] pair", ErrInvalidParameters, i)
+			}
+			start, end = s[0], s[1]
+		case map[string]any:
+			start, end = s["start"], s["end"]
+		default:
+			return nil, fmt.Errorf("%w: segment %d must be a pair or a map, got %T", ErrInvalidParameters, i, item)
+		}
+		var err error
+		if segments[i].Start, err = optionSeconds(key+".start", start); err != nil {
+			return nil, err
+		}
+		if segments[i].End, err = optionSeconds(key+".end", end); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,110 @@
+package genailib
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestVideoOperations(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("green")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		op   func() ([]byte, error)
+		want time.Duration
+	}{
+		{"trim", func() ([]byte, error) { return TrimVideo(vid, 200*time.Millisecond, 700*time.Millisecond) }, 500 * time.Millisecond},
+		{"cut", func() ([]byte, error) {
+			return CutVideo(vid, []Segment{{0, 300 * time.Millisecond}, {600 * time.Millisecond, time.Second}})
+		}, 700 * time.Millisecond},
+		{"speed", func() ([]byte, error) { return ChangeVideoSpeed(vid, 2) }, 500 * time.Millisecond},
+		{"reverse", func() ([]byte, error) { return ReverseVideo(vid) }, time.Second},
+		{"loop", func() ([]byte, error) { return LoopVideo(vid, 2500*time.Millisecond) }, 2500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		out, err := c.op()
+		if err != nil {
+			t.Fatalf("%s returned error: %v", c.name, err)
+		}
+		info, err := ProbeMedia(out)
+		if err != nil {
+			t.Fatalf("%s: ProbeMedia returned error: %v", c.name, err)
+		}
+		if d := info.Duration - c.want; d < -150*time.Millisecond || d > 150*time.Millisecond {
+			t.Errorf("%s: duration %v, want about %v", c.name, info.Duration, c.want)
+		}
+	}
+
+	if _, err := TrimVideo(vid, 0, 5*time.Second); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters past the end, got %v", err)
+	}
+}
+
+func TestAtempoChain(t *testing.T) {
+	cases := map[float64]string{
+		1.5:  "atempo=1.5",
+		4:    "atempo=2,atempo=2",
+		0.25: "atempo=0.5,atempo=0.5",
+		3:    "atempo=2,atempo=1.5",
+	}
+	for factor, want := range cases {
+		if got := atempoChain(factor); got != want {
+			t.Errorf("atempoChain(%v)=%q want %q", factor, got, want)
+		}
+	}
+}
+
+func TestCheckSegment(t *testing.T) {
+	s, err := checkSegment(Segment{Start: time.Second}, 3*time.Second)
+	if err != nil || s.End != 3*time.Second {
+		t.Fatalf("expected the segment to run to the end, got %+v: %v", s, err)
+	}
+	for _, bad := range []Segment{{-time.Second, time.Second}, {2 * time.Second, time.Second}, {0, 4 * time.Second}} {
+		if _, err := checkSegment(bad, 3*time.Second); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("expected ErrInvalidParameters for %+v, got %v", bad, err)
+		}
+	}
+}
+
+func TestVideoOperationOptions(t *testing.T) {
+	if _, err := videoOperation(FunctionTypeCutVideo, map[string]any{
+		"segments": []any{[]any{0, 1.5}, map[string]any{"start": 2, "end": 3}},
+	}); err != nil {
+		t.Fatalf("videoOperation returned error: %v", err)
+	}
+	segments, _ := segmentsFromOption("segments", []any{[]any{0.5, 1}})
+	if len(segments) != 1 || segments[0] != (Segment{500 * time.Millisecond, time.Second}) {
+		t.Fatalf("unexpected segments %+v", segments)
+	}
+
+	bad := []struct {
+		functionType string
+		options      map[string]any
+	}{
+		{FunctionTypeTrimVideo, map[string]any{"factor": 2}},
+		{FunctionTypeReverseVideo, map[string]any{"start": 1}},
+		{FunctionTypeLoopVideo, map[string]any{"duration": "long"}},
+		{FunctionTypeCutVideo, map[string]any{"segments": []any{[]any{1}}}},
+		{FunctionTypeCutVideo, map[string]any{"segments": "0-1"}},
+		{FunctionTypeWatermark, nil},
+	}
+	for _, c := range bad {
+		if _, err := videoOperation(c.functionType, c.options); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("%s %v: expected ErrInvalidParameters, got %v", c.functionType, c.options, err)
+		}
+	}
+	if _, err := ChangeVideoSpeed(nil, 10); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for speed factor, got %v", err)
+	}
+	if _, err := LoopVideo(nil, 0); !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected ErrInvalidParameters for loop duration, got %v", err)
+	}
+}
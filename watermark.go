@@ -0,0 +1,203 @@
+package genailib
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// WatermarkPosition places a watermark in the frame.
+type WatermarkPosition string
+
+// Watermark positions.
+const (
+	PositionTopLeft     WatermarkPosition = "top-left"
+	PositionTopRight    WatermarkPosition = "top-right"
+	PositionBottomLeft  WatermarkPosition = "bottom-left"
+	PositionBottomRight WatermarkPosition = "bottom-right"
+	PositionCenter      WatermarkPosition = "center"
+)
+
+// Watermark describes a logo or text overlay. Sizes are relative to the
+// frame width so that one watermark fits images and videos of any size.
+type Watermark struct {
+	// Logo is a PNG, JPEG or WEBP image. Either Logo or Text must be set.
+	Logo []byte
+	Text string
+	// TextColor defaults to white.
+	TextColor color.Color
+	// Position defaults to PositionBottomRight.
+	Position WatermarkPosition
+	// Opacity is between 0 and 1; zero means fully opaque.
+	Opacity float64
+	// Margin is the distance from the frame edges as a fraction of the
+	// frame width, 0.02 by default.
+	Margin float64
+	// Scale is the watermark width as a fraction of the frame width, 0.15
+	// by default.
+	Scale float64
+}
+
+func (wm Watermark) withDefaults() (Watermark, error) {
+	if (len(wm.Logo) == 0) == (wm.Text == "") {
+		return wm, fmt.Errorf("%w: watermark needs either a logo or text", ErrInvalidParameters)
+	}
+	if wm.Position == "" {
+		wm.Position = PositionBottomRight
+	}
+	switch wm.Position {
+	case PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionCenter:
+	default:
+		return wm, fmt.Errorf("%w: unknown watermark position %q", ErrInvalidParameters, wm.Position)
+	}
+	if wm.Opacity == 0 {
+		wm.Opacity = 1
+	}
+	if wm.Margin == 0 {
+		wm.Margin = 0.02
+	}
+	if wm.Scale == 0 {
+		wm.Scale = 0.15
+	}
+	if wm.Opacity < 0 || wm.Opacity > 1 || wm.Margin < 0 || wm.Scale < 0 || wm.Scale > 1 {
+		return wm, fmt.Errorf("%w: watermark opacity, margin and scale must be between 0 and 1", ErrInvalidParameters)
+	}
+	if wm.TextColor == nil {
+		wm.TextColor = color.White
+	}
+	return wm, nil
+}
+
+// overlay returns the watermark image at its natural size.
+func (wm Watermark) overlay() (image.Image, error) {
+	if len(wm.Logo) > 0 {
+		img, _, err := DecodeImage(wm.Logo)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode logo")
+		}
+		return img, nil
+	}
+	face := basicfont.Face7x13
+	d := font.Drawer{Face: face, Src: image.NewUniform(wm.TextColor)}
+	width := d.MeasureString(wm.Text).Ceil()
+	img := image.NewNRGBA(image.Rect(0, 0, width, face.Height))
+	d.Dst = img
+	d.Dot = fixed.P(0, face.Ascent)
+	d.DrawString(wm.Text)
+	return img, nil
+}
+
+// WatermarkImage overlays wm onto a PNG, JPEG or WEBP image and returns it in
+// the same format, except that WEBP images are returned as PNG, which
+// EncodeImage writes far smaller.
+func WatermarkImage(data []byte, wm Watermark) ([]byte, error) {
+	wm, err := wm.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := DecodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	mark, err := wm.overlay()
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	mb := mark.Bounds()
+	w := max(1, int(float64(b.Dx())*wm.Scale))
+	h := max(1, mb.Dy()*w/mb.Dx())
+	margin := int(float64(b.Dx()) * wm.Margin)
+	var at image.Point
+	switch wm.Position {
+	case PositionTopLeft:
+		at = image.Pt(margin, margin)
+	case PositionTopRight:
+		at = image.Pt(b.Dx()-w-margin, margin)
+	case PositionBottomLeft:
+		at = image.Pt(margin, b.Dy()-h-margin)
+	case PositionBottomRight:
+		at = image.Pt(b.Dx()-w-margin, b.Dy()-h-margin)
+	case PositionCenter:
+		at = image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2)
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	alpha := image.NewUniform(color.Alpha{A: uint8(wm.Opacity * 255)})
+	draw.DrawMask(dst, scaled.Bounds().Add(at), scaled, image.Point{}, alpha, image.Point{}, draw.Over)
+	if format == FormatWEBP {
+		format = FormatPNG
+	}
+	return EncodeImage(dst, format, 0)
+}
+
+// WatermarkVideo overlays wm onto every frame of an MP4 video using ffmpeg.
+// Text is rendered by this package, so ffmpeg needs no font support. The
+// audio track is copied unchanged. ffmpeg and ffprobe must be installed and
+// accessible on the system PATH.
+func WatermarkVideo(video []byte, wm Watermark) ([]byte, error) {
+	return defaultFFmpeg.transformBytes(context.Background(), video, WatermarkOp(wm))
+}
+
+// WatermarkOp is the operation run by WatermarkVideo.
+func WatermarkOp(wm Watermark) VideoOp {
+	wm, err := wm.withDefaults()
+	if err != nil {
+		return invalidOp(err)
+	}
+	mark, err := wm.overlay()
+	if err != nil {
+		return invalidOp(err)
+	}
+	markPNG, err := EncodeImage(mark, FormatPNG, 0)
+	if err != nil {
+		return invalidOp(err)
+	}
+
+	m := fmt.Sprintf("main_w*%g", wm.Margin)
+	var x, y string
+	switch wm.Position {
+	case PositionTopLeft:
+		x, y = m, m
+	case PositionTopRight:
+		x, y = "main_w-overlay_w-"+m, m
+	case PositionBottomLeft:
+		x, y = m, "main_h-overlay_h-"+m
+	case PositionBottomRight:
+		x, y = "main_w-overlay_w-"+m, "main_h-overlay_h-"+m
+	case PositionCenter:
+		x, y = "(main_w-overlay_w)/2", "(main_h-overlay_h)/2"
+	}
+	filter := fmt.Sprintf(
+		"[1:v][0:v]scale2ref=w=main_w*%g:h=ow/a[wm][base];[wm]format=rgba,colorchannelmixer=aa=%g[mark];[base][mark]overlay=x=%s:y=%s[out]",
+		wm.Scale, wm.Opacity, x, y,
+	)
+
+	return VideoOp{args: func(j *job, in, out string, info *MediaInfo) ([]string, error) {
+		markFile, err := j.write("watermark.png", markPNG)
+		if err != nil {
+			return nil, err
+		}
+		return []string{
+			"-i", in,
+			"-i", markFile,
+			"-filter_complex", filter,
+			"-map", "[out]",
+			"-map", "0:a?",
+			"-c:a", "copy",
+			"-pix_fmt", "yuv420p",
+			"-y", out,
+		}, nil
+	}}
+}
@@ -0,0 +1,115 @@
+package genailib
+
+import (
+	"context"
+	"image/color"
+	"os/exec"
+	"testing"
+)
+
+func TestWatermarkImageLogo(t *testing.T) {
+	base := testPNG(t, 100, 100, color.White)
+	logo := testPNG(t, 10, 10, color.RGBA{R: 255, A: 255})
+
+	out, err := WatermarkImage(base, Watermark{Logo: logo, Scale: 0.2})
+	if err != nil {
+		t.Fatalf("WatermarkImage returned error: %v", err)
+	}
+	img, format, err := DecodeImage(out)
+	if err != nil || format != FormatPNG {
+		t.Fatalf("unexpected output %s: %v", format, err)
+	}
+	// A 20x20 logo 2px from the bottom right corner.
+	if c := color.NRGBAModel.Convert(img.At(88, 88)).(color.NRGBA); c.R != 255 || c.G != 0 {
+		t.Fatalf("expected logo at the bottom right, got %v", c)
+	}
+	if c := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA); c.G != 255 {
+		t.Fatalf("expected the rest of the image untouched, got %v", c)
+	}
+
+	out, err = WatermarkImage(base, Watermark{Logo: logo, Scale: 0.2, Opacity: 0.5, Position: PositionTopLeft})
+	if err != nil {
+		t.Fatalf("WatermarkImage returned error: %v", err)
+	}
+	img, _, _ = DecodeImage(out)
+	if c := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA); c.R != 255 || c.G < 100 || c.G > 150 {
+		t.Fatalf("expected a half transparent logo, got %v", c)
+	}
+}
+
+func TestWatermarkImageText(t *testing.T) {
+	out, err := WatermarkImage(testPNG(t, 200, 100, color.White), Watermark{Text: "ACME", TextColor: color.Black, Position: PositionCenter, Scale: 0.5})
+	if err != nil {
+		t.Fatalf("WatermarkImage returned error: %v", err)
+	}
+	img, _, _ := DecodeImage(out)
+	dark := 0
+	for y := 40; y < 60; y++ {
+		for x := 50; x < 150; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+				dark++
+			}
+		}
+	}
+	if dark == 0 {
+		t.Fatal("expected text in the centre of the image")
+	}
+}
+
+func TestWatermarkInvalid(t *testing.T) {
+	base := testPNG(t, 10, 10, color.White)
+	cases := []Watermark{
+		{},
+		{Text: "a", Logo: base},
+		{Text: "a", Position: "middle"},
+		{Text: "a", Opacity: 2},
+	}
+	for _, wm := range cases {
+		if _, err := WatermarkImage(base, wm); err == nil {
+			t.Errorf("expected error for %+v", wm)
+		}
+	}
+}
+
+func TestWatermarkVideo(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+	vid, err := createColorVideo("blue")
+	if err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	out, err := WatermarkVideo(vid, Watermark{Text: "ACME", Opacity: 0.7})
+	if err != nil {
+		t.Fatalf("WatermarkVideo returned error: %v", err)
+	}
+	if len(out) == 0 {
+		t.Fatalf("output video is empty")
+	}
+}
+
+func TestWorkflowWatermark(t *testing.T) {
+	svc := NewWorkflowService()
+	wf := &Workflow{Steps: []WorkflowStep{{
+		ID:           "brand",
+		FunctionType: FunctionTypeWatermark,
+		Image:        "photo",
+		Options:      map[string]any{"logo": "logo", "position": "top-right", "opacity": 1, "scale": 0.5},
+	}}}
+	inputs := map[string]any{
+		"photo": testPNG(t, 40, 40, color.White),
+		"logo":  testPNG(t, 4, 4, color.Black),
+	}
+	result, _, err := svc.Generate(context.Background(), wf, inputs)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	art, ok := result.(*Artifact)
+	if !ok || art.MIMEType != "image/png" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	img, _, _ := DecodeImage(art.Data)
+	if r, _, _, _ := img.At(30, 5).RGBA(); r != 0 {
+		t.Fatalf("expected the logo in the top right corner, got %v", img.At(30, 5))
+	}
+}
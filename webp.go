@@ -0,0 +1,140 @@
+package genailib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// encodeWEBP writes img as a lossless WEBP (VP8L) image. The encoder applies
+// no transforms or backward references: every pixel is stored as literals
+// with fixed 8 bit codes, which keeps it small and always valid at the cost
+// of file size.
+func encodeWEBP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 1<<14 || b.Dy() > 1<<14 {
+		return fmt.Errorf("webp: cannot encode a %dx%d image", b.Dx(), b.Dy())
+	}
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	}
+	opaque := rgba.Opaque()
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8) // VP8L signature
+	bw.write(uint32(b.Dx()-1), 14)
+	bw.write(uint32(b.Dy()-1), 14)
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta Huffman codes
+
+	// Green (with the unused length prefix symbols), red, blue and alpha
+	// use 8 bit codes for the 256 literal values; the distance code has a
+	// single symbol and takes no bits.
+	writeLiteralCode(bw, 256+24)
+	writeLiteralCode(bw, 256)
+	writeLiteralCode(bw, 256)
+	writeLiteralCode(bw, 256)
+	bw.write(1, 1) // simple code
+	bw.write(0, 1) // one symbol
+	bw.write(0, 1) // of 1 bit
+	bw.write(0, 1) // symbol 0
+
+	for y := 0; y < b.Dy(); y++ {
+		row := rgba.Pix[y*rgba.Stride : y*rgba.Stride+b.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			bw.write(uint32(reverse8[row[x+1]]), 8)
+			bw.write(uint32(reverse8[row[x]]), 8)
+			bw.write(uint32(reverse8[row[x+2]]), 8)
+			bw.write(uint32(reverse8[row[x+3]]), 8)
+		}
+	}
+	data := bw.bytes()
+
+	chunk := len(data)
+	padded := chunk + chunk&1
+	var hdr [20]byte
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(4+8+padded))
+	copy(hdr[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(chunk))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if chunk&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeLiteralCode writes a normal Huffman code over an alphabet of size
+// symbols in which the first 256 symbols have length 8 and the rest are
+// unused. The code length code only needs lengths 0 and 8, each given a 1
+// bit code.
+func writeLiteralCode(bw *bitWriter, size int) {
+	bw.write(0, 1) // normal code
+	// Code length code lengths are sent in the order 17, 18, 0, 1, 2, 3, 4,
+	// 5, 16, 6, 7, 8; the first twelve cover the 0 and 8 entries.
+	bw.write(12-4, 4)
+	for _, l := range []uint32{0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1} {
+		bw.write(l, 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for i := 0; i < size; i++ {
+		if i < 256 {
+			bw.write(1, 1) // length 8
+		} else {
+			bw.write(0, 1) // length 0
+		}
+	}
+}
+
+// bitWriter packs bits least significant first, as VP8L expects.
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf.WriteByte(byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf.WriteByte(byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+// reverse8 maps a byte to its bit reversal. Huffman codes are read most
+// significant bit first from the least significant first bit stream.
+var reverse8 = func() (t [256]byte) {
+	for i := range t {
+		for b := 0; b < 8; b++ {
+			if i&(1<<b) != 0 {
+				t[i] |= 1 << (7 - b)
+			}
+		}
+	}
+	return t
+}()
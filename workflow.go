@@ -1,9 +1,10 @@
 package genailib
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 
 	"github.com/iomodo/gen-ai-lib/external/gemini"
 	"github.com/iomodo/gen-ai-lib/external/replicate"
+	"github.com/iomodo/gen-ai-lib/internal/mediatype"
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +26,20 @@ const (
 	FunctionTypeTextAndImageToVideo  = "text_and_image_to_video"
 	FunctionTypeVideosToVideo        = "videos_to_video"
 	FunctionTypeVideoAndAudioToVideo = "video_and_audio_to_video"
+	FunctionTypeProcessImage         = "process_image"
+	FunctionTypeWatermark            = "watermark"
+	FunctionTypeUpscaleImage         = "upscale_image"
+	FunctionTypeRemoveBackground     = "remove_background"
+	FunctionTypeVideoToImage         = "video_to_image"
+	FunctionTypeTrimVideo            = "trim_video"
+	FunctionTypeCutVideo             = "cut_video"
+	FunctionTypeChangeVideoSpeed     = "change_video_speed"
+	FunctionTypeReverseVideo         = "reverse_video"
+	FunctionTypeLoopVideo            = "loop_video"
+	FunctionTypeCaptionVideo         = "caption_video"
+	FunctionTypeMixAudio             = "mix_audio"
+	FunctionTypeExportVideo          = "export_video"
+	FunctionTypeRenderTimeline       = "render_timeline"
 )
 
 // Workflow providers.
@@ -44,6 +60,8 @@ const (
 	ProviderSeedance1                       = replicate.Seedance1Model
 	ProviderSeedance1Lite                   = replicate.Seedance1LiteModel
 	ProviderVeo3Preview                     = gemini.VEO_3_PREVIEW_MODEL
+	ProviderRealESRGAN                      = "nightmareai/real-esrgan"
+	ProviderRembg                           = "cjwbw/rembg"
 )
 
 // WorkflowStep represents a single step in a workflow.
@@ -58,6 +76,9 @@ type WorkflowStep struct {
 	Videos       []string `json:"videos,omitempty" yaml:"videos,omitempty"`
 	Video        string   `json:"video,omitempty" yaml:"video,omitempty"`
 	Audio        string   `json:"audio,omitempty" yaml:"audio,omitempty"`
+	// Options holds step specific settings, using the same keys as the
+	// options of Image.Generate and Image.Edit for image steps.
+	Options map[string]any `json:"options,omitempty" yaml:"options,omitempty"`
 }
 
 // Workflow defines an ordered set of steps for content generation.
@@ -73,17 +94,21 @@ type WorkflowService interface {
 	Generate(ctx context.Context, wf *Workflow, inputs map[string]any) (result any, output string, err error)
 }
 
-type workflowService struct{}
+type workflowService struct {
+	providers *providers
+	gateway   *APIGateway
+}
 
-// NewWorkflowService returns a WorkflowService implementation.
-func NewWorkflowService() WorkflowService {
-	return &workflowService{}
+// NewWorkflowService returns a WorkflowService implementation configured by opts.
+func NewWorkflowService(opts ...Option) WorkflowService {
+	p := newProviders(NewConfig(opts...))
+	return &workflowService{providers: p, gateway: newAPIGateway(p)}
 }
 
 // Generate executes a workflow with the provided inputs.
 func (s *workflowService) Generate(ctx context.Context, wf *Workflow, inputs map[string]any) (any, string, error) {
-	if wf == nil {
-		return nil, "", errors.New("nil workflow")
+	if err := ValidateWorkflow(wf); err != nil {
+		return nil, "", err
 	}
 
 	results := make(map[string]any)
@@ -104,9 +129,29 @@ func (s *workflowService) Generate(ctx context.Context, wf *Workflow, inputs map
 		case FunctionTypeTextAndImageToVideo:
 			res, err = s.processTextAndImageToVideo(ctx, step, inputs, results)
 		case FunctionTypeVideosToVideo:
-			res, err = s.processVideosToVideo(step, results)
+			res, err = s.processVideosToVideo(ctx, step, inputs, results)
 		case FunctionTypeVideoAndAudioToVideo:
 			res, err = s.processVideoAndAudioToVideo(ctx, step, inputs, results)
+		case FunctionTypeProcessImage:
+			res, err = s.processImage(ctx, step, inputs, results)
+		case FunctionTypeWatermark:
+			res, err = s.processWatermark(ctx, step, inputs, results)
+		case FunctionTypeUpscaleImage:
+			res, err = s.processUpscaleImage(ctx, step, inputs, results)
+		case FunctionTypeRemoveBackground:
+			res, err = s.processRemoveBackground(ctx, step, inputs, results)
+		case FunctionTypeVideoToImage:
+			res, err = s.processVideoToImage(ctx, step, inputs, results)
+		case FunctionTypeCaptionVideo:
+			res, err = s.processCaptionVideo(ctx, step, inputs, results)
+		case FunctionTypeMixAudio:
+			res, err = s.processMixAudio(ctx, step, inputs, results)
+		case FunctionTypeExportVideo:
+			res, err = s.processExportVideo(ctx, step, inputs, results)
+		case FunctionTypeRenderTimeline:
+			res, err = s.processRenderTimeline(ctx, step, inputs, results)
+		case FunctionTypeTrimVideo, FunctionTypeCutVideo, FunctionTypeChangeVideoSpeed, FunctionTypeReverseVideo, FunctionTypeLoopVideo:
+			res, err = s.processVideoOperation(ctx, step, inputs, results)
 		default:
 			err = errors.Errorf("unsupported function type: %s", step.FunctionType)
 		}
@@ -136,14 +181,45 @@ func (s *workflowService) processTextsToText(ctx context.Context, step WorkflowS
 	return out, nil
 }
 
+// processTextToImage generates an image with step.Provider, or the gateway's
+// default service when no provider is set, and returns it as an *Artifact.
 func (s *workflowService) processTextToImage(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
-	// TODO: implement real logic. For now return dummy value.
-	return "text_to_image result", nil
+	if step.Prompt == "" {
+		return nil, errors.New("missing prompt template in step configuration")
+	}
+
+	prompt := s.interpolateVariables(step.Prompt, inputs, results)
+	req, err := imageRequestFromOptions(prompt, step.Options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.gateway.TextToImage(ctx, req, step.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Images[0], nil
 }
 
+// processTextAndImageToImage edits the image referenced by step.Image, which
+// names an input or an earlier step, or is itself a URL.
 func (s *workflowService) processTextAndImageToImage(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
-	// TODO: implement real logic. For now return dummy value.
-	return "text_and_image_to_image result", nil
+	if step.Prompt == "" {
+		return nil, errors.New("missing prompt template in step configuration")
+	}
+	if step.Image == "" {
+		return nil, errors.New("missing image in step configuration")
+	}
+
+	prompt := s.interpolateVariables(step.Prompt, inputs, results)
+	req, err := editRequestFromOptions(prompt, resolveReference(step.Image, inputs, results), step.Options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.gateway.ImageToImage(ctx, req, step.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Images[0], nil
 }
 
 func (s *workflowService) processTextAndImagesToVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
@@ -155,7 +231,9 @@ func (s *workflowService) processTextAndImagesToVideo(ctx context.Context, step
 	}
 
 	prompt := s.interpolateVariables(step.Prompt, inputs, results)
-	return s.generateVideo(ctx, step.Provider, prompt, step.FirstImage, step.LastImage)
+	return s.generateVideo(ctx, step.Provider, prompt,
+		resolveReference(step.FirstImage, inputs, results),
+		resolveReference(step.LastImage, inputs, results))
 }
 
 func (s *workflowService) processTextAndImageToVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
@@ -167,111 +245,749 @@ func (s *workflowService) processTextAndImageToVideo(ctx context.Context, step W
 	}
 
 	prompt := s.interpolateVariables(step.Prompt, inputs, results)
-	return s.generateVideo(ctx, step.Provider, prompt, step.FirstImage, "")
+	return s.generateVideo(ctx, step.Provider, prompt, resolveReference(step.FirstImage, inputs, results), nil)
 }
 
 // generateVideo dispatches the video generation request to the chosen provider.
-// If lastURL is empty, only the first frame is sent.
-func (s *workflowService) generateVideo(ctx context.Context, provider, prompt, firstURL, lastURL string) (any, error) {
+// The frames are URLs, bytes or artifacts, such as the result of a
+// video_to_image step. If last is nil, only the first frame is sent.
+func (s *workflowService) generateVideo(ctx context.Context, provider, prompt string, first, last any) (any, error) {
 	if provider == "" {
 		provider = ProviderVeo3Preview
 	}
+	firstImage, err := singleImageInput(first)
+	if err != nil {
+		return nil, errors.Wrap(err, "first image")
+	}
+	var lastImage *ImageInput
+	if last != nil {
+		in, err := singleImageInput(last)
+		if err != nil {
+			return nil, errors.Wrap(err, "last image")
+		}
+		lastImage = &in
+	}
 
 	switch provider {
 	case ProviderVeo3Preview:
-		svc := gemini.NewGeminiService()
-		if lastURL != "" {
-			return svc.GenerateVeo3PreviewVideoFromURLs(ctx, prompt, firstURL, lastURL)
-		}
-		return svc.GenerateVeo3PreviewVideoWithStartFrameURL(ctx, prompt, firstURL)
-	case ProviderSeedance1:
-		svc, err := replicate.NewReplicateService(os.Getenv(ReplicateAPIToken))
-		if err != nil {
-			return nil, err
-		}
-		opts := map[string]any{"image": firstURL}
-		if lastURL != "" {
-			opts["last_frame_image"] = lastURL
-		}
-		return svc.RunSeedance1(ctx, prompt, opts)
-	case ProviderSeedance1Lite:
-		svc, err := replicate.NewReplicateService(os.Getenv(ReplicateAPIToken))
+		firstFrame, err := s.providers.loadImage(ctx, firstImage)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "failed to load first image")
 		}
-		opts := map[string]any{"image": firstURL}
-		if lastURL != "" {
-			opts["last_frame_image"] = lastURL
+		var lastFrame []byte
+		if lastImage != nil {
+			if lastFrame, err = s.providers.loadImage(ctx, *lastImage); err != nil {
+				return nil, errors.Wrap(err, "failed to load last image")
+			}
 		}
-		return svc.RunSeedance1Lite(ctx, prompt, opts)
+		return s.providers.withGemini(ctx, provider, func(svc gemini.GeminiService) (any, error) {
+			if lastFrame != nil {
+				return svc.GenerateVeo3PreviewVideo(ctx, prompt, firstFrame, lastFrame)
+			}
+			return svc.GenerateVeo3PreviewVideoWithStartFrame(ctx, prompt, firstFrame)
+		})
+	case ProviderSeedance1, ProviderSeedance1Lite:
+		return s.providers.withReplicate(ctx, provider, func(svc replicate.ReplicateService) (any, error) {
+			image, err := s.providers.replicateInput(ctx, svc, firstImage)
+			if err != nil {
+				return nil, err
+			}
+			opts := map[string]any{"image": image}
+			if lastImage != nil {
+				if opts["last_frame_image"], err = s.providers.replicateInput(ctx, svc, *lastImage); err != nil {
+					return nil, err
+				}
+			}
+			if provider == ProviderSeedance1Lite {
+				return svc.RunSeedance1Lite(ctx, prompt, opts)
+			}
+			return svc.RunSeedance1(ctx, prompt, opts)
+		})
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 }
 
-func (s *workflowService) processVideosToVideo(step WorkflowStep, results map[string]any) (any, error) {
+// processVideosToVideo merges the videos named by step.Videos in order. Each
+// may be a step result or an input holding a URL, bytes or an *Artifact.
+// step.Options may set transitions, see mergeOptionsFromOptions. Unlike the
+// later video steps, it returns the merged video as []byte rather than an
+// *Artifact, as it always has.
+func (s *workflowService) processVideosToVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
 	if len(step.Videos) == 0 {
 		return nil, errors.New("no videos specified in step configuration")
 	}
+	opts, err := mergeOptionsFromOptions(step.Options)
+	if err != nil {
+		return nil, err
+	}
 
 	var clips [][]byte
 	for _, name := range step.Videos {
-		data, ok := results[name]
+		ref, ok := results[name]
 		if !ok {
-			return nil, fmt.Errorf("video reference %s not found", name)
-		}
-		url, ok := data.(string)
-		if !ok {
-			return nil, fmt.Errorf("video reference %s is not []byte", name)
+			if ref, ok = inputs[name]; !ok {
+				return nil, fmt.Errorf("video reference %s not found", name)
+			}
 		}
-		b, err := DownloadFileToBytes(url)
+		b, err := s.loadMedia(ctx, ref)
 		if err != nil {
-			return nil, fmt.Errorf("failed to download video from %s: %w", url, err)
+			return nil, errors.Wrapf(err, "failed to load video %s", name)
 		}
 		clips = append(clips, b)
 	}
 
-	return MergeVideos(clips)
+	return s.providers.ffmpeg().mergeVideos(ctx, clips, opts)
 }
 
+// processVideoAndAudioToVideo adds the audio named by step.Audio to the
+// video named by step.Video and returns the result as []byte.
 func (s *workflowService) processVideoAndAudioToVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
 	if step.Video == "" || step.Audio == "" {
 		return nil, errors.New("video or audio reference missing in step configuration")
 	}
 
-	getBytes := func(name string) ([]byte, error) {
-		if data, ok := results[name]; ok {
-			switch v := data.(type) {
-			case []byte:
-				return v, nil
-			case string:
-				return DownloadFileToBytes(v)
+	vidBytes, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	audBytes, err := s.loadMedia(ctx, resolveReference(step.Audio, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(step.Options) == 0 {
+		return s.providers.ffmpeg().addAudio(ctx, vidBytes, audBytes)
+	}
+
+	// With options the audio is mixed in as a single track, looped like
+	// AddAudioToVideo does unless "loop" is false.
+	track := AudioTrack{Audio: audBytes, Loop: true}
+	var opts MixOptions
+	for k, v := range step.Options {
+		ok, err := audioTrackFromOption(&track, k, v)
+		if !ok {
+			ok, err = mixOptionsFromOptions(&opts, k, v)
+		}
+		if !ok {
+			err = fmt.Errorf("%w: unknown audio option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.providers.ffmpeg().mixAudio(ctx, vidBytes, []AudioTrack{track}, opts)
+}
+
+// processMixAudio mixes the audio tracks listed in the "tracks" option into
+// the video named by step.Video. Each track is a map with an "audio"
+// reference and the optional keys role, volume, start, fade_in, fade_out and
+// loop; the step options keep_original, original_volume and ducking apply to
+// the whole mix.
+func (s *workflowService) processMixAudio(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Video == "" {
+		return nil, errors.New("missing video in step configuration")
+	}
+	var (
+		tracks []AudioTrack
+		opts   MixOptions
+	)
+	for k, v := range step.Options {
+		if k == "tracks" {
+			var err error
+			if tracks, err = s.audioTracksFromOption(ctx, k, v, inputs, results); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ok, err := mixOptionsFromOptions(&opts, k, v)
+		if !ok {
+			err = fmt.Errorf("%w: unknown mix option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	video, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.providers.ffmpeg().mixAudio(ctx, video, tracks, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.videoArtifact(ctx, out), nil
+}
+
+func (s *workflowService) audioTracksFromOption(ctx context.Context, key string, v any, inputs, results map[string]any) ([]AudioTrack, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, key, v)
+	}
+	tracks := make([]AudioTrack, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: track %d must be a map, got %T", ErrInvalidParameters, i+1, item)
+		}
+		var ref string
+		for k, v := range m {
+			var err error
+			if k == "audio" {
+				ref, err = optionString(k, v)
+			} else if ok, err = audioTrackFromOption(&tracks[i], k, v); !ok {
+				err = fmt.Errorf("%w: unknown track option %s", ErrInvalidParameters, k)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("track %d: %w", i+1, err)
+			}
+		}
+		if ref == "" {
+			return nil, fmt.Errorf("%w: track %d has no audio", ErrInvalidParameters, i+1)
+		}
+		data, err := s.loadMedia(ctx, resolveReference(ref, inputs, results))
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", i+1, err)
+		}
+		tracks[i].Audio = data
+	}
+	return tracks, nil
+}
+
+// processImage resizes, crops, pads or converts the image referenced by
+// step.Image locally, as described by step.Options (see ImageOps).
+func (s *workflowService) processImage(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Image == "" {
+		return nil, errors.New("missing image in step configuration")
+	}
+	ops, err := imageOpsFromOptions(step.Options)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.loadMedia(ctx, resolveReference(step.Image, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	out, format, err := ProcessImage(data, ops)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Data: out, MIMEType: format.MIMEType()}, nil
+}
+
+// processWatermark brands the video named by step.Video or, when no video
+// is set, the image named by step.Image. The options "logo" (a reference
+// like step.Image) or "text", "color", "position", "opacity", "margin" and
+// "scale" describe the Watermark.
+func (s *workflowService) processWatermark(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	target := step.Video
+	if target == "" {
+		target = step.Image
+	}
+	if target == "" {
+		return nil, errors.New("missing image or video in step configuration")
+	}
+
+	var wm Watermark
+	for k, v := range step.Options {
+		var err error
+		switch k {
+		case "logo":
+			var ref string
+			if ref, err = optionString(k, v); err == nil {
+				wm.Logo, err = s.loadMedia(ctx, resolveReference(ref, inputs, results))
+			}
+		case "text":
+			wm.Text, err = optionString(k, v)
+		case "color":
+			var c string
+			if c, err = optionString(k, v); err == nil {
+				wm.TextColor, err = parseColor(c)
+			}
+		case "position":
+			var p string
+			p, err = optionString(k, v)
+			wm.Position = WatermarkPosition(p)
+		case "opacity":
+			wm.Opacity, err = optionFloat(k, v)
+		case "margin":
+			wm.Margin, err = optionFloat(k, v)
+		case "scale":
+			wm.Scale, err = optionFloat(k, v)
+		default:
+			err = fmt.Errorf("%w: unknown watermark option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := s.loadMedia(ctx, resolveReference(target, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	if step.Video != "" {
+		out, err := s.providers.ffmpeg().transformBytes(ctx, data, WatermarkOp(wm))
+		if err != nil {
+			return nil, err
+		}
+		return s.videoArtifact(ctx, out), nil
+	}
+	out, err := WatermarkImage(data, wm)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Data: out, MIMEType: mediatype.Detect(out)}, nil
+}
+
+// processUpscaleImage upscales the image named by step.Image with
+// step.Provider, or ProviderRealESRGAN when no provider is set.
+func (s *workflowService) processUpscaleImage(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Image == "" {
+		return nil, errors.New("missing image in step configuration")
+	}
+	req, err := upscaleRequestFromOptions(resolveReference(step.Image, inputs, results), step.Options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.gateway.Upscale(ctx, req, step.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Images[0], nil
+}
+
+// processRemoveBackground removes the background of the image named by
+// step.Image with step.Provider, or ProviderRembg when no provider is set.
+func (s *workflowService) processRemoveBackground(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Image == "" {
+		return nil, errors.New("missing image in step configuration")
+	}
+	req, err := backgroundRemovalRequestFromOptions(resolveReference(step.Image, inputs, results), step.Options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.gateway.RemoveBackground(ctx, req, step.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Images[0], nil
+}
+
+// processVideoToImage extracts a frame of the video named by step.Video as
+// a PNG image. The "frame" option selects "first", "last" (the default, for
+// chaining clips) or "poster", which takes an optional "width"; "at" takes
+// the frame shown at a time in seconds instead.
+func (s *workflowService) processVideoToImage(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Video == "" {
+		return nil, errors.New("missing video in step configuration")
+	}
+	frame := "last"
+	var at *time.Duration
+	var width int64
+	for k, v := range step.Options {
+		var err error
+		switch k {
+		case "frame":
+			frame, err = optionString(k, v)
+		case "at":
+			var t time.Duration
+			t, err = optionSeconds(k, v)
+			at = &t
+		case "width":
+			width, err = optionInt(k, v)
+		default:
+			err = fmt.Errorf("%w: unknown frame option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if at == nil && frame != "first" && frame != "last" && frame != "poster" {
+		return nil, fmt.Errorf("%w: unknown frame %q", ErrInvalidParameters, frame)
+	}
+	if width < 0 {
+		return nil, fmt.Errorf("%w: negative thumbnail width", ErrInvalidParameters)
+	}
+
+	video, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.providers.ffmpeg().newFrameSource(ctx, bytes.NewReader(video))
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	var out []byte
+	switch {
+	case at != nil:
+		out, err = src.at("frame", *at)
+	case frame == "first":
+		out, err = src.at("frame", 0)
+	case frame == "last":
+		out, err = src.last()
+	default:
+		out, err = src.poster(int(width))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Data: out, MIMEType: mediatype.PNG}, nil
+}
+
+// processVideoOperation trims, cuts, speeds up, reverses or loops the video
+// named by step.Video, see videoOperation for the options.
+func (s *workflowService) processVideoOperation(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Video == "" {
+		return nil, errors.New("missing video in step configuration")
+	}
+	op, err := videoOperation(step.FunctionType, step.Options)
+	if err != nil {
+		return nil, err
+	}
+	video, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.providers.ffmpeg().transformBytes(ctx, video, op)
+	if err != nil {
+		return nil, err
+	}
+	return s.videoArtifact(ctx, out), nil
+}
+
+// processCaptionVideo adds captions to the video named by step.Video. The
+// captions come from the "subtitles" option, naming SRT or WebVTT content,
+// a URL or an input, or from "captions", a list of maps with "start", "end"
+// and "text". Other options set the CaptionOptions: "mode", "font", "size",
+// "color", "outline_color", "outline", "position", "margin" and "language".
+func (s *workflowService) processCaptionVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Video == "" {
+		return nil, errors.New("missing video in step configuration")
+	}
+	var (
+		captions []Caption
+		opts     CaptionOptions
+	)
+	for k, v := range step.Options {
+		var err error
+		switch k {
+		case "subtitles":
+			var ref string
+			if ref, err = optionString(k, v); err == nil {
+				captions, err = s.loadSubtitles(ctx, resolveReference(ref, inputs, results))
+			}
+		case "captions":
+			captions, err = captionsFromOption(k, v)
+		case "mode":
+			var m string
+			m, err = optionString(k, v)
+			opts.Mode = CaptionMode(m)
+		case "language":
+			opts.Language, err = optionString(k, v)
+		default:
+			var ok bool
+			if ok, err = captionStyleFromOption(&opts.Style, k, v); !ok {
+				err = fmt.Errorf("%w: unknown caption option %s", ErrInvalidParameters, k)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(captions) == 0 {
+		return nil, errors.New("missing subtitles or captions in step options")
+	}
+
+	video, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.providers.ffmpeg().transformBytes(ctx, video, CaptionsOp(captions, opts))
+	if err != nil {
+		return nil, err
+	}
+	return s.videoArtifact(ctx, out), nil
+}
+
+// loadSubtitles parses the subtitles in ref, which is SRT or WebVTT text, a
+// URL or any file reference accepted by loadMedia.
+func (s *workflowService) loadSubtitles(ctx context.Context, ref any) ([]Caption, error) {
+	if text, ok := ref.(string); ok && !strings.HasPrefix(text, "http://") && !strings.HasPrefix(text, "https://") {
+		return ParseSubtitles([]byte(text))
+	}
+	data, err := s.loadMedia(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load subtitles")
+	}
+	return ParseSubtitles(data)
+}
+
+// processExportVideo reframes the video named by step.Video to the platform
+// "preset" (vertical, square or landscape, with fit, anchor and background)
+// and converts it to "format": mp4 (the default), gif or webm. GIF exports
+// take width, fps, start, duration, colors and play_once; WebM exports take
+// width and crf. HLS packages hold several files and are only available
+// through ExportHLS.
+func (s *workflowService) processExportVideo(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	if step.Video == "" {
+		return nil, errors.New("missing video in step configuration")
+	}
+	var (
+		format  = "mp4"
+		preset  *VideoPreset
+		popts   PresetOptions
+		gif     GIFOptions
+		webm    WebMOptions
+		formats = map[string][]string{
+			"mp4":  nil,
+			"gif":  {"width", "fps", "start", "duration", "colors", "play_once"},
+			"webm": {"width", "crf"},
+		}
+	)
+	if v, ok := step.Options["format"]; ok {
+		var err error
+		if format, err = optionString("format", v); err != nil {
+			return nil, err
+		}
+		if _, ok := formats[format]; !ok {
+			return nil, fmt.Errorf("%w: unknown export format %s", ErrInvalidParameters, format)
+		}
+	}
+	for k, v := range step.Options {
+		var (
+			err error
+			n   int64
+			str string
+		)
+		switch k {
+		case "format":
+			continue
+		case "preset":
+			if str, err = optionString(k, v); err == nil {
+				p, ok := LookupVideoPreset(str)
+				if !ok {
+					return nil, fmt.Errorf("%w: unknown video preset %s", ErrInvalidParameters, str)
+				}
+				preset = &p
+			}
+		case "fit":
+			str, err = optionString(k, v)
+			popts.Fit = ImageFit(str)
+		case "anchor":
+			str, err = optionString(k, v)
+			popts.Anchor = CropAnchor(str)
+		case "background":
+			if str, err = optionString(k, v); err == nil {
+				popts.Background, err = parseColor(str)
+			}
+		default:
+			if !slices.Contains(formats[format], k) {
+				return nil, fmt.Errorf("%w: unknown %s export option %s", ErrInvalidParameters, format, k)
 			}
-			return nil, fmt.Errorf("reference %s is not []byte or string", name)
-		}
-		if data, ok := inputs[name]; ok {
-			switch v := data.(type) {
-			case []byte:
-				return v, nil
-			case string:
-				return DownloadFileToBytes(v)
+			switch k {
+			case "width":
+				n, err = optionInt(k, v)
+				gif.Width, webm.Width = int(n), int(n)
+			case "fps":
+				gif.FPS, err = optionFloat(k, v)
+			case "start":
+				gif.Start, err = optionSeconds(k, v)
+			case "duration":
+				gif.Duration, err = optionSeconds(k, v)
+			case "colors":
+				n, err = optionInt(k, v)
+				gif.Colors = int(n)
+			case "play_once":
+				gif.PlayOnce, err = optionBool(k, v)
+			case "crf":
+				n, err = optionInt(k, v)
+				webm.CRF = int(n)
 			}
-			return nil, fmt.Errorf("reference %s is not []byte or string", name)
 		}
-		return nil, fmt.Errorf("reference %s not found", name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if preset == nil && format == "mp4" {
+		return nil, errors.New("export needs a preset or a format other than mp4")
 	}
 
-	vidBytes, err := getBytes(step.Video)
+	video, err := s.loadMedia(ctx, resolveReference(step.Video, inputs, results))
 	if err != nil {
 		return nil, err
 	}
+	if preset != nil {
+		if video, err = s.providers.ffmpeg().transformBytes(ctx, video, PresetOp(*preset, popts)); err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case "gif":
+		out, err := s.providers.ffmpeg().transformBytes(ctx, video, GIFOp(gif))
+		if err != nil {
+			return nil, err
+		}
+		return &Artifact{Data: out, MIMEType: mediatype.GIF}, nil
+	case "webm":
+		if video, err = s.providers.ffmpeg().transformBytes(ctx, video, WebMOp(webm)); err != nil {
+			return nil, err
+		}
+	}
+	return s.videoArtifact(ctx, video), nil
+}
 
-	audBytes, err := getBytes(step.Audio)
+// processRenderTimeline renders the Timeline described by step.Options:
+// "clips", "audio" and "texts" lists and the settings width, height, fps,
+// background, clip_volume and ducking. Each clip is a map with a "video" or
+// "image" reference and the optional keys start, end, duration (in seconds),
+// fit, mute, ken_burns and transition; audio tracks take the same keys as
+// the tracks of a mix_audio step and texts those of caption_video captions
+// plus their style.
+func (s *workflowService) processRenderTimeline(ctx context.Context, step WorkflowStep, inputs map[string]any, results map[string]any) (any, error) {
+	var tl Timeline
+	for k, v := range step.Options {
+		var (
+			err error
+			n   int64
+			str string
+		)
+		switch k {
+		case "clips":
+			tl.Clips, err = s.timelineClipsFromOption(ctx, k, v, inputs, results)
+		case "audio":
+			tl.Audio, err = s.audioTracksFromOption(ctx, k, v, inputs, results)
+		case "texts":
+			tl.Texts, err = textOverlaysFromOption(k, v)
+		case "width":
+			n, err = optionInt(k, v)
+			tl.Width = int(n)
+		case "height":
+			n, err = optionInt(k, v)
+			tl.Height = int(n)
+		case "fps":
+			tl.FPS, err = optionFloat(k, v)
+		case "background":
+			if str, err = optionString(k, v); err == nil {
+				tl.Background, err = parseColor(str)
+			}
+		case "clip_volume":
+			tl.ClipVolume, err = optionFloat(k, v)
+		case "ducking":
+			tl.Ducking, err = duckingFromOption(k, v)
+		default:
+			err = fmt.Errorf("%w: unknown timeline option %s", ErrInvalidParameters, k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(tl.Clips) == 0 {
+		return nil, errors.New("missing clips in step options")
+	}
+	out, err := s.providers.ffmpeg().renderTimeline(ctx, tl)
+	if err != nil {
+		return nil, err
+	}
+	return s.videoArtifact(ctx, out), nil
+}
+
+func (s *workflowService) timelineClipsFromOption(ctx context.Context, key string, v any, inputs, results map[string]any) ([]TimelineClip, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: option %s must be a list, got %T", ErrInvalidParameters, key, v)
+	}
+	clips := make([]TimelineClip, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: clip %d must be a map, got %T", ErrInvalidParameters, i+1, item)
+		}
+		c := &clips[i]
+		var video, image string
+		for k, v := range m {
+			var (
+				err error
+				str string
+			)
+			switch k {
+			case "video":
+				video, err = optionString(k, v)
+			case "image":
+				image, err = optionString(k, v)
+			case "start":
+				c.Start, err = optionSeconds(k, v)
+			case "end":
+				c.End, err = optionSeconds(k, v)
+			case "duration":
+				c.Duration, err = optionSeconds(k, v)
+			case "fit":
+				str, err = optionString(k, v)
+				c.Fit = ImageFit(str)
+			case "mute":
+				c.Mute, err = optionBool(k, v)
+			case "ken_burns":
+				c.KenBurns, err = kenBurnsFromOption(k, v)
+			case "transition":
+				c.Transition, err = transitionFromOption(k, v)
+			default:
+				err = fmt.Errorf("%w: unknown clip option %s", ErrInvalidParameters, k)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("clip %d: %w", i+1, err)
+			}
+		}
+		if (video == "") == (image == "") {
+			return nil, fmt.Errorf("%w: clip %d needs either a video or an image", ErrInvalidParameters, i+1)
+		}
+		ref, data := video, &c.Video
+		if image != "" {
+			ref, data = image, &c.Image
+		}
+		var err error
+		if *data, err = s.loadMedia(ctx, resolveReference(ref, inputs, results)); err != nil {
+			return nil, fmt.Errorf("clip %d: %w", i+1, err)
+		}
+	}
+	return clips, nil
+}
+
+// videoArtifact wraps a video made by a processing step. Its Media is
+// probed on a best effort basis and left nil when probing fails.
+func (s *workflowService) videoArtifact(ctx context.Context, data []byte) *Artifact {
+	art := &Artifact{Data: data, MIMEType: mediatype.Detect(data)}
+	if info, err := s.providers.ffmpeg().probeBytes(ctx, data); err == nil {
+		art.Media = info
+	}
+	return art
+}
+
+// loadMedia returns the content of a single image or video reference: bytes,
+// a URL, an ImageInput or an Artifact.
+func (s *workflowService) loadMedia(ctx context.Context, ref any) ([]byte, error) {
+	items, err := imageInputs(ref)
 	if err != nil {
 		return nil, err
 	}
+	if len(items) != 1 {
+		return nil, fmt.Errorf("expected a single file, got %d", len(items))
+	}
+	return s.providers.loadImage(ctx, items[0])
+}
 
-	return AddAudioToVideo(vidBytes, audBytes)
+// resolveReference returns the result of the step or the input called name,
+// in that order, or name itself so that literal URLs can be used directly.
+func resolveReference(name string, inputs map[string]any, results map[string]any) any {
+	if v, ok := results[name]; ok {
+		return v
+	}
+	if v, ok := inputs[name]; ok {
+		return v
+	}
+	return name
 }
 
 // interpolateVariables replaces placeholders in the template string with values from inputs and results.
@@ -290,11 +1006,22 @@ func (s *workflowService) interpolateVariables(template string, inputs map[strin
 
 // DownloadFileToBytes downloads the file from the given URL and returns its contents as a byte slice.
 func DownloadFileToBytes(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	return downloadFile(context.Background(), http.DefaultClient, url)
+}
+
+func downloadFile(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("download %s: %s", url, resp.Status)
+	}
 
 	return io.ReadAll(resp.Body)
 }
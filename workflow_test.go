@@ -4,24 +4,70 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
 func TestWorkflowGenerate(t *testing.T) {
-	svc := NewWorkflowService()
+	var requests []map[string]any
+	srv := fakeOpenAIServer(t, func(body map[string]any) {
+		requests = append(requests, body)
+	})
+	svc := NewWorkflowService(WithOpenAIKey("test"), WithOpenAIBaseURL(srv.URL))
 	wf := &Workflow{
 		Steps: []WorkflowStep{
-			{ID: "step1", FunctionType: FunctionTypeTextsToText, Prompt: "hello"},
-			{ID: "step2", FunctionType: FunctionTypeTextToImage, Prompt: "image"},
-			{ID: "step3", FunctionType: FunctionTypeTextAndImageToImage, Prompt: "edit"},
+			{ID: "step1", FunctionType: FunctionTypeTextsToText, Prompt: "a cat"},
+			{ID: "step2", FunctionType: FunctionTypeTextToImage, Provider: ProviderDallE3, Prompt: "${step1}", Options: map[string]any{"size": "1792x1024"}},
+			{ID: "step3", FunctionType: FunctionTypeTextAndImageToImage, Provider: ProviderGPTImage1, Prompt: "add a hat", Image: "step2"},
 		},
 	}
 	result, _, err := svc.Generate(context.Background(), wf, nil)
 	if err != nil {
 		t.Fatalf("Generate returned error: %v", err)
 	}
-	if result != "text_and_image_to_image result" {
-		t.Fatalf("unexpected result: %v", result)
+	img, ok := result.(*Artifact)
+	if !ok || img.Model != ProviderGPTImage1 || img.MIMEType != "image/png" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 provider requests, got %d", len(requests))
+	}
+	if requests[0]["prompt"] != "a cat" || requests[0]["size"] != "1792x1024" {
+		t.Errorf("unexpected generation request: %v", requests[0])
+	}
+	if requests[1]["prompt"] != "add a hat" || requests[1]["images"] != 1 {
+		t.Errorf("unexpected edit request: %v", requests[1])
+	}
+}
+
+func TestWorkflowImageStepsUseInputsAndReplicate(t *testing.T) {
+	gem := fakeGeminiServer(t)
+	rep := fakeReplicateServer(t, []string{"https://example.com/edited.png"}, func(input map[string]any) {
+		if input["image"] != "https://example.com/photo.png" {
+			t.Errorf("unexpected replicate input %v", input)
+		}
+	})
+	svc := NewWorkflowService(
+		WithGeminiKey("test"), WithGeminiBaseURL(gem.URL),
+		WithReplicateToken("test"), WithReplicateBaseURL(rep.URL),
+	)
+	wf := &Workflow{
+		Steps: []WorkflowStep{
+			{ID: "gen", FunctionType: FunctionTypeTextToImage, Provider: ProviderImagen3Generate002, Prompt: "a cat"},
+			{ID: "edit", FunctionType: FunctionTypeTextAndImageToImage, Provider: "owner/editor", Prompt: "add a hat", Image: "photo"},
+		},
+	}
+	result, _, err := svc.Generate(context.Background(), wf, map[string]any{"photo": "https://example.com/photo.png"})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if img, ok := result.(*Artifact); !ok || img.URL != "https://example.com/edited.png" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	wf.Steps[1].Image = ""
+	if _, _, err := svc.Generate(context.Background(), wf, nil); err == nil {
+		t.Fatal("expected error for missing image")
 	}
 }
 
@@ -36,7 +82,7 @@ func TestWorkflowGenerateVideo(t *testing.T) {
 	if os.Getenv("GOOGLE_CLOUD_LOCATION") == "" && os.Getenv("GOOGLE_CLOUD_REGION") == "" {
 		os.Setenv("GOOGLE_CLOUD_LOCATION", "us-central1")
 	}
-	svc := NewWorkflowService()
+	svc := NewWorkflowService(WithEnv())
 	wf := &Workflow{
 		Steps: []WorkflowStep{
 			{
@@ -131,7 +177,7 @@ func TestWorkflowGenerateAndMergeVideos(t *testing.T) {
 		t.Skip("ffmpeg not installed")
 	}
 
-	svc := NewWorkflowService()
+	svc := NewWorkflowService(WithEnv())
 	wf := &Workflow{
 		Steps: []WorkflowStep{
 			{
@@ -184,7 +230,7 @@ func TestWorkflowSingleImageClipsAndMerge(t *testing.T) {
 		t.Skip("ffmpeg not installed")
 	}
 
-	svc := NewWorkflowService()
+	svc := NewWorkflowService(WithEnv())
 	wf := &Workflow{
 		Steps: []WorkflowStep{
 			{
@@ -228,7 +274,7 @@ func TestWorkflowSeedanceSingleImageClipsAndMerge(t *testing.T) {
 		t.Skip("ffmpeg not installed")
 	}
 
-	svc := NewWorkflowService()
+	svc := NewWorkflowService(WithEnv())
 	wf := &Workflow{
 		Steps: []WorkflowStep{
 			{
@@ -266,6 +312,28 @@ func TestWorkflowSeedanceSingleImageClipsAndMerge(t *testing.T) {
 	}
 }
 
+func TestWorkflowTrimThenAddAudio(t *testing.T) {
+	f := fakeFFmpeg(t)
+	// Report an audio stream as well, so that the clip passes as audio.
+	f.ProbePath = filepath.Join(t.TempDir(), "ffprobe")
+	probe := `echo '{"streams":[{"codec_type":"video","codec_name":"h264","width":320,"height":240},{"codec_type":"audio","codec_name":"aac"}],"format":{"duration":"1.0"}}'`
+	if err := os.WriteFile(f.ProbePath, []byte("#!/bin/sh\n"+probe+"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewWorkflowService(WithFFmpeg(f))
+	wf := &Workflow{Steps: []WorkflowStep{
+		{ID: "cut", FunctionType: FunctionTypeTrimVideo, Video: "clip", Options: map[string]any{"end": 0.5}},
+		{ID: "add", FunctionType: FunctionTypeVideoAndAudioToVideo, Video: "cut", Audio: "music"},
+	}}
+	result, _, err := svc.Generate(context.Background(), wf, map[string]any{"clip": []byte("video"), "music": []byte("audio")})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if b, ok := result.([]byte); !ok || string(b) != "fake:-stream_loop" {
+		t.Fatalf("unexpected result %#v", result)
+	}
+}
+
 func TestWorkflowVideoAndAudioToVideo(t *testing.T) {
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		t.Skip("ffmpeg not installed")